@@ -0,0 +1,274 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	caCertFile = "ca.pem"
+	caKeyFile  = "ca-key.pem"
+
+	caValidity   = 10 * 365 * 24 * time.Hour
+	leafValidity = 365 * 24 * time.Hour
+	leafRenewal  = 24 * time.Hour
+)
+
+// CA is piko's local root certificate authority. It is name-constrained to
+// .localhost so trusting it cannot be abused to intercept real domains.
+type CA struct {
+	Dir  string
+	Cert *x509.Certificate
+	key  crypto.Signer
+
+	mu     sync.Mutex
+	leaves map[string]*tls.Certificate
+}
+
+func DefaultDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(home, ".piko", "ca"), nil
+}
+
+func (ca *CA) CertPath() string {
+	return filepath.Join(ca.Dir, caCertFile)
+}
+
+func Exists(dir string) bool {
+	_, certErr := os.Stat(filepath.Join(dir, caCertFile))
+	_, keyErr := os.Stat(filepath.Join(dir, caKeyFile))
+	return certErr == nil && keyErr == nil
+}
+
+// LoadOrCreate loads the CA from dir, generating it first if it doesn't exist.
+// The returned bool reports whether a new CA was created.
+func LoadOrCreate(dir string) (*CA, bool, error) {
+	if Exists(dir) {
+		ca, err := Load(dir)
+		return ca, false, err
+	}
+	ca, err := Create(dir)
+	return ca, err == nil, err
+}
+
+func Load(dir string) (*CA, error) {
+	certPEM, err := os.ReadFile(filepath.Join(dir, caCertFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+	keyPEM, err := os.ReadFile(filepath.Join(dir, caKeyFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA key: %w", err)
+	}
+
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, errors.New("invalid CA certificate PEM")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, errors.New("invalid CA key PEM")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA key: %w", err)
+	}
+	key, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported CA key type")
+	}
+
+	return &CA{Dir: dir, Cert: cert, key: key, leaves: make(map[string]*tls.Certificate)}, nil
+}
+
+func Create(dir string) (*CA, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create CA directory: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate CA key: %w", err)
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"piko local development CA"},
+			CommonName:   fmt.Sprintf("piko local CA (%s)", hostname),
+		},
+		NotBefore:                   now.Add(-time.Hour),
+		NotAfter:                    now.Add(caValidity),
+		KeyUsage:                    x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid:       true,
+		IsCA:                        true,
+		MaxPathLenZero:              true,
+		PermittedDNSDomainsCritical: true,
+		PermittedDNSDomains:         []string{"localhost"},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode CA key: %w", err)
+	}
+
+	if err := writePEM(filepath.Join(dir, caKeyFile), "PRIVATE KEY", keyDER, 0600); err != nil {
+		return nil, err
+	}
+	if err := writePEM(filepath.Join(dir, caCertFile), "CERTIFICATE", der, 0644); err != nil {
+		return nil, err
+	}
+
+	return &CA{Dir: dir, Cert: cert, key: key, leaves: make(map[string]*tls.Certificate)}, nil
+}
+
+// Check reports problems with the CA certificate: expiry and whether the
+// system trust store accepts certificates it issues.
+func (ca *CA) Check() (trusted bool, err error) {
+	now := time.Now()
+	if now.After(ca.Cert.NotAfter) {
+		return false, fmt.Errorf("CA certificate expired on %s", ca.Cert.NotAfter.Format("2006-01-02"))
+	}
+	if now.Before(ca.Cert.NotBefore) {
+		return false, fmt.Errorf("CA certificate is not valid until %s", ca.Cert.NotBefore.Format("2006-01-02"))
+	}
+
+	leaf, err := ca.issue("piko-doctor.localhost")
+	if err != nil {
+		return false, err
+	}
+
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		return false, nil
+	}
+	_, verifyErr := leaf.Leaf.Verify(x509.VerifyOptions{
+		DNSName: "check.piko-doctor.localhost",
+		Roots:   roots,
+	})
+	return verifyErr == nil, nil
+}
+
+// GetCertificate issues (and caches) a wildcard certificate covering the
+// requested name's parent domain, e.g. *.<env>.<project>.localhost for
+// web.<env>.<project>.localhost.
+func (ca *CA) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if name == "" || !strings.HasSuffix(name, ".localhost") {
+		return nil, fmt.Errorf("piko only issues certificates for *.localhost (got %q)", hello.ServerName)
+	}
+
+	_, parent, ok := strings.Cut(name, ".")
+	if !ok || parent == "localhost" {
+		parent = name
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if cert, ok := ca.leaves[parent]; ok && time.Until(cert.Leaf.NotAfter) > leafRenewal {
+		return cert, nil
+	}
+
+	cert, err := ca.issue(parent)
+	if err != nil {
+		return nil, err
+	}
+	ca.leaves[parent] = cert
+	return cert, nil
+}
+
+func (ca *CA) issue(domain string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"piko"}, CommonName: "*." + domain},
+		DNSNames:     []string{"*." + domain, domain},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(leafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if template.NotAfter.After(ca.Cert.NotAfter) {
+		template.NotAfter = ca.Cert.NotAfter
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue certificate for %s: %w", domain, err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der, ca.Cert.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+func randomSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	return serial, nil
+}
+
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, perm); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
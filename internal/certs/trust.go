@@ -0,0 +1,32 @@
+package certs
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+func TrustInstructions(certPath string) string {
+	var b strings.Builder
+
+	switch runtime.GOOS {
+	case "darwin":
+		fmt.Fprintf(&b, "  macOS keychain:\n")
+		fmt.Fprintf(&b, "    sudo security add-trusted-cert -d -r trustRoot -k /Library/Keychains/System.keychain %s\n", certPath)
+	case "linux":
+		fmt.Fprintf(&b, "  Debian/Ubuntu:\n")
+		fmt.Fprintf(&b, "    sudo cp %s /usr/local/share/ca-certificates/piko.crt && sudo update-ca-certificates\n", certPath)
+		fmt.Fprintf(&b, "  Fedora/Arch:\n")
+		fmt.Fprintf(&b, "    sudo trust anchor --store %s\n", certPath)
+	case "windows":
+		fmt.Fprintf(&b, "  Windows (admin prompt):\n")
+		fmt.Fprintf(&b, "    certutil -addstore -f ROOT %s\n", certPath)
+	}
+
+	fmt.Fprintf(&b, "  Firefox and Chrome on Linux (NSS):\n")
+	fmt.Fprintf(&b, "    certutil -d sql:$HOME/.pki/nssdb -A -t C,, -n piko -i %s\n", certPath)
+	fmt.Fprintf(&b, "  Node.js:\n")
+	fmt.Fprintf(&b, "    export NODE_EXTRA_CA_CERTS=%s\n", certPath)
+
+	return b.String()
+}
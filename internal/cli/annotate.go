@@ -0,0 +1,156 @@
+package cli
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/gwuah/piko/internal/state"
+	"github.com/spf13/cobra"
+)
+
+var annotateCmd = &cobra.Command{
+	Use:   "annotate [name]",
+	Short: "Show or change an environment's description, issue, owner, tags and labels",
+	Long: `Show or change what an environment is for.
+
+  piko env annotate auth --description "OAuth login flow" --issue ENG-142
+  piko env annotate auth --tag agent --label model=large
+  piko env annotate auth --untag agent --unlabel model
+
+Without flags, prints the environment's metadata.`,
+	Args: cobra.RangeArgs(0, 1),
+	RunE: runAnnotate,
+}
+
+var (
+	annotateDescription string
+	annotateIssue       string
+	annotateOwner       string
+	annotateTags        []string
+	annotateUntags      []string
+	annotateLabels      []string
+	annotateUnlabels    []string
+)
+
+func init() {
+	envCmd.AddCommand(annotateCmd)
+	annotateCmd.Flags().StringVar(&annotateDescription, "description", "", "What the environment is for")
+	annotateCmd.Flags().StringVar(&annotateIssue, "issue", "", "Issue or ticket reference")
+	annotateCmd.Flags().StringVar(&annotateOwner, "owner", "", "Who the environment belongs to")
+	annotateCmd.Flags().StringSliceVar(&annotateTags, "tag", nil, "Add tags")
+	annotateCmd.Flags().StringSliceVar(&annotateUntags, "untag", nil, "Remove tags")
+	annotateCmd.Flags().StringArrayVar(&annotateLabels, "label", nil, "Set a label (key=value), repeatable")
+	annotateCmd.Flags().StringSliceVar(&annotateUnlabels, "unlabel", nil, "Remove labels by key")
+}
+
+func runAnnotate(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	name, err := GetEnvNameOrSelect(args)
+	if err != nil {
+		return err
+	}
+
+	resolved, err := ResolveEnvironmentGlobally(name)
+	if err != nil {
+		return err
+	}
+	defer resolved.Close()
+
+	metadata, err := resolved.Ctx.DB.GetEnvironmentMetadata(resolved.Environment.ID)
+	if err != nil {
+		return err
+	}
+
+	flags := cmd.Flags()
+	if flags.NFlag() == 0 {
+		printMetadata(metadata)
+		return nil
+	}
+
+	labels, err := parseLabels(annotateLabels)
+	if err != nil {
+		return err
+	}
+
+	if flags.Changed("description") {
+		metadata.Description = annotateDescription
+	}
+	if flags.Changed("issue") {
+		metadata.Issue = annotateIssue
+	}
+	if flags.Changed("owner") {
+		metadata.Owner = annotateOwner
+	}
+	for _, tag := range annotateTags {
+		if !slices.Contains(metadata.Tags, tag) {
+			metadata.Tags = append(metadata.Tags, tag)
+		}
+	}
+	metadata.Tags = slices.DeleteFunc(metadata.Tags, func(tag string) bool {
+		return slices.Contains(annotateUntags, tag)
+	})
+	for key, value := range labels {
+		if metadata.Labels == nil {
+			metadata.Labels = make(map[string]string)
+		}
+		metadata.Labels[key] = value
+	}
+	for _, key := range annotateUnlabels {
+		delete(metadata.Labels, key)
+	}
+
+	api := NewAPIClient()
+	if api.IsServerRunning() {
+		err = api.SetMetadata(resolved.Project.ID, resolved.Environment.Name, metadata)
+	} else {
+		err = resolved.Ctx.DB.SetEnvironmentMetadata(resolved.Environment.ID, metadata)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("✓ Updated %s\n", resolved.Environment.Name)
+	return nil
+}
+
+// parseLabels parses key=value pairs.
+func parseLabels(values []string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, v := range values {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid label %q (use key=value)", v)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+func printMetadata(m *state.Metadata) {
+	if m.Description != "" {
+		fmt.Printf("Description: %s\n", m.Description)
+	}
+	if m.Issue != "" {
+		fmt.Printf("Issue:       %s\n", m.Issue)
+	}
+	if m.Owner != "" {
+		fmt.Printf("Owner:       %s\n", m.Owner)
+	}
+	if len(m.Tags) > 0 {
+		fmt.Printf("Tags:        %s\n", strings.Join(m.Tags, ", "))
+	}
+	if len(m.Labels) > 0 {
+		fmt.Printf("Labels:      %s\n", formatLabels(m.Labels))
+	}
+}
+
+func formatLabels(labels map[string]string) string {
+	var pairs []string
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
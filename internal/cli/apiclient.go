@@ -8,6 +8,8 @@ import (
 	"net/url"
 
 	"github.com/gwuah/piko/internal/httpclient"
+	"github.com/gwuah/piko/internal/state"
+	"github.com/gwuah/piko/internal/supervisor"
 )
 
 type APIClient struct {
@@ -29,20 +31,36 @@ type apiResponse struct {
 	Error   string `json:"error,omitempty"`
 }
 
-func (c *APIClient) CreateEnvironment(projectID int64, name, branch string) error {
-	resp, err := c.client.Post(
-		fmt.Sprintf("/api/projects/%d/environments", projectID),
-		map[string]string{"name": name, "branch": branch},
-		nil,
-	)
+// Job is a background operation running on the server.
+type Job struct {
+	ID          int64  `json:"id"`
+	Kind        string `json:"kind"`
+	Project     string `json:"project"`
+	Environment string `json:"environment"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+}
+
+type CreateEnvironmentRequest struct {
+	Name     string          `json:"name"`
+	Branch   string          `json:"branch"`
+	Paths    []string        `json:"paths,omitempty"`
+	Metadata *state.Metadata `json:"metadata,omitempty"`
+	Wait     bool            `json:"wait,omitempty"`
+}
+
+// CreateEnvironment submits a create job.
+func (c *APIClient) CreateEnvironment(projectID int64, req CreateEnvironmentRequest) (*Job, error) {
+	resp, err := c.client.Post(fmt.Sprintf("/api/projects/%d/environments", projectID), req, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
-	return c.parseResponse(resp)
+	return c.parseJob(resp)
 }
 
-func (c *APIClient) DestroyEnvironment(projectID int64, name string, removeVolumes, deleteBranch bool) error {
+// DestroyEnvironment submits a destroy job.
+func (c *APIClient) DestroyEnvironment(projectID int64, name string, removeVolumes, deleteBranch, wait bool) (*Job, error) {
 	params := url.Values{}
 	if !removeVolumes {
 		params.Set("keep-volumes", "true")
@@ -50,10 +68,46 @@ func (c *APIClient) DestroyEnvironment(projectID int64, name string, removeVolum
 	if deleteBranch {
 		params.Set("force", "true")
 	}
+	if wait {
+		params.Set("wait", "true")
+	}
 	resp, err := c.client.Delete(
 		fmt.Sprintf("/api/projects/%d/environments/%s", projectID, name),
 		params,
 	)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return c.parseJob(resp)
+}
+
+func (c *APIClient) CancelJob(id int64) error {
+	resp, err := c.client.Post(fmt.Sprintf("/api/jobs/%d/cancel", id), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return c.parseResponse(resp)
+}
+
+func (c *APIClient) parseJob(resp *http.Response) (*Job, error) {
+	if resp.StatusCode >= 400 {
+		return nil, c.parseResponse(resp)
+	}
+	var job Job
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		return nil, fmt.Errorf("failed to decode job: %w", err)
+	}
+	return &job, nil
+}
+
+func (c *APIClient) SetMetadata(projectID int64, name string, metadata *state.Metadata) error {
+	resp, err := c.client.Put(
+		fmt.Sprintf("/api/projects/%d/environments/%s/metadata", projectID, name),
+		metadata,
+		nil,
+	)
 	if err != nil {
 		return err
 	}
@@ -61,6 +115,20 @@ func (c *APIClient) DestroyEnvironment(projectID int64, name string, removeVolum
 	return c.parseResponse(resp)
 }
 
+// Rename submits a rename job.
+func (c *APIClient) Rename(projectID int64, name, newName string, wait bool) (*Job, error) {
+	resp, err := c.client.Post(
+		fmt.Sprintf("/api/projects/%d/environments/%s/rename", projectID, name),
+		map[string]any{"name": newName, "wait": wait},
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return c.parseJob(resp)
+}
+
 func (c *APIClient) Up(projectID int64, name string) error {
 	resp, err := c.client.Post(
 		fmt.Sprintf("/api/projects/%d/environments/%s/up", projectID, name),
@@ -105,6 +173,53 @@ func (c *APIClient) Restart(projectID int64, name, service string) error {
 	return c.parseResponse(resp)
 }
 
+func (c *APIClient) Processes(projectID int64, name string) ([]supervisor.Status, error) {
+	resp, err := c.client.Get(
+		fmt.Sprintf("/api/projects/%d/environments/%s/processes", projectID, name),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return nil, c.parseResponse(resp)
+	}
+
+	var statuses []supervisor.Status
+	if err := json.NewDecoder(resp.Body).Decode(&statuses); err != nil {
+		return nil, fmt.Errorf("failed to decode processes: %w", err)
+	}
+	return statuses, nil
+}
+
+func (c *APIClient) StartProcess(projectID int64, name, process string) error {
+	return c.processAction(projectID, name, "start", process)
+}
+
+func (c *APIClient) StopProcess(projectID int64, name, process string) error {
+	return c.processAction(projectID, name, "stop", process)
+}
+
+func (c *APIClient) processAction(projectID int64, name, action, process string) error {
+	var params url.Values
+	if process != "" {
+		params = url.Values{}
+		params.Set("process", process)
+	}
+	resp, err := c.client.Post(
+		fmt.Sprintf("/api/projects/%d/environments/%s/processes/%s", projectID, name, action),
+		nil,
+		params,
+	)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return c.parseResponse(resp)
+}
+
 func (c *APIClient) parseResponse(resp *http.Response) error {
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/gwuah/piko/internal/certs"
+	"github.com/spf13/cobra"
+)
+
+var caCmd = &cobra.Command{
+	Use:   "ca",
+	Short: "Manage the local certificate authority used for HTTPS",
+}
+
+var caInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Create the local CA if it doesn't exist",
+	RunE:  runCAInit,
+}
+
+var caTrustCmd = &cobra.Command{
+	Use:   "trust",
+	Short: "Show how to add the local CA to trust stores",
+	RunE:  runCATrust,
+}
+
+var caPathCmd = &cobra.Command{
+	Use:   "path",
+	Short: "Print the path of the CA certificate",
+	RunE:  runCAPath,
+}
+
+func init() {
+	rootCmd.AddCommand(caCmd)
+	caCmd.AddCommand(caInitCmd)
+	caCmd.AddCommand(caTrustCmd)
+	caCmd.AddCommand(caPathCmd)
+}
+
+func runCAInit(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	dir, err := certs.DefaultDir()
+	if err != nil {
+		return err
+	}
+	ca, created, err := certs.LoadOrCreate(dir)
+	if err != nil {
+		return err
+	}
+
+	if created {
+		fmt.Printf("✓ Created local CA at %s\n", ca.CertPath())
+	} else {
+		fmt.Printf("✓ Local CA already exists at %s\n", ca.CertPath())
+	}
+	fmt.Printf("  Valid until %s, only for *.localhost names\n", ca.Cert.NotAfter.Format("2006-01-02"))
+	fmt.Println()
+	fmt.Println("Trust it to use HTTPS without browser warnings:")
+	fmt.Print(certs.TrustInstructions(ca.CertPath()))
+	return nil
+}
+
+func runCATrust(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	ca, err := loadCA()
+	if err != nil {
+		return err
+	}
+	fmt.Print(certs.TrustInstructions(ca.CertPath()))
+	return nil
+}
+
+func runCAPath(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	ca, err := loadCA()
+	if err != nil {
+		return err
+	}
+	fmt.Println(ca.CertPath())
+	return nil
+}
+
+func loadCA() (*certs.CA, error) {
+	dir, err := certs.DefaultDir()
+	if err != nil {
+		return nil, err
+	}
+	if !certs.Exists(dir) {
+		return nil, fmt.Errorf("local CA not found (run: piko ca init)")
+	}
+	return certs.Load(dir)
+}
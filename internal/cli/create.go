@@ -6,6 +6,7 @@ import (
 	"os/exec"
 	"strings"
 
+	"github.com/gwuah/piko/internal/httpclient"
 	"github.com/gwuah/piko/internal/operations"
 	"github.com/gwuah/piko/internal/state"
 	"github.com/gwuah/piko/internal/tmux"
@@ -24,22 +25,48 @@ var createCmd = &cobra.Command{
 var (
 	createBranch   string
 	createNoAttach bool
+	createPaths    []string
+	createWait     bool
+
+	createDescription string
+	createIssue       string
+	createOwner       string
+	createTags        []string
+	createLabels      []string
 )
 
 func init() {
 	envCmd.AddCommand(createCmd)
 	createCmd.Flags().StringVar(&createBranch, "branch", "", "Base branch to create the new branch from")
 	createCmd.Flags().BoolVar(&createNoAttach, "no-attach", false, "Don't attach to tmux session after creation")
+	createCmd.Flags().BoolVar(&createWait, "wait", false, "Wait for other operations on the environment to finish instead of failing")
+	createCmd.Flags().StringSliceVar(&createPaths, "paths", nil, "Sparse checkout of these directories only (overrides sparse in .piko.yml)")
+	createCmd.Flags().StringVar(&createDescription, "description", "", "What the environment is for")
+	createCmd.Flags().StringVar(&createIssue, "issue", "", "Issue or ticket reference")
+	createCmd.Flags().StringVar(&createOwner, "owner", "", "Who the environment belongs to (default: current user)")
+	createCmd.Flags().StringSliceVar(&createTags, "tag", nil, "Tags")
+	createCmd.Flags().StringArrayVar(&createLabels, "label", nil, "Label (key=value), repeatable")
 }
 
 func runCreate(cmd *cobra.Command, args []string) error {
 	cmd.SilenceUsage = true
 	arg := args[0]
 
+	labels, err := parseLabels(createLabels)
+	if err != nil {
+		return err
+	}
+	metadata := &state.Metadata{
+		Description: createDescription,
+		Issue:       createIssue,
+		Owner:       createOwner,
+		Tags:        createTags,
+		Labels:      labels,
+	}
+
 	var db *state.DB
 	var project *state.Project
 	var name string
-	var err error
 
 	if strings.Contains(arg, "/") {
 		parts := strings.SplitN(arg, "/", 2)
@@ -76,24 +103,47 @@ func runCreate(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	opCtx, stop := interruptContext()
+	defer stop()
+
+	if err := waitForLocks(opCtx, createWait, db, project, name); err != nil {
+		return err
+	}
+
 	api := NewAPIClient()
 	if api.IsServerRunning() {
-		streamClient := NewStreamClient()
-		if err := streamClient.CreateEnvironmentStream(project.ID, name, createBranch); err == nil {
+		stop()
+		job, err := api.CreateEnvironment(project.ID, CreateEnvironmentRequest{
+			Name:     name,
+			Branch:   createBranch,
+			Paths:    createPaths,
+			Metadata: metadata,
+			Wait:     createWait,
+		})
+		if err == nil {
+			if err := NewStreamClient().FollowJob(job.ID, 0, true); err != nil {
+				return err
+			}
 			sessionName := tmux.SessionName(project.Name, name)
 			if !createNoAttach && tmux.SessionExists(sessionName) {
 				return tmux.Attach(sessionName)
 			}
 			return nil
 		}
+		if !httpclient.IsServerUnavailable(err) {
+			return err
+		}
 	}
 
-	result, err := operations.CreateEnvironment(operations.CreateEnvironmentOptions{
-		DB:      db,
-		Project: project,
-		Name:    name,
-		Branch:  createBranch,
-		Logger:  &operations.StdoutLogger{},
+	result, err := operations.CreateEnvironment(opCtx, operations.CreateEnvironmentOptions{
+		DB:       db,
+		Project:  project,
+		Name:     name,
+		Branch:   createBranch,
+		Paths:    createPaths,
+		Metadata: metadata,
+		Wait:     createWait,
+		Logger:   &operations.StdoutLogger{},
 	})
 	if err != nil {
 		return err
@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/gwuah/piko/internal/state"
+	"github.com/spf13/cobra"
+)
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Manage the central state database",
+	Long: `Manage the central state database (~/.piko/state.db).
+
+Schema migrations are applied automatically when piko opens the database;
+'piko db migrate' applies them explicitly. A database migrated by a newer
+piko is never modified.`,
+}
+
+var dbStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the schema version and migrations",
+	Args:  cobra.NoArgs,
+	RunE:  runDBStatus,
+}
+
+var dbMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Apply pending schema migrations",
+	Args:  cobra.NoArgs,
+	RunE:  runDBMigrate,
+}
+
+func init() {
+	rootCmd.AddCommand(dbCmd)
+	dbCmd.AddCommand(dbStatusCmd)
+	dbCmd.AddCommand(dbMigrateCmd)
+}
+
+func runDBStatus(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	path, err := state.CentralDBPath()
+	if err != nil {
+		return err
+	}
+	db, err := state.Open(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	statuses, err := db.MigrationStatuses()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Database:       %s\n", path)
+	fmt.Printf("Schema version: %d (latest %d)\n", version, state.LatestSchemaVersion())
+	if version > state.LatestSchemaVersion() {
+		fmt.Println("The database was migrated by a newer piko; upgrade piko to use it.")
+	}
+	fmt.Println()
+
+	table := NewTable("VERSION", "DESCRIPTION", "APPLIED")
+	for _, s := range statuses {
+		applied := "pending"
+		if s.AppliedAt.Valid {
+			applied = formatAge(s.AppliedAt.Time)
+		}
+		table.Row(fmt.Sprint(s.Version), s.Description, applied)
+	}
+	table.Flush()
+	return nil
+}
+
+func runDBMigrate(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	db, err := state.OpenCentral()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	applied, err := db.Migrate()
+	for _, m := range applied {
+		fmt.Printf("✓ Applied %d: %s\n", m.Version, m.Description)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Printf("Schema is up to date (version %d)\n", state.LatestSchemaVersion())
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"github.com/gwuah/piko/internal/httpclient"
 	"github.com/gwuah/piko/internal/operations"
 	"github.com/spf13/cobra"
 )
@@ -16,12 +17,14 @@ var destroyCmd = &cobra.Command{
 var (
 	keepVolumes  bool
 	forceDestroy bool
+	destroyWait  bool
 )
 
 func init() {
 	envCmd.AddCommand(destroyCmd)
 	destroyCmd.Flags().BoolVar(&keepVolumes, "keep-volumes", false, "Keep Docker volumes instead of removing them")
 	destroyCmd.Flags().BoolVarP(&forceDestroy, "force", "f", false, "Also delete the git branch")
+	destroyCmd.Flags().BoolVar(&destroyWait, "wait", false, "Wait for other operations on the environment to finish instead of failing")
 }
 
 func runDestroyWithSelection(cmd *cobra.Command, args []string) error {
@@ -40,20 +43,36 @@ func runDestroy(name string) error {
 	}
 	defer resolved.Close()
 
+	opCtx, stop := interruptContext()
+	defer stop()
+
+	if err := waitForLocks(opCtx, destroyWait, resolved.Ctx.DB, resolved.Project, resolved.Environment.Name); err != nil {
+		return err
+	}
+
 	api := NewAPIClient()
 	if api.IsServerRunning() {
-		streamClient := NewStreamClient()
-		if err := streamClient.DestroyEnvironmentStream(resolved.Project.ID, resolved.Environment.Name, !keepVolumes, forceDestroy); err == nil {
-			return nil
+		stop()
+		job, err := api.DestroyEnvironment(resolved.Project.ID, resolved.Environment.Name, !keepVolumes, forceDestroy, destroyWait)
+		if err == nil {
+			return NewStreamClient().FollowJob(job.ID, 0, true)
+		}
+		if !httpclient.IsServerUnavailable(err) {
+			return err
 		}
 	}
 
-	return operations.DestroyEnvironment(operations.DestroyEnvironmentOptions{
+	if _, err := resolved.Ctx.DB.Backup("destroy"); err != nil {
+		return err
+	}
+	return operations.DestroyEnvironment(opCtx, operations.DestroyEnvironmentOptions{
 		DB:            resolved.Ctx.DB,
 		Project:       resolved.Project,
 		Environment:   resolved.Environment,
 		RemoveVolumes: !keepVolumes,
 		DeleteBranch:  forceDestroy,
+		Wait:          destroyWait,
 		Logger:        &operations.StdoutLogger{},
+		StopProcesses: serverProcessStopper(),
 	})
 }
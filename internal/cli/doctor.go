@@ -9,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/gwuah/piko/internal/certs"
 	"github.com/gwuah/piko/internal/httpclient"
 	"github.com/spf13/cobra"
 )
@@ -77,6 +78,14 @@ func runDoctor(cmd *cobra.Command, args []string) error {
 		fmt.Printf("  %s✗%s piko server: not running (start with: piko server)\n", colorRed, colorReset)
 	}
 
+	fmt.Println()
+	fmt.Println("Projects:")
+	checkProjectLocations()
+
+	fmt.Println()
+	fmt.Println("Certificates:")
+	checkLocalCA()
+
 	fmt.Println()
 	fmt.Println("Hooks:")
 	checkClaudeHooksForEnvironments()
@@ -91,6 +100,63 @@ func runDoctor(cmd *cobra.Command, args []string) error {
 
 const hookLogPath = "/tmp/piko-hook.log"
 
+func checkProjectLocations() {
+	ctx, err := NewContextWithoutProject()
+	if err != nil {
+		fmt.Printf("  %s✗%s failed to open database: %v\n", colorRed, colorReset, err)
+		return
+	}
+	defer ctx.Close()
+
+	projects, err := ctx.DB.ListProjects()
+	if err != nil {
+		fmt.Printf("  %s✗%s failed to list projects: %v\n", colorRed, colorReset, err)
+		return
+	}
+	if len(projects) == 0 {
+		fmt.Println("  no projects registered")
+		return
+	}
+
+	for _, p := range projects {
+		if _, err := os.Stat(p.RootPath); os.IsNotExist(err) {
+			fmt.Printf("  %s✗%s %s: %s not found (moved? run: piko project move <new-path> --project %s)\n", colorRed, colorReset, p.Name, p.RootPath, p.Name)
+			continue
+		}
+		fmt.Printf("  %s✓%s %s: %s\n", colorGreen, colorReset, p.Name, p.RootPath)
+	}
+}
+
+func checkLocalCA() {
+	dir, err := certs.DefaultDir()
+	if err != nil {
+		fmt.Printf("  %s✗%s local CA: %v\n", colorRed, colorReset, err)
+		return
+	}
+	if !certs.Exists(dir) {
+		fmt.Printf("  - local CA: not created (HTTPS proxy creates it on first start, or run: piko ca init)\n")
+		return
+	}
+
+	ca, err := certs.Load(dir)
+	if err != nil {
+		fmt.Printf("  %s✗%s local CA: %v\n", colorRed, colorReset, err)
+		return
+	}
+
+	trusted, err := ca.Check()
+	if err != nil {
+		fmt.Printf("  %s✗%s local CA: %v (remove %s and run: piko ca init)\n", colorRed, colorReset, err, dir)
+		return
+	}
+	fmt.Printf("  %s✓%s local CA: valid until %s\n", colorGreen, colorReset, ca.Cert.NotAfter.Format("2006-01-02"))
+	if trusted {
+		fmt.Printf("  %s✓%s trust store: piko CA is trusted\n", colorGreen, colorReset)
+	} else {
+		fmt.Printf("  %s!%s trust store: piko CA is not trusted (see: piko ca trust)\n", colorYellow, colorReset)
+	}
+}
+
 const (
 	colorReset  = "\033[0m"
 	colorRed    = "\033[31m"
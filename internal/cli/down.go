@@ -13,8 +13,11 @@ var downCmd = &cobra.Command{
 	Annotations: Requires(ToolDocker),
 }
 
+var downWait bool
+
 func init() {
 	envCmd.AddCommand(downCmd)
+	downCmd.Flags().BoolVar(&downWait, "wait", false, "Wait for other operations on the environment to finish instead of failing")
 }
 
 func runDown(cmd *cobra.Command, args []string) error {
@@ -30,17 +33,26 @@ func runDown(cmd *cobra.Command, args []string) error {
 	}
 	defer resolved.Close()
 
+	opCtx, stop := interruptContext()
+	defer stop()
+
+	if err := waitForLocks(opCtx, downWait, resolved.Ctx.DB, resolved.Project, resolved.Environment.Name); err != nil {
+		return err
+	}
+
 	api := NewAPIClient()
 	if api.IsServerRunning() {
+		stop()
 		if err := api.Down(resolved.Project.ID, resolved.Environment.Name); err == nil {
 			return nil
 		}
 	}
 
-	return operations.DownEnvironment(operations.DownEnvironmentOptions{
+	return operations.DownEnvironment(opCtx, operations.DownEnvironmentOptions{
 		DB:          resolved.Ctx.DB,
 		Project:     resolved.Project,
 		Environment: resolved.Environment,
+		Wait:        downWait,
 		Logger:      &operations.StdoutLogger{},
 	})
 }
@@ -8,6 +8,7 @@ import (
 
 	"github.com/gwuah/piko/internal/docker"
 	"github.com/gwuah/piko/internal/env"
+	"github.com/gwuah/piko/internal/operations"
 	"github.com/gwuah/piko/internal/ports"
 	"github.com/spf13/cobra"
 )
@@ -52,6 +53,18 @@ func runVars(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to discover ports: %w", err)
 	}
 
+	reserved, err := operations.ListEnvironmentPorts(resolved.Ctx.DB, resolved.Environment)
+	if err != nil {
+		return fmt.Errorf("failed to list ports: %w", err)
+	}
+	allocations = append(allocations, reserved...)
+
+	links, err := operations.ResolveLinks(resolved.Ctx.DB, resolved.Project, resolved.Environment)
+	if err != nil {
+		return err
+	}
+	allocations = operations.LinkAllocations(allocations, links)
+
 	pikoEnv := env.Build(resolved.Project, resolved.Environment, allocations)
 
 	if varsJSON {
@@ -57,6 +57,7 @@ func executeInContainer(name, service string, command []string) error {
 		return err
 	}
 	defer resolved.Close()
+	resolved.Touch()
 
 	status := docker.GetProjectStatus(resolved.ComposeDir, resolved.Environment.DockerProject)
 	if status != docker.StatusRunning {
@@ -1,12 +1,18 @@
 package cli
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
 
+	"github.com/gwuah/piko/internal/operations"
 	"github.com/gwuah/piko/internal/state"
 )
 
@@ -82,6 +88,13 @@ func ResolveEnvironmentGlobally(name string) (*ResolvedEnvironment, error) {
 	}, nil
 }
 
+// Touch records activity on the environment, postponing its idle
+// hibernation and expiry. Commands that use the environment call it; ones
+// that only look at it don't.
+func (r *ResolvedEnvironment) Touch() {
+	r.Ctx.DB.TouchEnvironment(r.Environment.ID)
+}
+
 func RequireDockerGlobally(name string) (*ResolvedEnvironment, error) {
 	resolved, err := ResolveEnvironmentGlobally(name)
 	if err != nil {
@@ -158,3 +171,65 @@ func SelectEnvironment() (string, error) {
 
 	return selected, nil
 }
+
+func Confirm(prompt string) bool {
+	fmt.Printf("%s [y/N] ", prompt)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// waitForLocks blocks, with --wait, until no other operation holds the named
+// environments, or the whole project when no names are given.
+func waitForLocks(ctx context.Context, wait bool, db *state.DB, project *state.Project, names ...string) error {
+	if !wait {
+		return nil
+	}
+	if len(names) == 0 {
+		names = []string{""}
+	}
+	for _, name := range names {
+		if err := operations.WaitForEnvironment(ctx, db, project, name, &operations.StdoutLogger{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// interruptContext returns a context that is canceled on the first Ctrl-C or
+// SIGTERM, so the running operation stops its commands and rolls back. A
+// second Ctrl-C exits at once, skipping the rollback. stop releases the
+// signals; it may be called more than once.
+func interruptContext() (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	stopped := make(chan struct{})
+
+	go func() {
+		select {
+		case <-signals:
+		case <-stopped:
+			return
+		}
+		fmt.Fprintln(os.Stderr, "\nInterrupted, cleaning up (press Ctrl-C again to quit)")
+		cancel()
+		select {
+		case <-signals:
+			os.Exit(130)
+		case <-stopped:
+		}
+	}()
+
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			signal.Stop(signals)
+			close(stopped)
+			cancel()
+		})
+	}
+}
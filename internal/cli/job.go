@@ -0,0 +1,217 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gwuah/piko/internal/state"
+	"github.com/spf13/cobra"
+)
+
+var jobCmd = &cobra.Command{
+	Use:   "job",
+	Short: "Inspect and control operations running on the server",
+	Long: `When the piko server is running, create, rename and destroy run on it as
+jobs. A job keeps running if the client that started it goes away; its
+output is kept for 7 days and can be followed again from any line.`,
+}
+
+var jobListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List recent jobs",
+	Args:  cobra.NoArgs,
+	RunE:  runJobList,
+}
+
+var jobStatusCmd = &cobra.Command{
+	Use:   "status <id>",
+	Short: "Show a job's status",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runJobStatus,
+}
+
+var jobLogsCmd = &cobra.Command{
+	Use:   "logs <id>",
+	Short: "Show a job's output, following it while it runs",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runJobLogs,
+}
+
+var jobCancelCmd = &cobra.Command{
+	Use:   "cancel <id>",
+	Short: "Cancel a job; a canceled create or rename is rolled back",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runJobCancel,
+}
+
+var (
+	jobListLimit  int
+	jobLogsOffset int64
+)
+
+func init() {
+	rootCmd.AddCommand(jobCmd)
+	jobCmd.AddCommand(jobListCmd)
+	jobCmd.AddCommand(jobStatusCmd)
+	jobCmd.AddCommand(jobLogsCmd)
+	jobCmd.AddCommand(jobCancelCmd)
+	jobListCmd.Flags().IntVarP(&jobListLimit, "limit", "n", 20, "Maximum number of jobs")
+	jobLogsCmd.Flags().Int64Var(&jobLogsOffset, "offset", 0, "Start at this line of output")
+}
+
+func parseJobID(arg string) (int64, error) {
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid job ID %q", arg)
+	}
+	return id, nil
+}
+
+func runJobList(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	ctx, err := NewContextWithoutProject()
+	if err != nil {
+		return err
+	}
+	defer ctx.Close()
+
+	jobs, err := ctx.DB.ListJobs(state.JobFilter{Limit: jobListLimit})
+	if err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		fmt.Println("No jobs.")
+		return nil
+	}
+
+	table := NewTable("ID", "CREATED", "PROJECT", "ENVIRONMENT", "KIND", "SOURCE", "STATUS", "DURATION", "ERROR")
+	for _, j := range jobs {
+		table.Row(
+			strconv.FormatInt(j.ID, 10),
+			j.CreatedAt.Local().Format("2006-01-02 15:04:05"),
+			j.ProjectName,
+			j.Environment,
+			j.Kind,
+			j.Source,
+			j.Status,
+			jobDuration(j),
+			truncate(strings.Join(strings.Fields(j.Error), " "), 60),
+		)
+	}
+	table.Flush()
+	return nil
+}
+
+func jobDuration(j *state.Job) string {
+	if !j.StartedAt.Valid {
+		return "-"
+	}
+	end := time.Now()
+	if j.FinishedAt.Valid {
+		end = j.FinishedAt.Time
+	}
+	return formatElapsed(end.Sub(j.StartedAt.Time))
+}
+
+func runJobStatus(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	id, err := parseJobID(args[0])
+	if err != nil {
+		return err
+	}
+
+	ctx, err := NewContextWithoutProject()
+	if err != nil {
+		return err
+	}
+	defer ctx.Close()
+
+	job, err := ctx.DB.GetJob(id)
+	if err != nil {
+		return err
+	}
+	lines, err := ctx.DB.CountJobLogs(id)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Job:         %d (%s)\n", job.ID, job.Kind)
+	fmt.Printf("Environment: %s/%s\n", job.ProjectName, job.Environment)
+	fmt.Printf("Source:      %s\n", job.Source)
+	fmt.Printf("Status:      %s\n", job.Status)
+	fmt.Printf("Created:     %s\n", job.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+	if job.StartedAt.Valid {
+		fmt.Printf("Duration:    %s\n", jobDuration(job))
+	}
+	fmt.Printf("Output:      %d line(s)\n", lines)
+	if job.Error != "" {
+		fmt.Printf("Error:       %s\n", job.Error)
+	}
+	return nil
+}
+
+func runJobLogs(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	id, err := parseJobID(args[0])
+	if err != nil {
+		return err
+	}
+
+	api := NewAPIClient()
+	if api.IsServerRunning() {
+		return NewStreamClient().FollowJob(id, jobLogsOffset, false)
+	}
+
+	ctx, err := NewContextWithoutProject()
+	if err != nil {
+		return err
+	}
+	defer ctx.Close()
+
+	job, err := ctx.DB.GetJob(id)
+	if err != nil {
+		return err
+	}
+
+	streamClient := NewStreamClient()
+	offset := jobLogsOffset
+	for {
+		logs, err := ctx.DB.JobLogs(id, offset, 500)
+		if err != nil {
+			return err
+		}
+		for _, l := range logs {
+			streamClient.printLog(LogMessage{Source: l.Source, Stream: l.Stream, Data: l.Data})
+			offset = l.Seq + 1
+		}
+		if len(logs) < 500 {
+			break
+		}
+	}
+
+	if job.Status == state.JobFailed || job.Status == state.JobCanceled {
+		return fmt.Errorf("job %s: %s", job.Status, job.Error)
+	}
+	return nil
+}
+
+func runJobCancel(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	id, err := parseJobID(args[0])
+	if err != nil {
+		return err
+	}
+
+	api := NewAPIClient()
+	if !api.IsServerRunning() {
+		return fmt.Errorf("piko server is not running (jobs run on it; start with: piko server)")
+	}
+	if err := api.CancelJob(id); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Canceling job %d\n", id)
+	return nil
+}
@@ -0,0 +1,175 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/gwuah/piko/internal/docker"
+	"github.com/gwuah/piko/internal/operations"
+	"github.com/spf13/cobra"
+)
+
+var linkCmd = &cobra.Command{
+	Use:   "link <name>",
+	Short: "Point an environment's service at another environment",
+	Long: `Point a service of an environment at the same service running in another
+environment of the project, e.g. a frontend worktree using the backend of a
+different worktree:
+
+  piko env link frontend-work --service api=backend-work
+
+For docker environments the service is removed from the generated compose file
+and the remaining services join the other environment's network, where the
+service name resolves to its container. For simple mode environments
+PIKO_<SERVICE>_PORT points at the other environment's host port.
+
+Without --service the current links are listed.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runLink,
+}
+
+var unlinkCmd = &cobra.Command{
+	Use:   "unlink <name> <service>...",
+	Short: "Remove links created with 'piko env link'",
+	Args:  cobra.MinimumNArgs(2),
+	RunE:  runUnlink,
+}
+
+var linkServices []string
+
+func init() {
+	envCmd.AddCommand(linkCmd)
+	envCmd.AddCommand(unlinkCmd)
+	linkCmd.Flags().StringArrayVar(&linkServices, "service", nil, "Link a service to another environment (service=env, repeatable)")
+}
+
+func runLink(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	resolved, err := ResolveEnvironmentGlobally(args[0])
+	if err != nil {
+		return err
+	}
+	defer resolved.Close()
+
+	if len(linkServices) == 0 {
+		return printLinks(resolved)
+	}
+
+	db := resolved.Ctx.DB
+	for _, spec := range linkServices {
+		service, targetName, ok := strings.Cut(spec, "=")
+		if !ok || service == "" || targetName == "" {
+			return fmt.Errorf("invalid --service %q (expected service=env)", spec)
+		}
+
+		target, err := db.GetEnvironmentByName(resolved.Project.ID, targetName)
+		if err != nil {
+			return fmt.Errorf("environment %q not found in project %q", targetName, resolved.Project.Name)
+		}
+		if target.ID == resolved.Environment.ID {
+			return fmt.Errorf("cannot link %s to its own environment", service)
+		}
+		if target.DockerProject == "" {
+			return fmt.Errorf("environment %q is in simple mode (no services to link to)", targetName)
+		}
+
+		targetComposeDir := target.Path
+		if resolved.Project.ComposeDir != "" {
+			targetComposeDir = filepath.Join(target.Path, resolved.Project.ComposeDir)
+		}
+		composeConfig, err := docker.ParseComposeConfig(targetComposeDir)
+		if err != nil {
+			return fmt.Errorf("failed to parse compose config of %s: %w", targetName, err)
+		}
+		if _, ok := composeConfig.Project().Services[service]; !ok {
+			return fmt.Errorf("service %q not found in %s", service, targetName)
+		}
+
+		if err := db.SetEnvironmentLink(resolved.Environment.ID, service, target.ID); err != nil {
+			return err
+		}
+		fmt.Printf("✓ Linked %s → %s\n", service, targetName)
+	}
+
+	return applyLinks(resolved)
+}
+
+func runUnlink(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	resolved, err := ResolveEnvironmentGlobally(args[0])
+	if err != nil {
+		return err
+	}
+	defer resolved.Close()
+
+	for _, service := range args[1:] {
+		removed, err := resolved.Ctx.DB.DeleteEnvironmentLink(resolved.Environment.ID, service)
+		if err != nil {
+			return err
+		}
+		if !removed {
+			return fmt.Errorf("%s is not linked", service)
+		}
+		fmt.Printf("✓ Unlinked %s\n", service)
+	}
+
+	return applyLinks(resolved)
+}
+
+// applyLinks makes link changes take effect: docker environments get their
+// compose file regenerated and are restarted if running.
+func applyLinks(resolved *ResolvedEnvironment) error {
+	e := resolved.Environment
+
+	if e.DockerProject == "" {
+		links, err := operations.ResolveLinks(resolved.Ctx.DB, resolved.Project, e)
+		if err != nil {
+			return err
+		}
+		for _, link := range links {
+			for _, alloc := range link.Allocations {
+				fmt.Printf("  %s:%d → localhost:%d (%s)\n", link.Service, alloc.ContainerPort, alloc.HostPort, link.Target.Name)
+			}
+		}
+		if err := operations.WriteSimpleEnvFiles(resolved.Ctx.DB, resolved.Project, e, &operations.StdoutLogger{}); err != nil {
+			return err
+		}
+		fmt.Println("Restart processes in the environment to pick up the new PIKO_* ports.")
+		return nil
+	}
+
+	if docker.GetProjectStatus(resolved.ComposeDir, e.DockerProject) != docker.StatusRunning {
+		if _, err := operations.WriteComposeFile(resolved.Ctx.DB, resolved.Project, e); err != nil {
+			return err
+		}
+		fmt.Printf("Links apply the next time the environment starts (piko env up %s)\n", e.Name)
+		return nil
+	}
+
+	opCtx, stop := interruptContext()
+	defer stop()
+	return operations.UpEnvironment(opCtx, operations.UpEnvironmentOptions{
+		DB:          resolved.Ctx.DB,
+		Project:     resolved.Project,
+		Environment: e,
+		Logger:      &operations.StdoutLogger{},
+	})
+}
+
+func printLinks(resolved *ResolvedEnvironment) error {
+	links, err := operations.ResolveLinks(resolved.Ctx.DB, resolved.Project, resolved.Environment)
+	if err != nil {
+		return err
+	}
+	if len(links) == 0 {
+		fmt.Println("No links")
+		return nil
+	}
+	for _, link := range links {
+		fmt.Printf("%s → %s\n", link.Service, link.Target.Name)
+	}
+	return nil
+}
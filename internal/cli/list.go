@@ -3,9 +3,11 @@ package cli
 import (
 	"fmt"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/gwuah/piko/internal/docker"
+	"github.com/gwuah/piko/internal/state"
 	"github.com/spf13/cobra"
 )
 
@@ -16,17 +18,29 @@ var listCmd = &cobra.Command{
 	RunE:    runList,
 }
 
-var listAll bool
+var (
+	listAll    bool
+	listTags   []string
+	listLabels []string
+)
 
 func init() {
 	envCmd.AddCommand(listCmd)
 	listCmd.Flags().BoolVarP(&listAll, "all", "a", false, "List environments from all projects")
+	listCmd.Flags().StringSliceVar(&listTags, "tag", nil, "Only environments with these tags")
+	listCmd.Flags().StringArrayVar(&listLabels, "label", nil, "Only environments with this label (key=value), repeatable")
 }
 
 func runList(cmd *cobra.Command, args []string) error {
 	cmd.SilenceUsage = true
+	labels, err := parseLabels(listLabels)
+	if err != nil {
+		return err
+	}
+	filtered := len(listTags) > 0 || len(labels) > 0
+
 	if listAll {
-		return runListAll()
+		return runListAll(labels, filtered)
 	}
 
 	ctx, err := NewContext()
@@ -45,25 +59,28 @@ func runList(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	table := NewTable("NAME", "STATUS", "BRANCH", "CREATED")
+	table := NewTable("NAME", "STATUS", "BRANCH", "CREATED", "LAST ACTIVE", "TAGS", "DESCRIPTION")
+	shown := 0
 	for _, e := range environments {
-		var status string
-		if e.DockerProject == "" {
-			status = "simple"
-		} else {
-			composeDir := e.Path
-			if ctx.Project.ComposeDir != "" {
-				composeDir = filepath.Join(e.Path, ctx.Project.ComposeDir)
-			}
-			status = string(docker.GetProjectStatus(composeDir, e.DockerProject))
+		metadata, err := ctx.DB.GetEnvironmentMetadata(e.ID)
+		if err != nil {
+			return err
+		}
+		if !metadata.Matches(listTags, labels) {
+			continue
 		}
-		table.Row(e.Name, status, e.Branch, formatAge(e.CreatedAt))
+		table.Row(e.Name, environmentStatus(ctx.Project, e), e.Branch, formatAge(e.CreatedAt), formatAge(e.LastActivityAt), strings.Join(metadata.Tags, ","), truncate(metadata.Description, 40))
+		shown++
+	}
+	if shown == 0 && filtered {
+		fmt.Println("No environments match.")
+		return nil
 	}
 	table.Flush()
 	return nil
 }
 
-func runListAll() error {
+func runListAll(labels map[string]string, filtered bool) error {
 	ctx, err := NewContextWithoutProject()
 	if err != nil {
 		return err
@@ -80,7 +97,7 @@ func runListAll() error {
 		return nil
 	}
 
-	table := NewTable("PROJECT", "ENVIRONMENT", "STATUS", "BRANCH")
+	table := NewTable("PROJECT", "ENVIRONMENT", "STATUS", "BRANCH", "LAST ACTIVE", "TAGS")
 	for _, p := range projects {
 		environments, err := ctx.DB.ListEnvironmentsByProject(p.ID)
 		if err != nil {
@@ -88,28 +105,56 @@ func runListAll() error {
 		}
 
 		if len(environments) == 0 {
-			table.Row(p.Name, "(no environments)", "", "")
+			if !filtered {
+				table.Row(p.Name, "(no environments)", "", "", "", "")
+			}
 			continue
 		}
 
 		for _, e := range environments {
-			var status string
-			if e.DockerProject == "" {
-				status = "simple"
-			} else {
-				composeDir := e.Path
-				if p.ComposeDir != "" {
-					composeDir = filepath.Join(e.Path, p.ComposeDir)
-				}
-				status = string(docker.GetProjectStatus(composeDir, e.DockerProject))
+			metadata, err := ctx.DB.GetEnvironmentMetadata(e.ID)
+			if err != nil {
+				return err
 			}
-			table.Row(p.Name, e.Name, status, e.Branch)
+			if !metadata.Matches(listTags, labels) {
+				continue
+			}
+			table.Row(p.Name, e.Name, environmentStatus(p, e), e.Branch, formatAge(e.LastActivityAt), strings.Join(metadata.Tags, ","))
 		}
 	}
 	table.Flush()
 	return nil
 }
 
+func environmentStatus(project *state.Project, e *state.Environment) string {
+	status := "simple"
+	if e.DockerProject != "" {
+		composeDir := e.Path
+		if project.ComposeDir != "" {
+			composeDir = filepath.Join(e.Path, project.ComposeDir)
+		}
+		status = string(docker.GetProjectStatus(composeDir, e.DockerProject))
+		if status == string(docker.StatusRunning) {
+			return status
+		}
+	}
+
+	if e.ExpiredAt.Valid {
+		return "expired"
+	}
+	if e.HibernatedAt.Valid {
+		return "hibernated"
+	}
+	return status
+}
+
+func truncate(s string, n int) string {
+	if len([]rune(s)) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
+
 func formatAge(t time.Time) string {
 	d := time.Since(t)
 	if d < time.Minute {
@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gwuah/piko/internal/config"
+	"github.com/gwuah/piko/internal/state"
+	"github.com/spf13/cobra"
+)
+
+var logCmd = &cobra.Command{
+	Use:   "log [env]",
+	Short: "Show the history of operations on environments",
+	Long: `Show who created, started, stopped, restarted, renamed or destroyed
+environments, when, from where (cli, api or server) and with what outcome.
+
+Inside a project, shows that project's events; use project/env or --all
+elsewhere. Events are kept for 90 days.`,
+	Args: cobra.RangeArgs(0, 1),
+	RunE: runLog,
+}
+
+var (
+	logAll    bool
+	logAction string
+	logSince  string
+	logLimit  int
+)
+
+func init() {
+	rootCmd.AddCommand(logCmd)
+	logCmd.Flags().BoolVarP(&logAll, "all", "a", false, "Show events from all projects")
+	logCmd.Flags().StringVar(&logAction, "action", "", "Only this action (create, up, down, restart, rename, destroy, hibernate, expire, move)")
+	logCmd.Flags().StringVar(&logSince, "since", "", "Only events newer than this (e.g. 2h, 7d)")
+	logCmd.Flags().IntVarP(&logLimit, "limit", "n", 50, "Maximum number of events")
+}
+
+func runLog(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	filter := state.EventFilter{Action: logAction, Limit: logLimit}
+	if logSince != "" {
+		d, err := config.ParseDuration(logSince)
+		if err != nil {
+			return fmt.Errorf("invalid --since: %w", err)
+		}
+		filter.Since = time.Now().Add(-d)
+	}
+
+	ctx, err := NewContextWithoutProject()
+	if err != nil {
+		return err
+	}
+	defer ctx.Close()
+
+	if len(args) == 1 {
+		filter.Environment = args[0]
+	}
+	if projectName, name, ok := strings.Cut(filter.Environment, "/"); ok {
+		project, err := ctx.DB.GetProjectByName(projectName)
+		if err != nil {
+			return fmt.Errorf("project %q not found", projectName)
+		}
+		filter.ProjectID = project.ID
+		filter.Environment = name
+	} else if !logAll {
+		project, err := ctx.DB.FindProjectByPath(ctx.CWD)
+		if err != nil {
+			return fmt.Errorf("not in a piko project (use project/env or --all)")
+		}
+		filter.ProjectID = project.ID
+	}
+
+	events, err := ctx.DB.ListEvents(filter)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		fmt.Println("No events.")
+		return nil
+	}
+
+	showProject := filter.ProjectID == 0
+	columns := []string{"TIME", "ENVIRONMENT", "ACTION", "SOURCE", "ACTOR", "RESULT", "DURATION", "DETAIL"}
+	if showProject {
+		columns = append([]string{"TIME", "PROJECT"}, columns[1:]...)
+	}
+	table := NewTable(columns...)
+	for _, e := range events {
+		environment := e.Environment
+		if environment == "" {
+			environment = "-"
+		}
+		detail := e.Detail
+		if e.Error != "" {
+			detail = truncate(strings.Join(strings.Fields(e.Error), " "), 60)
+		}
+		row := []string{e.StartedAt.Local().Format("2006-01-02 15:04:05"), environment, e.Action, e.Source, e.Actor, e.Status, formatElapsed(e.Duration), detail}
+		if showProject {
+			row = append([]string{row[0], e.ProjectName}, row[1:]...)
+		}
+		table.Row(row...)
+	}
+	table.Flush()
+	return nil
+}
+
+func formatElapsed(d time.Duration) string {
+	if d < time.Second {
+		return fmt.Sprintf("%dms", d.Milliseconds())
+	}
+	return d.Round(100 * time.Millisecond).String()
+}
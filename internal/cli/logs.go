@@ -1,18 +1,24 @@
 package cli
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gwuah/piko/internal/operations"
+	"github.com/gwuah/piko/internal/supervisor"
 
 	"github.com/spf13/cobra"
 )
 
 var logsCmd = &cobra.Command{
-	Use:         "logs [name] [service]",
-	Short:       "View container logs",
-	Args:        cobra.RangeArgs(0, 2),
-	RunE:        runLogs,
-	Annotations: Requires(ToolDocker),
+	Use:   "logs [name] [service]",
+	Short: "View container or background process logs",
+	Args:  cobra.RangeArgs(0, 2),
+	RunE:  runLogs,
 }
 
 var (
@@ -37,12 +43,19 @@ func runLogs(cmd *cobra.Command, args []string) error {
 		serviceName = args[1]
 	}
 
-	resolved, err := RequireDockerGlobally(name)
+	resolved, err := ResolveEnvironmentGlobally(name)
 	if err != nil {
 		return err
 	}
 	defer resolved.Close()
 
+	if resolved.Environment.DockerProject == "" {
+		return processLogs(resolved, serviceName)
+	}
+	if ok, _ := CheckTool(ToolDocker); !ok {
+		return fmt.Errorf("missing required tools: Docker (run 'piko doctor' for details)")
+	}
+
 	cmdArgs := []string{"compose", "-p", resolved.Environment.DockerProject, "logs"}
 
 	if logsFollow {
@@ -65,3 +78,89 @@ func runLogs(cmd *cobra.Command, args []string) error {
 
 	return dockerCmd.Run()
 }
+
+func processLogs(resolved *ResolvedEnvironment, process string) error {
+	names := []string{process}
+	if process == "" {
+		specs, err := operations.ProcessSpecs(resolved.Ctx.DB, resolved.Project, resolved.Environment)
+		if err != nil {
+			return err
+		}
+		if len(specs) == 0 {
+			return fmt.Errorf("no processes defined (add a 'processes' section to .piko.yml)")
+		}
+		names = names[:0]
+		for _, spec := range specs {
+			names = append(names, spec.Name)
+		}
+	}
+
+	lines := 100
+	if logsTail != "" {
+		n, err := strconv.Atoi(logsTail)
+		if err != nil && logsTail != "all" {
+			return fmt.Errorf("invalid --tail %q", logsTail)
+		}
+		lines = n
+	}
+
+	offsets := make(map[string]int64)
+	for _, name := range names {
+		path := operations.ProcessLogPath(resolved.Project, resolved.Environment, name)
+		data, err := supervisor.Tail(path, lines)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		printPrefixed(name, len(names) > 1, data)
+		if info, err := os.Stat(path); err == nil {
+			offsets[name] = info.Size()
+		}
+	}
+
+	for logsFollow {
+		time.Sleep(500 * time.Millisecond)
+		for _, name := range names {
+			path := operations.ProcessLogPath(resolved.Project, resolved.Environment, name)
+			offsets[name] = printNewLogOutput(path, name, len(names) > 1, offsets[name])
+		}
+	}
+	return nil
+}
+
+// printNewLogOutput prints what was appended to a log since offset and
+// returns the new offset. A shrunk file was rotated and is read from the start.
+func printNewLogOutput(path, name string, prefix bool, offset int64) int64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return offset
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return offset
+	}
+	if info.Size() < offset {
+		offset = 0
+	}
+	if info.Size() == offset {
+		return offset
+	}
+
+	data := make([]byte, info.Size()-offset)
+	n, _ := f.ReadAt(data, offset)
+	printPrefixed(name, prefix, data[:n])
+	return offset + int64(n)
+}
+
+func printPrefixed(name string, prefix bool, data []byte) {
+	if !prefix {
+		os.Stdout.Write(data)
+		return
+	}
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if line != "" {
+			fmt.Printf("%s | %s", name, line)
+		}
+	}
+}
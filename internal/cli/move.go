@@ -0,0 +1,116 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gwuah/piko/internal/operations"
+	"github.com/gwuah/piko/internal/state"
+	"github.com/spf13/cobra"
+)
+
+var moveCmd = &cobra.Command{
+	Use:   "move <new-path>",
+	Short: "Relocate a project whose repository moved",
+	Long: `Point a project at its repository's new location.
+
+Run it after moving the repository, or from the project before moving it and
+piko moves the directory. Environment paths are updated, worktree links
+repaired ('git worktree repair'), generated compose and env files rewritten,
+and tmux sessions pointed at the new paths.
+
+Without --project, the project is the one in the current directory, or the
+only project whose repository is missing.`,
+	Args:        cobra.ExactArgs(1),
+	RunE:        runMove,
+	Annotations: Requires(ToolGit),
+}
+
+var (
+	moveProject string
+	moveWait    bool
+)
+
+func init() {
+	projectCmd.AddCommand(moveCmd)
+	moveCmd.Flags().StringVar(&moveProject, "project", "", "Name of the project to relocate")
+	moveCmd.Flags().BoolVar(&moveWait, "wait", false, "Wait for operations on the project's environments to finish instead of failing")
+}
+
+func runMove(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	ctx, err := NewContextWithoutProject()
+	if err != nil {
+		return err
+	}
+	defer ctx.Close()
+
+	project, err := projectToMove(ctx)
+	if err != nil {
+		return err
+	}
+
+	opCtx, stop := interruptContext()
+	defer stop()
+
+	moved, err := operations.MoveProject(opCtx, operations.MoveProjectOptions{
+		DB:      ctx.DB,
+		Project: project,
+		NewRoot: args[0],
+		Wait:    moveWait,
+		Logger:  &operations.StdoutLogger{},
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("✓ Project %q is now at %s\n", moved.Name, moved.RootPath)
+	return nil
+}
+
+func projectToMove(ctx *Context) (*state.Project, error) {
+	if moveProject != "" {
+		project, err := ctx.DB.GetProjectByName(moveProject)
+		if err != nil {
+			return nil, fmt.Errorf("project %q not found", moveProject)
+		}
+		return project, nil
+	}
+
+	if project, err := ctx.DB.FindProjectByPath(ctx.CWD); err == nil {
+		return project, nil
+	}
+
+	missing, err := missingProjects(ctx.DB)
+	if err != nil {
+		return nil, err
+	}
+	switch len(missing) {
+	case 0:
+		return nil, fmt.Errorf("no project found here and no project is missing its repository (use --project)")
+	case 1:
+		return missing[0], nil
+	}
+	var names []string
+	for _, p := range missing {
+		names = append(names, p.Name)
+	}
+	return nil, fmt.Errorf("several projects are missing their repository: %s (use --project)", strings.Join(names, ", "))
+}
+
+// missingProjects returns the projects whose root path no longer exists.
+func missingProjects(db *state.DB) ([]*state.Project, error) {
+	projects, err := db.ListProjects()
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []*state.Project
+	for _, p := range projects {
+		if _, err := os.Stat(p.RootPath); os.IsNotExist(err) {
+			missing = append(missing, p)
+		}
+	}
+	return missing, nil
+}
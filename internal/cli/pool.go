@@ -0,0 +1,130 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/gwuah/piko/internal/config"
+	"github.com/gwuah/piko/internal/git"
+	"github.com/gwuah/piko/internal/operations"
+	"github.com/spf13/cobra"
+)
+
+var poolCmd = &cobra.Command{
+	Use:   "pool",
+	Short: "Show the project's pool of spare environments",
+	Long: `Show the spare environments kept for the current project.
+
+With 'pool: {size: N}' in .piko.yml, the running 'piko server' keeps N fully
+set up environments on a detached HEAD. 'piko env create <name>' (without
+--branch) claims a spare at the current HEAD, renaming its branch, worktree,
+data directory and docker project, instead of building a new environment.
+Spares are rebuilt when HEAD moves.`,
+	Args: cobra.NoArgs,
+	RunE: runPool,
+}
+
+var poolFillCmd = &cobra.Command{
+	Use:   "fill",
+	Short: "Create spare environments up to the configured pool size",
+	Args:  cobra.NoArgs,
+	RunE:  runPoolFill,
+}
+
+var poolDrainCmd = &cobra.Command{
+	Use:   "drain",
+	Short: "Destroy all spare environments",
+	Args:  cobra.NoArgs,
+	RunE:  runPoolDrain,
+}
+
+func init() {
+	rootCmd.AddCommand(poolCmd)
+	poolCmd.AddCommand(poolFillCmd)
+	poolCmd.AddCommand(poolDrainCmd)
+}
+
+func runPool(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	ctx, err := NewContext()
+	if err != nil {
+		return err
+	}
+	defer ctx.Close()
+
+	cfg, err := config.Load(ctx.Project.RootPath)
+	if err != nil {
+		return err
+	}
+
+	spares, err := ctx.DB.ListPoolEnvironments(ctx.Project.ID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Pool size: %d\n", cfg.Pool.Size)
+	if len(spares) == 0 {
+		return nil
+	}
+
+	head, _ := git.HeadCommit(ctx.Project.RootPath)
+	table := NewTable("NAME", "STATE", "COMMIT", "AGE")
+	for _, spare := range spares {
+		commit, _ := git.HeadCommit(spare.Path)
+		state := string(spare.Pool)
+		if commit != "" && commit != head {
+			state += " (stale)"
+		}
+		if len(commit) > 7 {
+			commit = commit[:7]
+		}
+		table.Row(spare.Name, state, commit, formatAge(spare.CreatedAt))
+	}
+	table.Flush()
+	return nil
+}
+
+func runPoolFill(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	ctx, err := NewContext()
+	if err != nil {
+		return err
+	}
+	defer ctx.Close()
+
+	opCtx, stop := interruptContext()
+	defer stop()
+
+	created, err := operations.FillPool(opCtx, operations.FillPoolOptions{
+		DB:            ctx.DB,
+		Project:       ctx.Project,
+		Logger:        &operations.StdoutLogger{},
+		StopProcesses: serverProcessStopper(),
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ Created %d spare environment(s)\n", created)
+	return nil
+}
+
+func runPoolDrain(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	ctx, err := NewContext()
+	if err != nil {
+		return err
+	}
+	defer ctx.Close()
+
+	opCtx, stop := interruptContext()
+	defer stop()
+
+	removed, err := operations.DrainPool(opCtx, ctx.DB, ctx.Project, &operations.StdoutLogger{})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ Removed %d spare environment(s)\n", removed)
+	if cfg, _ := config.Load(ctx.Project.RootPath); cfg != nil && cfg.Pool.Size > 0 {
+		fmt.Println("The running server refills the pool; set pool.size to 0 to keep it empty.")
+	}
+	return nil
+}
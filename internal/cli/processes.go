@@ -0,0 +1,155 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gwuah/piko/internal/state"
+	"github.com/gwuah/piko/internal/supervisor"
+	"github.com/spf13/cobra"
+)
+
+var psCmd = &cobra.Command{
+	Use:   "ps [name]",
+	Short: "Show background processes of a simple mode environment",
+	Long: `Show the processes declared in the 'processes' section of .piko.yml and
+their state. Processes are supervised by 'piko server': they run in the
+background, are restarted when they crash and log to
+.piko/data/<env>/logs/<process>.log.`,
+	Args: cobra.RangeArgs(0, 1),
+	RunE: runPs,
+}
+
+var startCmd = &cobra.Command{
+	Use:   "start [name] [process]",
+	Short: "Start background processes (all, or one by name)",
+	Args:  cobra.RangeArgs(0, 2),
+	RunE:  runStart,
+}
+
+var stopCmd = &cobra.Command{
+	Use:   "stop [name] [process]",
+	Short: "Stop background processes (all, or one by name)",
+	Args:  cobra.RangeArgs(0, 2),
+	RunE:  runStop,
+}
+
+func init() {
+	envCmd.AddCommand(psCmd)
+	envCmd.AddCommand(startCmd)
+	envCmd.AddCommand(stopCmd)
+}
+
+func runPs(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	name, err := GetEnvNameOrSelect(args)
+	if err != nil {
+		return err
+	}
+
+	resolved, err := ResolveEnvironmentGlobally(name)
+	if err != nil {
+		return err
+	}
+	defer resolved.Close()
+
+	api, err := supervisorAPI()
+	if err != nil {
+		return err
+	}
+	statuses, err := api.Processes(resolved.Project.ID, resolved.Environment.Name)
+	if err != nil {
+		return err
+	}
+	if len(statuses) == 0 {
+		fmt.Println("No processes defined (add a 'processes' section to .piko.yml)")
+		return nil
+	}
+
+	printProcesses(statuses)
+	return nil
+}
+
+func runStart(cmd *cobra.Command, args []string) error {
+	return processAction(cmd, args, "Started", func(api *APIClient, projectID int64, env, process string) error {
+		return api.StartProcess(projectID, env, process)
+	})
+}
+
+func runStop(cmd *cobra.Command, args []string) error {
+	return processAction(cmd, args, "Stopped", func(api *APIClient, projectID int64, env, process string) error {
+		return api.StopProcess(projectID, env, process)
+	})
+}
+
+func processAction(cmd *cobra.Command, args []string, verb string, action func(*APIClient, int64, string, string) error) error {
+	cmd.SilenceUsage = true
+	name, err := GetEnvNameOrSelect(args)
+	if err != nil {
+		return err
+	}
+	var process string
+	if len(args) > 1 {
+		process = args[1]
+	}
+
+	resolved, err := ResolveEnvironmentGlobally(name)
+	if err != nil {
+		return err
+	}
+	defer resolved.Close()
+
+	if resolved.Environment.DockerProject != "" {
+		return fmt.Errorf("environment %q uses docker (use 'piko env up/down' instead)", name)
+	}
+
+	api, err := supervisorAPI()
+	if err != nil {
+		return err
+	}
+	if err := action(api, resolved.Project.ID, resolved.Environment.Name, process); err != nil {
+		return err
+	}
+
+	if process == "" {
+		process = "all processes"
+	}
+	fmt.Printf("✓ %s %s\n", verb, process)
+	return nil
+}
+
+// serverProcessStopper returns a StopProcesses hook that asks the server to
+// stop an environment's processes, or nil when no server is running to
+// supervise any.
+func serverProcessStopper() func(*state.Environment) {
+	api := NewAPIClient()
+	if !api.IsServerRunning() {
+		return nil
+	}
+	return func(environment *state.Environment) {
+		api.StopProcess(environment.ProjectID, environment.Name, "")
+	}
+}
+
+func supervisorAPI() (*APIClient, error) {
+	api := NewAPIClient()
+	if !api.IsServerRunning() {
+		return nil, fmt.Errorf("piko server is not running (background processes are supervised by it; start with: piko server)")
+	}
+	return api, nil
+}
+
+func printProcesses(statuses []supervisor.Status) {
+	table := NewTable("PROCESS", "STATE", "PID", "RESTARTS", "UPTIME", "COMMAND")
+	for _, st := range statuses {
+		pid, uptime := "-", "-"
+		if st.PID > 0 {
+			pid = fmt.Sprintf("%d", st.PID)
+		}
+		if st.StartedAt != nil {
+			uptime = time.Since(*st.StartedAt).Truncate(time.Second).String()
+		}
+		table.Row(st.Name, string(st.State), pid, fmt.Sprintf("%d", st.Restarts), uptime, st.Command)
+	}
+	table.Flush()
+}
@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gwuah/piko/internal/certs"
+	"github.com/gwuah/piko/internal/proxy"
+	"github.com/gwuah/piko/internal/state"
+	"github.com/spf13/cobra"
+)
+
+var proxyCmd = &cobra.Command{
+	Use:   "proxy",
+	Short: "Run the environment reverse proxy without the web server",
+	Long: `Route http://<service>.<env>.<project>.localhost:<port> to the host port of each
+environment's service. 'piko server' already runs this proxy unless started
+with --proxy-port 0.`,
+	Args: cobra.NoArgs,
+	RunE: runProxy,
+}
+
+var (
+	proxyPort      int
+	proxyHTTPSPort int
+)
+
+func init() {
+	rootCmd.AddCommand(proxyCmd)
+	proxyCmd.Flags().IntVar(&proxyPort, "port", proxy.DefaultPort, "Port to listen on")
+	proxyCmd.Flags().IntVar(&proxyHTTPSPort, "https-port", proxy.DefaultTLSPort, "Port to listen on for HTTPS (0 to disable)")
+}
+
+func runProxy(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	ctx, err := NewContextWithoutProject()
+	if err != nil {
+		return err
+	}
+	defer ctx.Close()
+
+	p, err := newProxy(ctx.DB, proxyPort, proxyHTTPSPort)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan os.Signal, 1)
+	signal.Notify(done, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-done
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		p.Shutdown(shutdownCtx)
+	}()
+
+	if err := p.Listen(); err != nil {
+		return fmt.Errorf("failed to start proxy: %w", err)
+	}
+	fmt.Printf("→ Proxy routing http://<service>.<env>.<project>.localhost:%d\n", p.Port())
+	if p.TLSPort() > 0 {
+		fmt.Printf("→ Proxy routing https://<service>.<env>.<project>.localhost:%d\n", p.TLSPort())
+	}
+	return p.Serve()
+}
+
+func newProxy(db *state.DB, port, httpsPort int) (*proxy.Proxy, error) {
+	p := proxy.New(db, port)
+	if httpsPort <= 0 {
+		return p, nil
+	}
+
+	dir, err := certs.DefaultDir()
+	if err != nil {
+		return nil, err
+	}
+	ca, created, err := certs.LoadOrCreate(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load local CA: %w", err)
+	}
+	if created {
+		fmt.Printf("→ Created local CA at %s\n", ca.CertPath())
+		fmt.Println("  Trust it to use HTTPS without browser warnings:")
+		fmt.Print(certs.TrustInstructions(ca.CertPath()))
+	}
+
+	return p.WithTLS(httpsPort, ca), nil
+}
@@ -0,0 +1,294 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gwuah/piko/internal/config"
+	"github.com/gwuah/piko/internal/docker"
+	"github.com/gwuah/piko/internal/git"
+	"github.com/gwuah/piko/internal/operations"
+	"github.com/gwuah/piko/internal/state"
+	"github.com/gwuah/piko/internal/tmux"
+	"github.com/spf13/cobra"
+)
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Destroy environments matching the given selectors",
+	Long: `Destroy environments in bulk. Selectors are combined, so --merged --stopped
+only matches environments whose branch is merged and whose containers are stopped.
+Matching environments are listed for confirmation before anything is destroyed.`,
+	Args:        cobra.NoArgs,
+	RunE:        runPrune,
+	Annotations: Requires(ToolGit, ToolTmux),
+}
+
+var (
+	pruneMerged      bool
+	pruneOlderThan   string
+	pruneStopped     bool
+	pruneProject     string
+	pruneAll         bool
+	pruneBase        string
+	pruneYes         bool
+	pruneDryRun      bool
+	pruneJobs        int
+	pruneKeepVolumes bool
+	pruneForce       bool
+)
+
+func init() {
+	envCmd.AddCommand(pruneCmd)
+	pruneCmd.Flags().BoolVar(&pruneMerged, "merged", false, "Match environments whose branch is merged into the base branch")
+	pruneCmd.Flags().StringVar(&pruneOlderThan, "older-than", "", "Match environments created before this age (e.g. 14d, 2w, 36h)")
+	pruneCmd.Flags().BoolVar(&pruneStopped, "stopped", false, "Match environments whose containers (or tmux session, in simple mode) are stopped")
+	pruneCmd.Flags().StringVar(&pruneProject, "project", "", "Only consider environments from this project")
+	pruneCmd.Flags().BoolVarP(&pruneAll, "all", "a", false, "Consider environments from all projects")
+	pruneCmd.Flags().StringVar(&pruneBase, "base", "", "Base branch for --merged (defaults to the repository's default branch)")
+	pruneCmd.Flags().BoolVarP(&pruneYes, "yes", "y", false, "Skip confirmation")
+	pruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "List matching environments without destroying them")
+	pruneCmd.Flags().IntVarP(&pruneJobs, "jobs", "j", 4, "Number of environments to destroy concurrently")
+	pruneCmd.Flags().BoolVar(&pruneKeepVolumes, "keep-volumes", false, "Keep Docker volumes instead of removing them")
+	pruneCmd.Flags().BoolVarP(&pruneForce, "force", "f", false, "Also delete the git branches")
+}
+
+type pruneCandidate struct {
+	Project        *state.Project
+	Environment    *state.Environment
+	GitState       string
+	ContainerState string
+}
+
+func runPrune(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	if !pruneMerged && !pruneStopped && pruneOlderThan == "" {
+		return fmt.Errorf("specify at least one selector (--merged, --stopped, --older-than)")
+	}
+
+	var maxAge time.Duration
+	if pruneOlderThan != "" {
+		d, err := config.ParseDuration(pruneOlderThan)
+		if err != nil {
+			return err
+		}
+		maxAge = d
+	}
+
+	if pruneJobs < 1 {
+		pruneJobs = 1
+	}
+
+	ctx, err := NewContextWithoutProject()
+	if err != nil {
+		return err
+	}
+	defer ctx.Close()
+
+	projects, err := pruneProjects(ctx)
+	if err != nil {
+		return err
+	}
+
+	var candidates []pruneCandidate
+	for _, p := range projects {
+		matches, err := findPruneCandidates(ctx.DB, p, maxAge)
+		if err != nil {
+			return err
+		}
+		candidates = append(candidates, matches...)
+	}
+
+	if len(candidates) == 0 {
+		fmt.Println("No environments match")
+		return nil
+	}
+
+	table := NewTable("PROJECT", "ENVIRONMENT", "BRANCH", "GIT", "CONTAINERS", "CREATED")
+	for _, c := range candidates {
+		table.Row(c.Project.Name, c.Environment.Name, c.Environment.Branch, c.GitState, c.ContainerState, formatAge(c.Environment.CreatedAt))
+	}
+	table.Flush()
+	fmt.Println()
+
+	if pruneDryRun {
+		return nil
+	}
+
+	if !pruneYes && !Confirm(fmt.Sprintf("Destroy %d environment(s)?", len(candidates))) {
+		return fmt.Errorf("cancelled")
+	}
+
+	// One backup covers the whole prune; a backup per environment would
+	// rotate older ones away.
+	if _, err := ctx.DB.Backup("prune"); err != nil {
+		return err
+	}
+
+	opCtx, stop := interruptContext()
+	defer stop()
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		failures  []string
+		destroyed int
+	)
+	sem := make(chan struct{}, pruneJobs)
+	stopProcesses := serverProcessStopper()
+
+	// Destroys run concurrently, but git changes within one repository are
+	// made one at a time.
+	gitLocks := make(map[int64]*sync.Mutex)
+	for _, c := range candidates {
+		if gitLocks[c.Project.ID] == nil {
+			gitLocks[c.Project.ID] = &sync.Mutex{}
+		}
+	}
+
+	for _, c := range candidates {
+		if opCtx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(c pruneCandidate) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			fullName := fmt.Sprintf("%s/%s", c.Project.Name, c.Environment.Name)
+			err := operations.DestroyEnvironment(opCtx, operations.DestroyEnvironmentOptions{
+				DB:            ctx.DB,
+				Project:       c.Project,
+				Environment:   c.Environment,
+				RemoveVolumes: !pruneKeepVolumes,
+				DeleteBranch:  pruneForce,
+				GitLock:       gitLocks[c.Project.ID],
+				StopProcesses: stopProcesses,
+				Logger: &operations.PrefixLogger{
+					Prefix: fmt.Sprintf("[%s] ", fullName),
+					Next:   &operations.StdoutLogger{},
+				},
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", fullName, err))
+				return
+			}
+			destroyed++
+		}(c)
+	}
+	wg.Wait()
+
+	fmt.Println()
+	fmt.Printf("Destroyed %d of %d environment(s)\n", destroyed, len(candidates))
+	if err := opCtx.Err(); err != nil {
+		return fmt.Errorf("interrupted")
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to destroy:\n  %s", strings.Join(failures, "\n  "))
+	}
+	return nil
+}
+
+func pruneProjects(ctx *Context) ([]*state.Project, error) {
+	if pruneProject != "" {
+		project, err := ctx.DB.GetProjectByName(pruneProject)
+		if err != nil {
+			return nil, err
+		}
+		return []*state.Project{project}, nil
+	}
+
+	if pruneAll {
+		return ctx.DB.ListProjects()
+	}
+
+	project, err := ctx.DB.FindProjectByPath(ctx.CWD)
+	if err != nil {
+		return nil, fmt.Errorf("%w (or use --project/--all)", err)
+	}
+	return []*state.Project{project}, nil
+}
+
+func findPruneCandidates(db *state.DB, project *state.Project, maxAge time.Duration) ([]pruneCandidate, error) {
+	environments, err := db.ListEnvironmentsByProject(project.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list environments for %s: %w", project.Name, err)
+	}
+
+	if len(environments) == 0 {
+		return nil, nil
+	}
+
+	var merged map[string]bool
+	if pruneMerged {
+		base := pruneBase
+		if base == "" {
+			base, err = git.DefaultBranch(project.RootPath)
+			if err != nil {
+				return nil, fmt.Errorf("failed to determine base branch for %s: %w", project.Name, err)
+			}
+		}
+
+		merged, err = git.MergedBranches(project.RootPath, base)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list merged branches for %s: %w", project.Name, err)
+		}
+	}
+
+	var candidates []pruneCandidate
+	for _, e := range environments {
+		if maxAge > 0 && time.Since(e.CreatedAt) < maxAge {
+			continue
+		}
+
+		if pruneMerged && !merged[e.Branch] {
+			continue
+		}
+
+		containerState := pruneContainerState(project, e)
+		if pruneStopped && containerState != string(docker.StatusStopped) {
+			continue
+		}
+
+		gitState := "clean"
+		if dirty, err := git.IsDirty(e.Path); err != nil {
+			gitState = "missing"
+		} else if dirty {
+			gitState = "dirty"
+		}
+		if pruneMerged {
+			gitState = "merged, " + gitState
+		}
+
+		candidates = append(candidates, pruneCandidate{
+			Project:        project,
+			Environment:    e,
+			GitState:       gitState,
+			ContainerState: containerState,
+		})
+	}
+
+	return candidates, nil
+}
+
+func pruneContainerState(project *state.Project, e *state.Environment) string {
+	if e.DockerProject == "" {
+		if tmux.SessionExists(tmux.SessionName(project.Name, e.Name)) {
+			return string(docker.StatusRunning)
+		}
+		return string(docker.StatusStopped)
+	}
+
+	composeDir := e.Path
+	if project.ComposeDir != "" {
+		composeDir = filepath.Join(e.Path, project.ComposeDir)
+	}
+	return string(docker.GetProjectStatus(composeDir, e.DockerProject))
+}
@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/gwuah/piko/internal/httpclient"
+	"github.com/gwuah/piko/internal/operations"
+	"github.com/spf13/cobra"
+)
+
+var renameCmd = &cobra.Command{
+	Use:   "rename <name> <new-name>",
+	Short: "Rename an environment",
+	Long: `Rename an environment and everything named after it: the branch (if it
+carries the environment's name), the worktree and data directories, the tmux
+session, and the docker project with its network and volumes, which are
+copied to their new names. Running containers are recreated under the new
+project. If a step fails, the completed ones are rolled back.`,
+	Args:        cobra.ExactArgs(2),
+	RunE:        runRename,
+	Annotations: Requires(ToolGit),
+}
+
+var renameWait bool
+
+func init() {
+	envCmd.AddCommand(renameCmd)
+	renameCmd.Flags().BoolVar(&renameWait, "wait", false, "Wait for other operations on the environment to finish instead of failing")
+}
+
+func runRename(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	name, newName := args[0], args[1]
+
+	if err := operations.ValidateEnvironmentName(newName); err != nil {
+		return err
+	}
+
+	resolved, err := ResolveEnvironmentGlobally(name)
+	if err != nil {
+		return err
+	}
+	defer resolved.Close()
+
+	opCtx, stop := interruptContext()
+	defer stop()
+
+	if err := waitForLocks(opCtx, renameWait, resolved.Ctx.DB, resolved.Project, resolved.Environment.Name, newName); err != nil {
+		return err
+	}
+
+	api := NewAPIClient()
+	if api.IsServerRunning() {
+		stop()
+		job, err := api.Rename(resolved.Project.ID, resolved.Environment.Name, newName, renameWait)
+		if err == nil {
+			if err := NewStreamClient().FollowJob(job.ID, 0, true); err != nil {
+				return err
+			}
+			fmt.Printf("✓ Renamed %s to %s\n", name, newName)
+			return nil
+		}
+		if !httpclient.IsServerUnavailable(err) {
+			return err
+		}
+	}
+
+	_, err = operations.RenameEnvironment(opCtx, operations.RenameEnvironmentOptions{
+		DB:          resolved.Ctx.DB,
+		Project:     resolved.Project,
+		Environment: resolved.Environment,
+		NewName:     newName,
+		Wait:        renameWait,
+		Logger:      &operations.StdoutLogger{},
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ Renamed %s to %s\n", name, newName)
+	return nil
+}
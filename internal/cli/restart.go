@@ -15,8 +15,11 @@ var restartCmd = &cobra.Command{
 	Annotations: Requires(ToolDocker),
 }
 
+var restartWait bool
+
 func init() {
 	envCmd.AddCommand(restartCmd)
+	restartCmd.Flags().BoolVar(&restartWait, "wait", false, "Wait for other operations on the environment to finish instead of failing")
 }
 
 func runRestart(cmd *cobra.Command, args []string) error {
@@ -36,6 +39,13 @@ func runRestart(cmd *cobra.Command, args []string) error {
 	}
 	defer resolved.Close()
 
+	opCtx, stop := interruptContext()
+	defer stop()
+
+	if err := waitForLocks(opCtx, restartWait, resolved.Ctx.DB, resolved.Project, resolved.Environment.Name); err != nil {
+		return err
+	}
+
 	if resolved.Environment.DockerProject == "" {
 		fmt.Println("Simple mode environment - no containers to restart")
 		return nil
@@ -43,6 +53,7 @@ func runRestart(cmd *cobra.Command, args []string) error {
 
 	api := NewAPIClient()
 	if api.IsServerRunning() {
+		stop()
 		if err := api.Restart(resolved.Project.ID, resolved.Environment.Name, service); err == nil {
 			if service != "" {
 				fmt.Printf("Restarted %s\n", service)
@@ -53,11 +64,12 @@ func runRestart(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	return operations.RestartEnvironment(operations.RestartEnvironmentOptions{
+	return operations.RestartEnvironment(opCtx, operations.RestartEnvironmentOptions{
 		DB:          resolved.Ctx.DB,
 		Project:     resolved.Project,
 		Environment: resolved.Environment,
 		Service:     service,
+		Wait:        restartWait,
 		Logger:      &operations.StdoutLogger{},
 	})
 }
@@ -38,6 +38,10 @@ func runRm(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("project %q has %d environment(s), use --force to remove anyway or destroy environments first", ctx.Project.Name, len(environments))
 	}
 
+	if _, err := ctx.DB.Backup("rm"); err != nil {
+		return err
+	}
+
 	if err := ctx.DB.DeleteProject(ctx.Project.RootPath); err != nil {
 		return fmt.Errorf("failed to remove project: %w", err)
 	}
@@ -5,24 +5,52 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"slices"
 	"syscall"
 
 	"github.com/gwuah/piko/internal/config"
 	"github.com/gwuah/piko/internal/docker"
 	"github.com/gwuah/piko/internal/env"
+	"github.com/gwuah/piko/internal/operations"
 	"github.com/gwuah/piko/internal/ports"
+	"github.com/gwuah/piko/internal/run"
+	"github.com/gwuah/piko/internal/tmux"
 	"github.com/spf13/cobra"
 )
 
 var runCmd = &cobra.Command{
-	Use:   "run <name>",
+	Use:   "run <name> [process...]",
 	Short: "Execute the run script for an environment",
-	Args:  cobra.ExactArgs(1),
-	RunE:  runRun,
+	Long: `Execute scripts.run from .piko.yml in the foreground.
+
+scripts.run is either a single command or, Procfile style, a map of named
+processes that are started together (dependencies first) with prefixed,
+interleaved output; when one exits the rest are stopped:
+
+  scripts:
+    run:
+      web: bin/rails server -p $PIKO_ENV_ID
+      worker:
+        command: bundle exec sidekiq
+        depends_on: [web]
+        env: {QUEUES: default}
+      assets:
+        command: npm run watch
+        dir: frontend
+        window: true
+
+Processes with 'window: true' (or all of them with --windows) run in their own
+window of the environment's tmux session instead. Pass process names to run a
+subset.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runRun,
 }
 
+var runWindows bool
+
 func init() {
 	envCmd.AddCommand(runCmd)
+	runCmd.Flags().BoolVar(&runWindows, "windows", false, "Run every process in its own tmux window")
 }
 
 func runRun(cmd *cobra.Command, args []string) error {
@@ -34,13 +62,14 @@ func runRun(cmd *cobra.Command, args []string) error {
 		return err
 	}
 	defer resolved.Close()
+	resolved.Touch()
 
 	cfg, err := config.Load(resolved.Environment.Path)
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
-	if cfg.Scripts.Run == "" {
+	if cfg.Scripts.Run.IsEmpty() {
 		return fmt.Errorf("no run script defined in .piko.yml (add scripts.run)")
 	}
 
@@ -57,10 +86,29 @@ func runRun(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	reserved, err := operations.ListEnvironmentPorts(resolved.Ctx.DB, resolved.Environment)
+	if err != nil {
+		return fmt.Errorf("failed to list ports: %w", err)
+	}
+	allocations = append(allocations, reserved...)
+
+	links, err := operations.ResolveLinks(resolved.Ctx.DB, resolved.Project, resolved.Environment)
+	if err != nil {
+		return err
+	}
+	allocations = operations.LinkAllocations(allocations, links)
+
 	pikoEnv := env.Build(resolved.Project, resolved.Environment, allocations)
 	envVars := append(os.Environ(), pikoEnv.ToEnvSlice()...)
 
-	shellCmd := exec.Command("sh", "-c", cfg.Scripts.Run)
+	if cfg.Scripts.Run.Command == "" {
+		return runProcesses(resolved, cfg.Scripts.Run.Processes, args[1:], pikoEnv.ToEnvSlice())
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("scripts.run is a single command (process names need the map form)")
+	}
+
+	shellCmd := exec.Command("sh", "-c", cfg.Scripts.Run.Command)
 	shellCmd.Dir = resolved.Environment.Path
 	shellCmd.Env = envVars
 	shellCmd.Stdin = os.Stdin
@@ -79,3 +127,62 @@ func runRun(cmd *cobra.Command, args []string) error {
 	fmt.Printf("Running scripts.run from .piko.yml...\n")
 	return shellCmd.Run()
 }
+
+func runProcesses(resolved *ResolvedEnvironment, processes map[string]config.Process, only []string, pikoVars []string) error {
+	order, err := config.ProcessOrder(processes)
+	if err != nil {
+		return err
+	}
+
+	if len(only) > 0 {
+		selected := make(map[string]bool)
+		for _, name := range only {
+			if _, ok := processes[name]; !ok {
+				return fmt.Errorf("process %q not defined in scripts.run", name)
+			}
+			selected[name] = true
+		}
+		order = slices.DeleteFunc(order, func(name string) bool { return !selected[name] })
+	}
+
+	sessionName := tmux.SessionName(resolved.Project.Name, resolved.Environment.Name)
+	group := run.NewGroup(os.Stdout)
+	foreground := 0
+
+	for _, name := range order {
+		process := processes[name]
+		dir := process.WorkDir(resolved.Environment.Path)
+		vars := append(slices.Clone(pikoVars), process.EnvSlice()...)
+
+		if process.Window || runWindows {
+			if !tmux.SessionExists(sessionName) {
+				if err := tmux.CreateSession(sessionName, resolved.Environment.Path); err != nil {
+					return fmt.Errorf("failed to create tmux session: %w", err)
+				}
+			}
+			if err := tmux.NewWindowWithEnv(sessionName, name, dir, vars, process.Command); err != nil {
+				return fmt.Errorf("failed to start %s: %w", name, err)
+			}
+			fmt.Printf("→ %s running in tmux window %s:%s\n", name, sessionName, name)
+			continue
+		}
+
+		group.Add(run.GroupProcess{
+			Name:    name,
+			Command: process.Command,
+			Dir:     dir,
+			Env:     vars,
+		})
+		foreground++
+	}
+
+	if foreground == 0 {
+		return nil
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	return group.Run(sigChan)
+}
@@ -3,6 +3,7 @@ package cli
 import (
 	"fmt"
 
+	"github.com/gwuah/piko/internal/proxy"
 	"github.com/gwuah/piko/internal/server"
 	"github.com/gwuah/piko/internal/state"
 	"github.com/spf13/cobra"
@@ -14,11 +15,17 @@ var serverCmd = &cobra.Command{
 	RunE:  runServer,
 }
 
-var serverPort int
+var (
+	serverPort      int
+	serverProxyPort int
+	serverHTTPSPort int
+)
 
 func init() {
 	rootCmd.AddCommand(serverCmd)
 	serverCmd.Flags().IntVar(&serverPort, "port", 19876, "Port to listen on")
+	serverCmd.Flags().IntVar(&serverProxyPort, "proxy-port", proxy.DefaultPort, "Port for the environment reverse proxy (0 to disable)")
+	serverCmd.Flags().IntVar(&serverHTTPSPort, "https-port", proxy.DefaultTLSPort, "Port for the HTTPS environment proxy (0 to disable)")
 }
 
 func runServer(cmd *cobra.Command, args []string) error {
@@ -34,5 +41,12 @@ func runServer(cmd *cobra.Command, args []string) error {
 	}
 
 	srv := server.New(serverPort, db)
+	if serverProxyPort > 0 {
+		p, err := newProxy(db, serverProxyPort, serverHTTPSPort)
+		if err != nil {
+			return err
+		}
+		srv.WithProxy(p)
+	}
 	return srv.Start()
 }
@@ -0,0 +1,153 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gwuah/piko/internal/state"
+	"github.com/spf13/cobra"
+)
+
+var stateCmd = &cobra.Command{
+	Use:   "state",
+	Short: "Export and import piko state",
+	Long: `Export and import the projects and environments registered with piko.
+
+'piko state export' writes a JSON document that 'piko state import' restores,
+e.g. on a new machine. Use --remap when repositories moved. The database is
+also backed up to ~/.piko/backups before migrations, imports and destructive
+operations; the newest backups are kept.`,
+}
+
+var stateExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Write projects and environments as JSON",
+	Args:  cobra.NoArgs,
+	RunE:  runStateExport,
+}
+
+var stateImportCmd = &cobra.Command{
+	Use:   "import <file>",
+	Short: "Register projects and environments from an export",
+	Long: `Register projects and environments from a 'piko state export' document
+('-' reads stdin). Projects already registered at the same path are skipped.
+
+--remap rewrites path prefixes, e.g. --remap /Users/old=/Users/new.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runStateImport,
+}
+
+var (
+	stateExportOutput string
+	stateImportRemap  []string
+)
+
+func init() {
+	rootCmd.AddCommand(stateCmd)
+	stateCmd.AddCommand(stateExportCmd)
+	stateCmd.AddCommand(stateImportCmd)
+	stateExportCmd.Flags().StringVarP(&stateExportOutput, "output", "o", "", "Write to a file instead of stdout")
+	stateImportCmd.Flags().StringArrayVar(&stateImportRemap, "remap", nil, "Rewrite a path prefix (old=new), repeatable")
+}
+
+func runStateExport(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	ctx, err := NewContextWithoutProject()
+	if err != nil {
+		return err
+	}
+	defer ctx.Close()
+
+	doc, err := ctx.DB.Export()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode export: %w", err)
+	}
+	data = append(data, '\n')
+
+	if stateExportOutput == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(stateExportOutput, data, 0600); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "✓ Exported %d project(s) to %s\n", len(doc.Projects), stateExportOutput)
+	return nil
+}
+
+func runStateImport(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+
+	remap, err := parseRemaps(stateImportRemap)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	if args[0] == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(args[0])
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read export: %w", err)
+	}
+
+	var doc state.Export
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("invalid export: %w", err)
+	}
+
+	ctx, err := NewContextWithoutProject()
+	if err != nil {
+		return err
+	}
+	defer ctx.Close()
+
+	if _, err := ctx.DB.Backup("import"); err != nil {
+		return err
+	}
+
+	result, err := ctx.DB.Import(&doc, remap)
+	if err != nil {
+		return err
+	}
+	for _, warning := range result.Warnings {
+		fmt.Printf("  Warning: %s\n", warning)
+	}
+	fmt.Printf("✓ Imported %d project(s) and %d environment(s)\n", result.Projects, result.Environments)
+	return nil
+}
+
+// parseRemaps turns old=new prefix pairs into a path rewriting function.
+func parseRemaps(pairs []string) (func(string) string, error) {
+	type remap struct{ from, to string }
+	var remaps []remap
+	for _, pair := range pairs {
+		from, to, ok := strings.Cut(pair, "=")
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("invalid --remap %q (use old=new)", pair)
+		}
+		remaps = append(remaps, remap{filepath.Clean(from), filepath.Clean(to)})
+	}
+
+	return func(path string) string {
+		for _, r := range remaps {
+			if path == r.from {
+				return r.to
+			}
+			if rest, ok := strings.CutPrefix(path, r.from+string(filepath.Separator)); ok {
+				return filepath.Join(r.to, rest)
+			}
+		}
+		return path
+	}, nil
+}
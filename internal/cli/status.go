@@ -7,6 +7,9 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/gwuah/piko/internal/docker"
+	"github.com/gwuah/piko/internal/git"
+	"github.com/gwuah/piko/internal/operations"
 	"github.com/gwuah/piko/internal/tmux"
 	"github.com/spf13/cobra"
 )
@@ -58,7 +61,37 @@ func runStatus(cmd *cobra.Command, args []string) error {
 	fmt.Printf("Environment: %s\n", resolved.Environment.Name)
 	fmt.Printf("Branch:      %s\n", resolved.Environment.Branch)
 	fmt.Printf("Path:        %s\n", relPath)
+	if metadata, err := resolved.Ctx.DB.GetEnvironmentMetadata(resolved.Environment.ID); err == nil {
+		printMetadata(metadata)
+	}
+	if sparse := git.SparsePatterns(resolved.Environment.Path); len(sparse) > 0 {
+		fmt.Printf("Sparse:      %s\n", strings.Join(sparse, ", "))
+	}
 	fmt.Printf("Tmux:        %s\n", tmuxStatus)
+	if locks, err := resolved.Ctx.DB.ListLocks(resolved.Project.ID); err == nil {
+		for _, l := range locks {
+			if (l.Environment == "" || l.Environment == resolved.Environment.Name) && !l.Stale() {
+				fmt.Printf("Busy:        %s by pid %d (%s), started %s\n", l.Action, l.PID, l.Command, formatAge(l.AcquiredAt))
+			}
+		}
+	}
+	if resolved.Environment.ExpiredAt.Valid {
+		fmt.Printf("Idle:        expired %s\n", formatAge(resolved.Environment.ExpiredAt.Time))
+	} else if resolved.Environment.HibernatedAt.Valid {
+		fmt.Printf("Idle:        hibernated %s\n", formatAge(resolved.Environment.HibernatedAt.Time))
+	}
+
+	links, err := operations.ResolveLinks(resolved.Ctx.DB, resolved.Project, resolved.Environment)
+	if err != nil {
+		return err
+	}
+	for i, link := range links {
+		label := ""
+		if i == 0 {
+			label = "Links:"
+		}
+		fmt.Printf("%-12s %s → %s (%s)\n", label, link.Service, link.Target.Name, linkTargetStatus(resolved, link))
+	}
 
 	isSimpleMode := resolved.Environment.DockerProject == ""
 
@@ -67,6 +100,26 @@ func runStatus(cmd *cobra.Command, args []string) error {
 		dataDir := filepath.Join(resolved.Project.RootPath, ".piko", "data", name)
 		fmt.Printf("Data dir:    %s\n", dataDir)
 		fmt.Printf("Env ID:      %d\n", resolved.Environment.ID)
+
+		reserved, err := operations.ListEnvironmentPorts(resolved.Ctx.DB, resolved.Environment)
+		if err != nil {
+			return fmt.Errorf("failed to list ports: %w", err)
+		}
+		for i, alloc := range reserved {
+			label := ""
+			if i == 0 {
+				label = "Ports:"
+			}
+			fmt.Printf("%-12s %s → localhost:%d\n", label, alloc.Service, alloc.HostPort)
+		}
+
+		if api := NewAPIClient(); api.IsServerRunning() {
+			statuses, err := api.Processes(resolved.Project.ID, resolved.Environment.Name)
+			if err == nil && len(statuses) > 0 {
+				fmt.Println()
+				printProcesses(statuses)
+			}
+		}
 	} else {
 		fmt.Printf("Docker:      %s\n", resolved.Environment.DockerProject)
 
@@ -133,3 +186,11 @@ func getContainerStatus(workDir, projectName string) ([]containerInfo, int, int)
 
 	return containers, running, len(containers)
 }
+
+func linkTargetStatus(resolved *ResolvedEnvironment, link operations.ResolvedLink) string {
+	composeDir := link.Target.Path
+	if resolved.Project.ComposeDir != "" {
+		composeDir = filepath.Join(link.Target.Path, resolved.Project.ComposeDir)
+	}
+	return string(docker.GetProjectStatus(composeDir, link.Target.DockerProject))
+}
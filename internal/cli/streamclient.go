@@ -2,14 +2,22 @@ package cli
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/gwuah/piko/internal/httpclient"
 )
 
+var cliHeader = http.Header{httpclient.ClientHeader: {"cli"}}
+
 type StreamClient struct {
 	baseURL string
 }
@@ -22,6 +30,7 @@ func NewStreamClient() *StreamClient {
 
 type LogMessage struct {
 	Type   string `json:"type"`
+	Seq    int64  `json:"seq"`
 	Source string `json:"source"`
 	Stream string `json:"stream"`
 	Data   string `json:"data"`
@@ -43,107 +52,97 @@ type Environment struct {
 	Status string `json:"status"`
 }
 
-type CreateRequest struct {
-	Action      string `json:"action"`
-	Environment string `json:"environment"`
-	Branch      string `json:"branch"`
-}
-
-type DestroyRequest struct {
-	Action        string `json:"action"`
-	RemoveVolumes bool   `json:"remove_volumes"`
-	DeleteBranch  bool   `json:"delete_branch"`
-}
-
-func (c *StreamClient) CreateEnvironmentStream(projectID int64, name, branch string) error {
-	wsURL := strings.Replace(c.baseURL, "http://", "ws://", 1)
-	wsURL = strings.Replace(wsURL, "https://", "wss://", 1)
-
-	u, err := url.Parse(wsURL)
-	if err != nil {
-		return fmt.Errorf("invalid base URL: %w", err)
-	}
-	u.Path = fmt.Sprintf("/api/ws/projects/%d/environments/create/stream", projectID)
-
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
-	if err != nil {
-		return fmt.Errorf("failed to connect: %w", err)
-	}
-	defer conn.Close()
-
-	req := CreateRequest{
-		Action:      "create",
-		Environment: name,
-		Branch:      branch,
-	}
-	if err := conn.WriteJSON(req); err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
-	}
-
+// followRetries is how many times FollowJob reconnects in a row before
+// giving up.
+const followRetries = 10
+
+// FollowJob prints a job's output from offset until the job finishes and
+// returns its error, if any. A dropped connection is resumed where it left
+// off. With cancel, the first Ctrl-C cancels the job and keeps following
+// while it rolls back; otherwise, or on a second Ctrl-C, it stops following
+// and the job keeps running on the server.
+func (c *StreamClient) FollowJob(id, offset int64, cancel bool) error {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	retries := 0
 	for {
-		_, message, err := conn.ReadMessage()
-		if err != nil {
-			return fmt.Errorf("connection error: %w", err)
-		}
-
-		var baseMsg struct {
-			Type string `json:"type"`
-		}
-		if err := json.Unmarshal(message, &baseMsg); err != nil {
-			continue
-		}
-
-		switch baseMsg.Type {
-		case "log":
-			var logMsg LogMessage
-			if err := json.Unmarshal(message, &logMsg); err != nil {
-				continue
+		before := offset
+		complete, err := c.followJob(id, &offset, interrupt)
+		if errors.Is(err, errInterrupted) {
+			if !cancel {
+				return fmt.Errorf("stopped following job %d; it keeps running on the server (follow: piko job logs %d, cancel: piko job cancel %d)", id, id, id)
 			}
-			c.printLog(logMsg)
-
-		case "complete":
-			var completeMsg CompleteMessage
-			if err := json.Unmarshal(message, &completeMsg); err != nil {
-				return fmt.Errorf("failed to parse completion: %w", err)
+			cancel = false
+			if err := NewAPIClient().CancelJob(id); err != nil {
+				fmt.Fprintf(os.Stderr, "\nCould not cancel job %d: %v (press Ctrl-C again to stop following)\n", id, err)
+			} else {
+				fmt.Fprintf(os.Stderr, "\nCanceling job %d, cleaning up (press Ctrl-C again to stop following)\n", id)
 			}
-
-			if !completeMsg.Success {
-				return fmt.Errorf("%s", completeMsg.Error)
+			continue
+		}
+		if err == nil {
+			if !complete.Success {
+				return fmt.Errorf("%s", complete.Error)
 			}
 			return nil
 		}
+
+		if offset > before {
+			retries = 0
+		}
+		retries++
+		if retries > followRetries {
+			return fmt.Errorf("lost connection to job %d: %w (follow it with: piko job logs %d)", id, err, id)
+		}
+		if retries == 1 {
+			fmt.Fprintf(os.Stderr, "Connection lost, reconnecting to job %d...\n", id)
+		}
+		time.Sleep(time.Second)
 	}
 }
 
-func (c *StreamClient) DestroyEnvironmentStream(projectID int64, name string, removeVolumes, deleteBranch bool) error {
+var errInterrupted = errors.New("interrupted")
+
+func (c *StreamClient) followJob(id int64, offset *int64, interrupt <-chan os.Signal) (*CompleteMessage, error) {
 	wsURL := strings.Replace(c.baseURL, "http://", "ws://", 1)
 	wsURL = strings.Replace(wsURL, "https://", "wss://", 1)
 
 	u, err := url.Parse(wsURL)
 	if err != nil {
-		return fmt.Errorf("invalid base URL: %w", err)
+		return nil, fmt.Errorf("invalid base URL: %w", err)
 	}
-	u.Path = fmt.Sprintf("/api/ws/projects/%d/environments/%s/destroy/stream", projectID, name)
+	u.Path = fmt.Sprintf("/api/ws/jobs/%d/logs", id)
+	u.RawQuery = url.Values{"offset": {strconv.FormatInt(*offset, 10)}}.Encode()
 
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	conn, _, err := websocket.DefaultDialer.Dial(u.String(), cliHeader)
 	if err != nil {
-		return fmt.Errorf("failed to connect: %w", err)
+		return nil, fmt.Errorf("failed to connect: %w", err)
 	}
 	defer conn.Close()
 
-	req := DestroyRequest{
-		Action:        "destroy",
-		RemoveVolumes: removeVolumes,
-		DeleteBranch:  deleteBranch,
-	}
-	if err := conn.WriteJSON(req); err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
-	}
+	interrupted := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-interrupt:
+			close(interrupted)
+			conn.Close()
+		case <-done:
+		}
+	}()
 
 	for {
 		_, message, err := conn.ReadMessage()
 		if err != nil {
-			return fmt.Errorf("connection error: %w", err)
+			select {
+			case <-interrupted:
+				return nil, errInterrupted
+			default:
+			}
+			return nil, fmt.Errorf("connection error: %w", err)
 		}
 
 		var baseMsg struct {
@@ -160,17 +159,14 @@ func (c *StreamClient) DestroyEnvironmentStream(projectID int64, name string, re
 				continue
 			}
 			c.printLog(logMsg)
+			*offset = logMsg.Seq + 1
 
 		case "complete":
 			var completeMsg CompleteMessage
 			if err := json.Unmarshal(message, &completeMsg); err != nil {
-				return fmt.Errorf("failed to parse completion: %w", err)
+				return nil, fmt.Errorf("failed to parse completion: %w", err)
 			}
-
-			if !completeMsg.Success {
-				return fmt.Errorf("%s", completeMsg.Error)
-			}
-			return nil
+			return &completeMsg, nil
 		}
 	}
 }
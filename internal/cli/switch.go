@@ -36,6 +36,7 @@ func runSwitch(cmd *cobra.Command, args []string) error {
 		return err
 	}
 	defer resolved.Close()
+	resolved.Touch()
 
 	sessionName := tmux.SessionName(resolved.Project.Name, resolved.Environment.Name)
 
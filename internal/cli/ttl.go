@@ -0,0 +1,129 @@
+package cli
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/gwuah/piko/internal/config"
+	"github.com/gwuah/piko/internal/operations"
+	"github.com/spf13/cobra"
+)
+
+var ttlCmd = &cobra.Command{
+	Use:   "ttl [name]",
+	Short: "Show or set an environment's idle policy",
+	Long: `Show or set when an idle environment is hibernated and expired.
+
+An environment is idle when no tmux client is attached, no agent activity is
+reported and it hasn't been accessed from the CLI. The running 'piko server'
+stops the containers of environments idle for longer than --hibernate-after
+and marks them expired after --expire-after, destroying them if
+--destroy-expired is set. Per-environment settings override the project's
+'idle' section in .piko.yml; use 0 or "never" to disable a step.`,
+	Args: cobra.RangeArgs(0, 1),
+	RunE: runTTL,
+}
+
+var (
+	ttlHibernateAfter string
+	ttlExpireAfter    string
+	ttlDestroyExpired bool
+	ttlClear          bool
+)
+
+func init() {
+	envCmd.AddCommand(ttlCmd)
+	ttlCmd.Flags().StringVar(&ttlHibernateAfter, "hibernate-after", "", "Stop containers after this much idle time (e.g. 8h)")
+	ttlCmd.Flags().StringVar(&ttlExpireAfter, "expire-after", "", "Mark the environment expired after this much idle time (e.g. 14d)")
+	ttlCmd.Flags().BoolVar(&ttlDestroyExpired, "destroy-expired", false, "Destroy the environment when it expires")
+	ttlCmd.Flags().BoolVar(&ttlClear, "clear", false, "Remove per-environment overrides and use the project policy")
+}
+
+func runTTL(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	name, err := GetEnvNameOrSelect(args)
+	if err != nil {
+		return err
+	}
+
+	resolved, err := ResolveEnvironmentGlobally(name)
+	if err != nil {
+		return err
+	}
+	defer resolved.Close()
+
+	e := resolved.Environment
+	flags := cmd.Flags()
+	changed := ttlClear || flags.Changed("hibernate-after") || flags.Changed("expire-after") || flags.Changed("destroy-expired")
+
+	if changed {
+		hibernateAfter, expireAfter, destroyExpired := e.HibernateAfter, e.ExpireAfter, e.DestroyExpired
+		if ttlClear {
+			hibernateAfter, expireAfter, destroyExpired = sql.NullInt64{}, sql.NullInt64{}, sql.NullBool{}
+		}
+		if flags.Changed("hibernate-after") {
+			if hibernateAfter, err = parseTTL(ttlHibernateAfter); err != nil {
+				return err
+			}
+		}
+		if flags.Changed("expire-after") {
+			if expireAfter, err = parseTTL(ttlExpireAfter); err != nil {
+				return err
+			}
+		}
+		if flags.Changed("destroy-expired") {
+			destroyExpired = sql.NullBool{Bool: ttlDestroyExpired, Valid: true}
+		}
+
+		if err := resolved.Ctx.DB.SetEnvironmentIdlePolicy(e.ID, hibernateAfter, expireAfter, destroyExpired); err != nil {
+			return err
+		}
+		e.HibernateAfter, e.ExpireAfter, e.DestroyExpired = hibernateAfter, expireAfter, destroyExpired
+	}
+
+	cfg, err := config.Load(resolved.Project.RootPath)
+	if err != nil {
+		cfg = &config.Config{}
+	}
+	policy := operations.ResolveIdlePolicy(cfg, e)
+
+	fmt.Printf("Environment:     %s/%s\n", resolved.Project.Name, e.Name)
+	fmt.Printf("Hibernate after: %s%s\n", formatTTL(policy.HibernateAfter), ttlSource(e.HibernateAfter.Valid))
+	fmt.Printf("Expire after:    %s%s\n", formatTTL(policy.ExpireAfter), ttlSource(e.ExpireAfter.Valid))
+	fmt.Printf("Destroy expired: %t%s\n", policy.DestroyExpired, ttlSource(e.DestroyExpired.Valid))
+	return nil
+}
+
+func parseTTL(s string) (sql.NullInt64, error) {
+	if s == "never" {
+		return sql.NullInt64{Int64: 0, Valid: true}, nil
+	}
+	d, err := config.ParseDuration(s)
+	if err != nil {
+		return sql.NullInt64{}, err
+	}
+	return sql.NullInt64{Int64: int64(d / time.Second), Valid: true}, nil
+}
+
+func formatTTL(d time.Duration) string {
+	if d <= 0 {
+		return "never"
+	}
+	switch {
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+	return d.String()
+}
+
+func ttlSource(override bool) string {
+	if override {
+		return " (environment)"
+	}
+	return " (project)"
+}
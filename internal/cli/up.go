@@ -13,8 +13,11 @@ var upCmd = &cobra.Command{
 	Annotations: Requires(ToolDocker),
 }
 
+var upWait bool
+
 func init() {
 	envCmd.AddCommand(upCmd)
+	upCmd.Flags().BoolVar(&upWait, "wait", false, "Wait for other operations on the environment to finish instead of failing")
 }
 
 func runUp(cmd *cobra.Command, args []string) error {
@@ -29,18 +32,28 @@ func runUp(cmd *cobra.Command, args []string) error {
 		return err
 	}
 	defer resolved.Close()
+	resolved.Touch()
+
+	opCtx, stop := interruptContext()
+	defer stop()
+
+	if err := waitForLocks(opCtx, upWait, resolved.Ctx.DB, resolved.Project, resolved.Environment.Name); err != nil {
+		return err
+	}
 
 	api := NewAPIClient()
 	if api.IsServerRunning() {
+		stop()
 		if err := api.Up(resolved.Project.ID, resolved.Environment.Name); err == nil {
 			return nil
 		}
 	}
 
-	return operations.UpEnvironment(operations.UpEnvironmentOptions{
+	return operations.UpEnvironment(opCtx, operations.UpEnvironmentOptions{
 		DB:          resolved.Ctx.DB,
 		Project:     resolved.Project,
 		Environment: resolved.Environment,
+		Wait:        upWait,
 		Logger:      &operations.StdoutLogger{},
 	})
 }
@@ -0,0 +1,40 @@
+package config
+
+import "gopkg.in/yaml.v3"
+
+// EnvFile is a file rendered into each worktree from a Go template. In
+// .piko.yml it is either the template text itself or a mapping naming a
+// template file relative to the worktree.
+type EnvFile struct {
+	Template string `yaml:"template"`
+	From     string `yaml:"from"`
+}
+
+func (f *EnvFile) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		f.Template = node.Value
+		return nil
+	}
+	type plain EnvFile
+	return node.Decode((*plain)(f))
+}
+
+// InjectEnv maps compose services ("*" for all of them) to extra environment
+// entries. Listed services get the PIKO_* variables plus the extras, whose
+// values are templates like env files.
+type InjectEnv map[string]map[string]string
+
+func (i *InjectEnv) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.SequenceNode {
+		var services []string
+		if err := node.Decode(&services); err != nil {
+			return err
+		}
+		*i = make(InjectEnv, len(services))
+		for _, service := range services {
+			(*i)[service] = nil
+		}
+		return nil
+	}
+	return node.Decode((*map[string]map[string]string)(i))
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+type FileMode string
+
+const (
+	FileCopy  FileMode = "copy"
+	FileLink  FileMode = "link"
+	FileClone FileMode = "clone"
+)
+
+// File is an untracked path (or glob) copied from the project root into new
+// worktrees. In .piko.yml it is either a glob, copied, or a mapping with a
+// mode: copy, link (symlink) or clone (copy-on-write, falling back to
+// hardlinks and then copies).
+type File struct {
+	Path string   `yaml:"path"`
+	Mode FileMode `yaml:"mode"`
+}
+
+func (f *File) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		f.Path = node.Value
+	} else {
+		type plain File
+		if err := node.Decode((*plain)(f)); err != nil {
+			return err
+		}
+	}
+
+	if f.Path == "" {
+		return fmt.Errorf("files: entry at line %d needs a path", node.Line)
+	}
+	switch f.Mode {
+	case "":
+		f.Mode = FileCopy
+	case FileCopy, FileLink, FileClone:
+	default:
+		return fmt.Errorf("files: invalid mode %q for %s (use copy, link or clone)", f.Mode, f.Path)
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package config
+
+// Naming sets where worktrees are created and how branches and docker
+// projects are named. Each value is a Go template over .Project, .Name (the
+// environment name) and .User, e.g.
+//
+//	naming:
+//	  worktree_dir: ~/worktrees/{{.Project}}
+//	  branch: "{{.User}}/{{.Name}}"
+//	  docker_project: "{{.Project}}-{{.Name}}"
+//
+// Worktrees are created at <worktree_dir>/<name>; a relative worktree_dir
+// is relative to the project root.
+type Naming struct {
+	WorktreeDir   string `yaml:"worktree_dir"`
+	Branch        string `yaml:"branch"`
+	DockerProject string `yaml:"docker_project"`
+}
+
+const (
+	DefaultWorktreeDir   = ".piko/worktrees"
+	DefaultBranch        = "{{.Name}}"
+	DefaultDockerProject = "piko-{{.Project}}-{{.Name}}"
+)
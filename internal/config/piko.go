@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -14,15 +17,104 @@ type Config struct {
 	Shared  []string          `yaml:"shared"`
 	Shells  map[string]string `yaml:"shells"`
 	Ignore  []string          `yaml:"ignore"`
+	Idle    Idle              `yaml:"idle"`
+	Pool    Pool              `yaml:"pool"`
+	Naming  Naming            `yaml:"naming"`
+	Git     Git               `yaml:"git"`
+
+	// Sparse limits new worktrees to these directories (sparse-checkout
+	// cone patterns). Files at the repository root are always checked out.
+	Sparse []string `yaml:"sparse"`
+
+	// Ports are named host ports reserved per simple mode environment and
+	// exported as PIKO_<NAME>_PORT.
+	Ports []string `yaml:"ports"`
+
+	// Files are untracked files copied or linked from the project root into
+	// each new worktree before prepare runs.
+	Files []File `yaml:"files"`
+
+	// EnvFiles are rendered into each worktree at create and up, keyed by
+	// path relative to the worktree.
+	EnvFiles map[string]EnvFile `yaml:"env_files"`
+
+	// InjectEnv adds environment entries to services in the generated
+	// docker-compose.piko.yml.
+	InjectEnv InjectEnv `yaml:"inject_env"`
+
+	// Processes are long-running commands that piko server keeps running in
+	// the background for simple mode environments.
+	Processes map[string]Process `yaml:"processes"`
 }
 
 type Scripts struct {
 	Prepare string `yaml:"prepare"`
 	Setup   string `yaml:"setup"`
-	Run     string `yaml:"run"`
+	Run     Run    `yaml:"run"`
 	Destroy string `yaml:"destroy"`
 }
 
+// Idle configures when idle environments are hibernated (containers and
+// processes stopped) and expired. A zero duration disables that step.
+type Idle struct {
+	HibernateAfter Duration `yaml:"hibernate_after"`
+	ExpireAfter    Duration `yaml:"expire_after"`
+	DestroyExpired bool     `yaml:"destroy_expired"`
+}
+
+// Pool keeps spare, fully set up environments that piko env create claims
+// instead of building a new one.
+type Pool struct {
+	Size int `yaml:"size"`
+}
+
+// Git controls the steps run after a worktree is checked out: submodules are
+// initialized and LFS objects pulled unless turned off.
+type Git struct {
+	Submodules *bool `yaml:"submodules"`
+	LFS        *bool `yaml:"lfs"`
+}
+
+func (g Git) SubmodulesEnabled() bool {
+	return g.Submodules == nil || *g.Submodules
+}
+
+func (g Git) LFSEnabled() bool {
+	return g.LFS == nil || *g.LFS
+}
+
+// Duration is a time.Duration that also accepts day and week suffixes (14d, 2w).
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	parsed, err := ParseDuration(node.Value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// ParseDuration parses durations like 90m, 36h, 14d or 2w.
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			value, err := strconv.Atoi(n)
+			if err != nil || value < 0 {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(value) * unit, nil
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q (use e.g. 90m, 36h, 14d, 2w)", s)
+	}
+	return d, nil
+}
+
 // Load loads the .piko.yml configuration from the given directory.
 // Returns an empty config if the file doesn't exist (scripts are optional).
 func Load(dir string) (*Config, error) {
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Process is a named long-running command. In .piko.yml it is either a plain
+// command string or a mapping with the fields below.
+type Process struct {
+	Command   string            `yaml:"command"`
+	Dir       string            `yaml:"dir"`
+	Env       map[string]string `yaml:"env"`
+	DependsOn []string          `yaml:"depends_on"`
+	Window    bool              `yaml:"window"`
+}
+
+func (p *Process) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		p.Command = node.Value
+		return nil
+	}
+	type plain Process
+	return node.Decode((*plain)(p))
+}
+
+// WorkDir resolves the process directory relative to the environment path.
+func (p Process) WorkDir(envPath string) string {
+	if p.Dir == "" {
+		return envPath
+	}
+	if filepath.IsAbs(p.Dir) {
+		return p.Dir
+	}
+	return filepath.Join(envPath, p.Dir)
+}
+
+// EnvSlice returns the process environment as KEY=value pairs.
+func (p Process) EnvSlice() []string {
+	keys := make([]string, 0, len(p.Env))
+	for k := range p.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	vars := make([]string, 0, len(keys))
+	for _, k := range keys {
+		vars = append(vars, fmt.Sprintf("%s=%s", k, p.Env[k]))
+	}
+	return vars
+}
+
+// Run is scripts.run: either a single shell command or, Procfile style, a
+// map of named processes started together.
+type Run struct {
+	Command   string
+	Processes map[string]Process
+}
+
+func (r *Run) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		r.Command = node.Value
+		return nil
+	}
+	return node.Decode(&r.Processes)
+}
+
+func (r Run) IsEmpty() bool {
+	return r.Command == "" && len(r.Processes) == 0
+}
+
+// ProcessOrder returns the process names ordered so that every process comes
+// after the ones it depends on. Independent processes are sorted by name.
+func ProcessOrder(processes map[string]Process) ([]string, error) {
+	names := make([]string, 0, len(processes))
+	for name, p := range processes {
+		for _, dep := range p.DependsOn {
+			if _, ok := processes[dep]; !ok {
+				return nil, fmt.Errorf("process %q depends on unknown process %q", name, dep)
+			}
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	marks := make(map[string]int)
+	order := make([]string, 0, len(names))
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch marks[name] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("process dependency cycle: %v", append(path, name))
+		}
+		marks[name] = visiting
+		deps := append([]string(nil), processes[name].DependsOn...)
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		marks[name] = visited
+		order = append(order, name)
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
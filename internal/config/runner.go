@@ -1,13 +1,16 @@
 package config
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
+
+	"github.com/gwuah/piko/internal/run"
 )
 
 type ScriptRunner struct {
+	ctx     context.Context
 	WorkDir string
 	Env     []string
 	Stdout  io.Writer
@@ -16,6 +19,7 @@ type ScriptRunner struct {
 
 func NewScriptRunner(workDir string, env []string) *ScriptRunner {
 	return &ScriptRunner{
+		ctx:     context.Background(),
 		WorkDir: workDir,
 		Env:     env,
 		Stdout:  os.Stdout,
@@ -29,6 +33,13 @@ func (r *ScriptRunner) WithOutput(stdout, stderr io.Writer) *ScriptRunner {
 	return r
 }
 
+// WithContext kills the running script, and everything it started, when ctx
+// is done.
+func (r *ScriptRunner) WithContext(ctx context.Context) *ScriptRunner {
+	r.ctx = ctx
+	return r
+}
+
 func (r *ScriptRunner) RunPrepare(script string) error {
 	if script == "" {
 		return nil
@@ -51,13 +62,16 @@ func (r *ScriptRunner) RunDestroy(script string) error {
 }
 
 func (r *ScriptRunner) run(script string) error {
-	cmd := exec.Command("sh", "-c", script)
+	cmd := run.CommandContext(r.ctx, "sh", "-c", script)
 	cmd.Dir = r.WorkDir
 	cmd.Env = append(os.Environ(), r.Env...)
 	cmd.Stdout = r.Stdout
 	cmd.Stderr = r.Stderr
 
 	if err := cmd.Run(); err != nil {
+		if r.ctx.Err() != nil {
+			return r.ctx.Err()
+		}
 		return fmt.Errorf("script failed: %w", err)
 	}
 	return nil
@@ -0,0 +1,367 @@
+package docker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gwuah/piko/internal/run"
+)
+
+const (
+	composeProjectLabel = "com.docker.compose.project"
+	composeServiceLabel = "com.docker.compose.service"
+
+	// reconcileInterval is how often the cache is checked against docker in
+	// case an event was missed.
+	reconcileInterval = 30 * time.Second
+	// followRetryInterval is how long to wait before subscribing to events
+	// again after docker went away.
+	followRetryInterval = 10 * time.Second
+	// changeDelay batches the burst of events a compose up or down causes
+	// into one change notification per project.
+	changeDelay = 300 * time.Millisecond
+)
+
+// containerEvents are the container events that change what the cache
+// reports; exec and attach events are ignored.
+var containerEvents = []string{
+	"create", "start", "restart", "stop", "die", "kill", "oom",
+	"pause", "unpause", "destroy", "rename", "health_status",
+}
+
+// ContainerCache is an in-memory model of the state of compose containers.
+// It subscribes to `docker events` once and reconciles with `docker inspect`
+// periodically, so reading a project's containers doesn't run docker.
+type ContainerCache struct {
+	onChange func(dockerProject string)
+
+	mu         sync.RWMutex
+	synced     bool
+	containers map[string]Container
+
+	pendingMu sync.Mutex
+	pending   map[string]bool
+	timer     *time.Timer
+}
+
+// NewContainerCache returns a cache that calls onChange with the compose
+// project whose containers changed. It is empty until Run has synced it.
+func NewContainerCache(onChange func(dockerProject string)) *ContainerCache {
+	return &ContainerCache{
+		onChange:   onChange,
+		containers: make(map[string]Container),
+		pending:    make(map[string]bool),
+	}
+}
+
+// Project returns a compose project's containers, sorted by name. ok is
+// false while the cache isn't following docker (or is nil), and callers
+// should ask docker directly.
+func (c *ContainerCache) Project(dockerProject string) (containers []Container, ok bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if !c.synced {
+		return nil, false
+	}
+	for _, container := range c.containers {
+		if container.Project == dockerProject {
+			containers = append(containers, container)
+		}
+	}
+	sort.Slice(containers, func(i, j int) bool { return containers[i].Name < containers[j].Name })
+	return containers, true
+}
+
+// Run keeps the cache up to date until ctx is canceled. If docker isn't
+// available it keeps retrying.
+func (c *ContainerCache) Run(ctx context.Context) {
+	for {
+		err := c.follow(ctx)
+		c.mu.Lock()
+		wasSynced := c.synced
+		c.synced = false
+		c.mu.Unlock()
+		if ctx.Err() != nil {
+			return
+		}
+		if wasSynced {
+			log.Printf("[containers] stopped following docker events: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(followRetryInterval):
+		}
+	}
+}
+
+type containerEvent struct {
+	ID     string `json:"id"`
+	Action string `json:"Action"`
+	Actor  struct {
+		Attributes map[string]string `json:"Attributes"`
+	} `json:"Actor"`
+}
+
+func (c *ContainerCache) follow(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	args := []string{"events", "--format", "{{json .}}",
+		"--filter", "type=container",
+		"--filter", "label=" + composeProjectLabel}
+	for _, event := range containerEvents {
+		args = append(args, "--filter", "event="+event)
+	}
+	cmd := run.CommandContext(ctx, "docker", args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to follow docker events: %w", err)
+	}
+	defer func() {
+		cancel()
+		cmd.Wait()
+	}()
+
+	events := make(chan containerEvent, 64)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var event containerEvent
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || event.ID == "" {
+				continue
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Events that arrive while reconciling are applied afterwards, so
+	// nothing between the two is lost.
+	if err := c.reconcile(ctx); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.synced = true
+	c.mu.Unlock()
+
+	ticker := time.NewTicker(reconcileInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-events:
+			if !ok {
+				return fmt.Errorf("docker events exited")
+			}
+			c.apply(ctx, event)
+		case <-ticker.C:
+			if err := c.reconcile(ctx); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// reconcile replaces the cache with what docker reports now.
+func (c *ContainerCache) reconcile(ctx context.Context) error {
+	output, err := run.Command("docker", "ps", "-aq", "--no-trunc", "--filter", "label="+composeProjectLabel).
+		Context(ctx).
+		Timeout(dockerTimeout).
+		Output()
+	if err != nil {
+		return fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	containers := make(map[string]Container)
+	if ids := strings.Fields(string(output)); len(ids) > 0 {
+		inspected, err := inspectContainers(ctx, ids)
+		if err != nil {
+			return err
+		}
+		for _, container := range inspected {
+			containers[container.ID] = container
+		}
+	}
+
+	c.mu.Lock()
+	old := c.containers
+	c.containers = containers
+	c.mu.Unlock()
+
+	changed := make(map[string]bool)
+	for id, container := range containers {
+		if fingerprint(old[id]) != fingerprint(container) {
+			changed[container.Project] = true
+		}
+	}
+	for id, container := range old {
+		if _, ok := containers[id]; !ok {
+			changed[container.Project] = true
+		}
+	}
+	for project := range changed {
+		c.changed(project)
+	}
+	return nil
+}
+
+// apply updates the container an event is about.
+func (c *ContainerCache) apply(ctx context.Context, event containerEvent) {
+	project := event.Actor.Attributes[composeProjectLabel]
+
+	var updated *Container
+	if event.Action != "destroy" {
+		inspected, err := inspectContainers(ctx, []string{event.ID})
+		if err == nil && len(inspected) == 1 {
+			updated = &inspected[0]
+		}
+	}
+
+	c.mu.Lock()
+	old, existed := c.containers[event.ID]
+	if updated != nil {
+		c.containers[event.ID] = *updated
+	} else {
+		delete(c.containers, event.ID)
+	}
+	c.mu.Unlock()
+
+	if updated == nil && !existed {
+		return
+	}
+	if updated != nil && existed && fingerprint(old) == fingerprint(*updated) {
+		return
+	}
+	if project == "" && existed {
+		project = old.Project
+	}
+	c.changed(project)
+}
+
+// changed notes that a project's containers changed and notifies once the
+// burst of events is over.
+func (c *ContainerCache) changed(project string) {
+	if c.onChange == nil || project == "" {
+		return
+	}
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	c.pending[project] = true
+	if c.timer == nil {
+		c.timer = time.AfterFunc(changeDelay, c.flush)
+	}
+}
+
+func (c *ContainerCache) flush() {
+	c.pendingMu.Lock()
+	pending := c.pending
+	c.pending = make(map[string]bool)
+	c.timer = nil
+	c.pendingMu.Unlock()
+
+	for project := range pending {
+		c.onChange(project)
+	}
+}
+
+// fingerprint is what a client sees of a container; a change to it is
+// worth telling clients about.
+func fingerprint(c Container) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s|%s|%s|%s|%s", c.Project, c.Service, c.Name, c.State, c.Health)
+	for _, p := range c.Publishers {
+		fmt.Fprintf(&b, "|%d:%d", p.TargetPort, p.PublishedPort)
+	}
+	return b.String()
+}
+
+type inspectedContainer struct {
+	ID    string `json:"Id"`
+	Name  string `json:"Name"`
+	State struct {
+		Status string `json:"Status"`
+		Health *struct {
+			Status string `json:"Status"`
+		} `json:"Health"`
+	} `json:"State"`
+	Config struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+	NetworkSettings struct {
+		Ports map[string][]struct {
+			HostPort string `json:"HostPort"`
+		} `json:"Ports"`
+	} `json:"NetworkSettings"`
+}
+
+func inspectContainers(ctx context.Context, ids []string) ([]Container, error) {
+	output, err := run.Command("docker", append([]string{"inspect"}, ids...)...).
+		Context(ctx).
+		Timeout(dockerTimeout).
+		Output()
+	if err != nil && len(output) == 0 {
+		return nil, fmt.Errorf("failed to inspect containers: %w", err)
+	}
+
+	var inspected []inspectedContainer
+	if err := json.Unmarshal(output, &inspected); err != nil {
+		return nil, fmt.Errorf("failed to parse docker inspect output: %w", err)
+	}
+
+	containers := make([]Container, 0, len(inspected))
+	for _, in := range inspected {
+		c := Container{
+			ID:      in.ID,
+			Project: in.Config.Labels[composeProjectLabel],
+			Service: in.Config.Labels[composeServiceLabel],
+			Name:    strings.TrimPrefix(in.Name, "/"),
+			State:   in.State.Status,
+		}
+		if in.State.Health != nil {
+			c.Health = in.State.Health.Status
+		}
+		for port, bindings := range in.NetworkSettings.Ports {
+			target, err := strconv.Atoi(strings.SplitN(port, "/", 2)[0])
+			if err != nil {
+				continue
+			}
+			for _, binding := range bindings {
+				published, err := strconv.Atoi(binding.HostPort)
+				if err != nil {
+					continue
+				}
+				c.Publishers = append(c.Publishers, Publisher{TargetPort: target, PublishedPort: published})
+			}
+		}
+		sort.Slice(c.Publishers, func(i, j int) bool {
+			if c.Publishers[i].TargetPort != c.Publishers[j].TargetPort {
+				return c.Publishers[i].TargetPort < c.Publishers[j].TargetPort
+			}
+			return c.Publishers[i].PublishedPort < c.Publishers[j].PublishedPort
+		})
+		containers = append(containers, c)
+	}
+	return containers, nil
+}
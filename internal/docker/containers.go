@@ -0,0 +1,59 @@
+package docker
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/gwuah/piko/internal/run"
+)
+
+type Publisher struct {
+	TargetPort    int `json:"TargetPort"`
+	PublishedPort int `json:"PublishedPort"`
+}
+
+type Container struct {
+	ID         string      `json:"ID"`
+	Project    string      `json:"Project"`
+	Service    string      `json:"Service"`
+	Name       string      `json:"Name"`
+	State      string      `json:"State"`
+	Health     string      `json:"Health"`
+	Publishers []Publisher `json:"Publishers"`
+}
+
+// ProjectContainers lists a compose project's containers, stopped ones
+// included.
+func ProjectContainers(workDir, projectName string) ([]Container, error) {
+	output, err := run.Command("docker", "compose", "-p", projectName, "ps", "-a", "--format", "json").
+		Dir(workDir).
+		Timeout(dockerTimeout).
+		Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var containers []Container
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if line == "" {
+			continue
+		}
+		var c Container
+		if err := json.Unmarshal([]byte(line), &c); err != nil {
+			continue
+		}
+		containers = append(containers, c)
+	}
+	return containers, nil
+}
+
+// StatusOf summarizes containers the way GetProjectStatus does: running if
+// any container is running.
+func StatusOf(containers []Container) ContainerStatus {
+	for _, c := range containers {
+		if c.State == "running" {
+			return StatusRunning
+		}
+	}
+	return StatusStopped
+}
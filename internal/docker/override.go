@@ -3,14 +3,16 @@ package docker
 import (
 	"fmt"
 	"os"
+	"slices"
+	"strings"
 
 	"github.com/compose-spec/compose-go/v2/types"
 	"github.com/gwuah/piko/internal/ports"
 )
 
-func ApplyOverrides(project *types.Project, projectName, envName string, allocations []ports.Allocation) {
-	pikoPrefix := fmt.Sprintf("piko-%s-%s", projectName, envName)
-
+// ApplyOverrides publishes the allocated host ports and names the default
+// network and volumes after the environment's docker project.
+func ApplyOverrides(project *types.Project, dockerProject string, allocations []ports.Allocation) {
 	portsByService := make(map[string][]types.ServicePortConfig)
 	for _, alloc := range allocations {
 		portsByService[alloc.Service] = append(portsByService[alloc.Service], types.ServicePortConfig{
@@ -28,18 +30,84 @@ func ApplyOverrides(project *types.Project, projectName, envName string, allocat
 
 	project.Networks = types.Networks{
 		"default": types.NetworkConfig{
-			Name: pikoPrefix,
+			Name: dockerProject,
 		},
 	}
 
 	newVolumes := types.Volumes{}
 	for volName, volConfig := range project.Volumes {
-		volConfig.Name = fmt.Sprintf("%s_%s", pikoPrefix, volName)
+		volConfig.Name = fmt.Sprintf("%s_%s", dockerProject, volName)
 		newVolumes[volName] = volConfig
 	}
 	project.Volumes = newVolumes
 }
 
+// Link replaces a service with the container of the same service running in
+// another environment.
+type Link struct {
+	Service   string
+	Network   string
+	Container string
+}
+
+// ApplyLinks removes each linked service and joins the remaining services to
+// the other environment's network, with an external link so the service name
+// resolves to that environment's container.
+func ApplyLinks(project *types.Project, links []Link) {
+	for _, link := range links {
+		delete(project.Services, link.Service)
+
+		if project.Networks == nil {
+			project.Networks = types.Networks{}
+		}
+		project.Networks[link.Network] = types.NetworkConfig{
+			Name:     link.Network,
+			External: true,
+		}
+
+		for name, svc := range project.Services {
+			delete(svc.DependsOn, link.Service)
+			svc.Links = slices.DeleteFunc(svc.Links, func(l string) bool {
+				return l == link.Service || strings.HasPrefix(l, link.Service+":")
+			})
+
+			if svc.NetworkMode != "" {
+				project.Services[name] = svc
+				continue
+			}
+			if svc.Networks == nil {
+				svc.Networks = map[string]*types.ServiceNetworkConfig{"default": nil}
+			}
+			svc.Networks[link.Network] = nil
+			svc.ExternalLinks = append(svc.ExternalLinks, link.Container+":"+link.Service)
+			project.Services[name] = svc
+		}
+	}
+}
+
+// ApplyEnvironment adds KEY=value entries to the environment of services,
+// overriding values from the compose file. A "*" entry applies to every
+// service; entries for a named service win over it. Dollar signs are escaped
+// so compose doesn't interpolate the values again.
+func ApplyEnvironment(project *types.Project, vars map[string][]string) {
+	for name, svc := range project.Services {
+		entries, ok := vars[name]
+		all, hasAll := vars["*"]
+		if !ok && !hasAll {
+			continue
+		}
+		if svc.Environment == nil {
+			svc.Environment = types.MappingWithEquals{}
+		}
+		for _, entry := range append(slices.Clone(all), entries...) {
+			key, value, _ := strings.Cut(entry, "=")
+			value = strings.ReplaceAll(value, "$", "$$")
+			svc.Environment[key] = &value
+		}
+		project.Services[name] = svc
+	}
+}
+
 func WriteProjectFile(path string, project *types.Project) error {
 	data, err := project.MarshalYAML()
 	if err != nil {
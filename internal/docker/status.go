@@ -44,3 +44,10 @@ func GetProjectStatus(workDir, projectName string) ContainerStatus {
 
 	return StatusStopped
 }
+
+func NetworkExists(name string) bool {
+	_, err := run.Command("docker", "network", "inspect", name).
+		Timeout(dockerTimeout).
+		Output()
+	return err == nil
+}
@@ -0,0 +1,59 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gwuah/piko/internal/run"
+)
+
+const volumeCopyTimeout = 30 * time.Minute
+
+// volumeCopyImage is the image used to copy volume contents.
+const volumeCopyImage = "alpine"
+
+func VolumeExists(name string) bool {
+	_, err := run.Command("docker", "volume", "inspect", name).
+		Timeout(dockerTimeout).
+		Output()
+	return err == nil
+}
+
+// CopyVolume creates dst and copies the contents of src into it, preserving
+// ownership and permissions. If ctx is canceled, the copy stops and dst is
+// removed.
+func CopyVolume(ctx context.Context, src, dst string) error {
+	output, err := run.Command("docker", "volume", "create", dst).
+		Timeout(dockerTimeout).
+		CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to create volume %s: %s", dst, string(output))
+	}
+
+	output, err = run.Command("docker", "run", "--rm",
+		"-v", src+":/from:ro",
+		"-v", dst+":/to",
+		volumeCopyImage, "cp", "-a", "/from/.", "/to/").
+		Context(ctx).
+		Timeout(volumeCopyTimeout).
+		CombinedOutput()
+	if err != nil {
+		RemoveVolume(dst)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("failed to copy volume %s to %s: %s", src, dst, string(output))
+	}
+	return nil
+}
+
+func RemoveVolume(name string) error {
+	output, err := run.Command("docker", "volume", "rm", name).
+		Timeout(dockerTimeout).
+		CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to remove volume %s: %s", name, string(output))
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package env
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// Render executes a Go template with the environment as data, e.g.
+// {{ .EnvName }}, {{ .DataDir }} or {{ port "web" }}.
+func (e *PikoEnv) Render(name, text string) (string, error) {
+	funcs := template.FuncMap{
+		"port": func(service string) (int, error) {
+			port, ok := e.Ports[service]
+			if !ok {
+				return 0, fmt.Errorf("no port for %q", service)
+			}
+			return port, nil
+		},
+	}
+
+	tmpl, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	if err := tmpl.Execute(&out, e); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
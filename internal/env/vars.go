@@ -19,13 +19,16 @@ type PikoEnv struct {
 	Project  string
 	Branch   string
 	PortVars map[string]int
+	Ports    map[string]int
 }
 
 func Build(project *state.Project, env *state.Environment, allocations []ports.Allocation) *PikoEnv {
 	portVars := make(map[string]int)
+	servicePorts := make(map[string]int)
 	for _, alloc := range allocations {
 		varName := serviceToVarName(alloc.Service)
 		portVars[varName] = alloc.HostPort
+		servicePorts[alloc.Service] = alloc.HostPort
 	}
 
 	dataDir := filepath.Join(project.RootPath, ".piko", "data", env.Name)
@@ -39,6 +42,7 @@ func Build(project *state.Project, env *state.Environment, allocations []ports.A
 		Project:  project.Name,
 		Branch:   env.Branch,
 		PortVars: portVars,
+		Ports:    servicePorts,
 	}
 }
 
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package files
+
+import "io/fs"
+
+func cloneFile(src, dst string, perm fs.FileMode) error {
+	return errCloneUnsupported
+}
+
+func cloneTree(src, dst string) error {
+	return errCloneUnsupported
+}
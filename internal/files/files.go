@@ -0,0 +1,310 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gwuah/piko/internal/config"
+)
+
+const progressInterval = 2 * time.Second
+
+var errCloneUnsupported = errors.New("copy-on-write clones not supported")
+
+// method is how file contents reach the worktree. Clones degrade to hardlinks
+// and then to copies when the filesystem doesn't support them.
+type method int32
+
+const (
+	methodClone method = iota
+	methodHardlink
+	methodCopy
+)
+
+func (m method) String() string {
+	switch m {
+	case methodClone:
+		return "cloned"
+	case methodHardlink:
+		return "hardlinked"
+	default:
+		return "copied"
+	}
+}
+
+// Populate copies or links the configured files from the project root into a
+// worktree, reporting progress to out. Paths that already exist in the
+// worktree (tracked files) are left alone. It stops when ctx is done.
+func Populate(ctx context.Context, root, worktree string, entries []config.File, out io.Writer) error {
+	for _, entry := range entries {
+		matches, err := filepath.Glob(filepath.Join(root, entry.Path))
+		if err != nil {
+			return fmt.Errorf("invalid files pattern %q: %w", entry.Path, err)
+		}
+		if len(matches) == 0 {
+			fmt.Fprintf(out, "%s: no match\n", entry.Path)
+			continue
+		}
+
+		for _, src := range matches {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, src)
+			if err != nil || !filepath.IsLocal(rel) || skipped(rel) {
+				continue
+			}
+			dst := filepath.Join(worktree, rel)
+
+			// Directories that partly exist are merged unless linked.
+			if _, err := os.Lstat(dst); err == nil && (entry.Mode == config.FileLink || !isDir(src)) {
+				fmt.Fprintf(out, "%s: exists, skipped\n", rel)
+				continue
+			}
+
+			if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+				return fmt.Errorf("failed to create directory for %s: %w", rel, err)
+			}
+
+			if entry.Mode == config.FileLink {
+				if err := os.Symlink(src, dst); err != nil {
+					return fmt.Errorf("failed to link %s: %w", rel, err)
+				}
+				fmt.Fprintf(out, "%s: linked\n", rel)
+				continue
+			}
+
+			start := time.Now()
+			c := &copier{ctx: ctx, rel: rel, out: out}
+			if entry.Mode == config.FileCopy {
+				c.method.Store(int32(methodCopy))
+			}
+			if err := c.run(src, dst); err != nil {
+				return fmt.Errorf("failed to copy %s: %w", rel, err)
+			}
+			fmt.Fprintf(out, "%s: %s %d files (%s) in %s\n", rel, method(c.method.Load()), c.files.Load(),
+				formatBytes(c.bytes.Load()), time.Since(start).Round(time.Millisecond))
+		}
+	}
+	return nil
+}
+
+func isDir(path string) bool {
+	info, err := os.Lstat(path)
+	return err == nil && info.IsDir()
+}
+
+// skipped reports whether a match is piko's or git's own state, which must
+// never be copied into a worktree.
+func skipped(rel string) bool {
+	first, _, _ := strings.Cut(filepath.ToSlash(rel), "/")
+	return first == ".git" || first == ".piko"
+}
+
+// copier copies one file or directory tree. Directories are walked once and
+// their files copied by a pool of workers.
+type copier struct {
+	ctx    context.Context
+	rel    string
+	out    io.Writer
+	method atomic.Int32
+	files  atomic.Int64
+	bytes  atomic.Int64
+}
+
+type job struct {
+	src, dst string
+	info     fs.FileInfo
+}
+
+func (c *copier) run(src, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return c.copyEntry(job{src, dst, info})
+	}
+
+	// A whole directory can be cloned in one call on some platforms.
+	if method(c.method.Load()) == methodClone {
+		if _, err := os.Lstat(dst); os.IsNotExist(err) {
+			if err := cloneTree(src, dst); err == nil {
+				c.countTree(dst)
+				return nil
+			}
+		}
+	}
+
+	jobs := make(chan job)
+	errs := make(chan error, 1)
+	var wg sync.WaitGroup
+	for range min(runtime.NumCPU()*2, 16) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if err := c.copyEntry(j); err != nil {
+					select {
+					case errs <- err:
+					default:
+					}
+				}
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go c.reportProgress(done)
+
+	walkErr := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := c.ctx.Err(); err != nil {
+			return err
+		}
+		select {
+		case err := <-errs:
+			return err
+		default:
+		}
+
+		rel, _ := filepath.Rel(src, path)
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		}
+		jobs <- job{path, target, info}
+		return nil
+	})
+	close(jobs)
+	wg.Wait()
+	close(done)
+
+	if walkErr != nil {
+		return walkErr
+	}
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return nil
+	}
+}
+
+func (c *copier) reportProgress(done <-chan struct{}) {
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			fmt.Fprintf(c.out, "%s: %d files (%s)...\n", c.rel, c.files.Load(), formatBytes(c.bytes.Load()))
+		}
+	}
+}
+
+func (c *copier) copyEntry(j job) error {
+	if _, err := os.Lstat(j.dst); err == nil {
+		return nil
+	}
+
+	switch {
+	case j.info.Mode()&fs.ModeSymlink != 0:
+		target, err := os.Readlink(j.src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, j.dst)
+	case !j.info.Mode().IsRegular():
+		return nil
+	}
+
+	if err := c.copyFile(j); err != nil {
+		return err
+	}
+	c.files.Add(1)
+	c.bytes.Add(j.info.Size())
+	return nil
+}
+
+// copyFile copies a regular file with the best method that works, degrading
+// the method for the rest of the tree once one fails.
+func (c *copier) copyFile(j job) error {
+	for {
+		m := method(c.method.Load())
+		var err error
+		switch m {
+		case methodClone:
+			err = cloneFile(j.src, j.dst, j.info.Mode().Perm())
+		case methodHardlink:
+			err = os.Link(j.src, j.dst)
+		default:
+			return copyFile(j.src, j.dst, j.info.Mode().Perm())
+		}
+		if err == nil {
+			return nil
+		}
+		os.Remove(j.dst)
+		c.method.CompareAndSwap(int32(m), int32(m+1))
+	}
+}
+
+func (c *copier) countTree(root string) {
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				c.files.Add(1)
+				c.bytes.Add(info.Size())
+			}
+		}
+		return nil
+	})
+}
+
+func copyFile(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
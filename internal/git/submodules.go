@@ -0,0 +1,145 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gwuah/piko/internal/run"
+)
+
+// fetchTimeout bounds steps that may download from remotes.
+const fetchTimeout = 10 * time.Minute
+
+// initSubmodules initializes the submodules of a new worktree. Submodules
+// already checked out in the main worktree are cloned from there instead of
+// their remotes.
+func initSubmodules(ctx context.Context, opts WorktreeOptions, worktreePath string) error {
+	if _, err := os.Stat(filepath.Join(worktreePath, ".gitmodules")); err != nil {
+		return nil
+	}
+
+	paths, err := submodulePaths(worktreePath)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		args := []string{"submodule", "update", "--init"}
+		reference := filepath.Join(opts.RepoPath, path)
+		if IsGitRepo(reference) {
+			args = append(args, "--reference", reference, "--dissociate")
+		}
+		args = append(args, "--", path)
+		if err := runWorktreeGit(ctx, opts, worktreePath, fetchTimeout, args...); err != nil {
+			return err
+		}
+	}
+
+	// Nested submodules.
+	return runWorktreeGit(ctx, opts, worktreePath, fetchTimeout, "submodule", "update", "--init", "--recursive")
+}
+
+func submodulePaths(worktreePath string) ([]string, error) {
+	output, err := run.Command("git", "config", "--file", ".gitmodules", "--get-regexp", `^submodule\..*\.path$`).
+		Dir(worktreePath).
+		Timeout(5 * time.Second).
+		Output()
+	if err != nil {
+		// No submodule entries.
+		return nil, nil
+	}
+
+	var paths []string
+	for line := range strings.SplitSeq(strings.TrimSpace(string(output)), "\n") {
+		if _, path, ok := strings.Cut(line, " "); ok {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// pullLFS fetches and checks out the LFS objects of a new worktree. LFS
+// objects are stored in the repository's common git dir, so ones the main
+// worktree already has aren't downloaded again.
+func pullLFS(ctx context.Context, opts WorktreeOptions, worktreePath string) error {
+	if !usesLFS(worktreePath) {
+		return nil
+	}
+	if _, err := exec.LookPath("git-lfs"); err != nil {
+		return fmt.Errorf("repository uses Git LFS but git-lfs is not installed (set git.lfs: false in .piko.yml to skip)")
+	}
+	return runWorktreeGit(ctx, opts, worktreePath, fetchTimeout, "lfs", "pull")
+}
+
+func usesLFS(worktreePath string) bool {
+	data, err := os.ReadFile(filepath.Join(worktreePath, ".gitattributes"))
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(data), "filter=lfs")
+}
+
+// submoduleGitDirs maps the checked-out submodules of a worktree, nested ones
+// included, to their git directories.
+func submoduleGitDirs(worktreePath string) (map[string]string, error) {
+	if _, err := os.Stat(filepath.Join(worktreePath, ".gitmodules")); err != nil {
+		return nil, nil
+	}
+
+	output, err := run.Command("git", "submodule", "foreach", "--recursive", "--quiet", `echo "$displaypath"`).
+		Dir(worktreePath).
+		Timeout(gitTimeout).
+		Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list submodules: %w", err)
+	}
+
+	gitDirs := make(map[string]string)
+	for path := range strings.SplitSeq(strings.TrimSpace(string(output)), "\n") {
+		if path == "" {
+			continue
+		}
+		gitDir, err := run.Command("git", "rev-parse", "--absolute-git-dir").
+			Dir(filepath.Join(worktreePath, path)).
+			Timeout(5 * time.Second).
+			Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find git dir of submodule %s: %w", path, err)
+		}
+		gitDirs[path] = strings.TrimSpace(string(gitDir))
+	}
+	return gitDirs, nil
+}
+
+// relinkSubmodules points the submodules of a moved worktree at their git
+// directories, and the git directories back at the submodules, using
+// relative paths as git does.
+func relinkSubmodules(worktreePath string, gitDirs map[string]string) error {
+	for path, gitDir := range gitDirs {
+		dir := filepath.Join(worktreePath, path)
+		rel, err := filepath.Rel(dir, gitDir)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, ".git"), []byte("gitdir: "+rel+"\n"), 0644); err != nil {
+			return fmt.Errorf("failed to relink submodule %s: %w", path, err)
+		}
+
+		worktree, err := filepath.Rel(gitDir, dir)
+		if err != nil {
+			return err
+		}
+		output, err := run.Command("git", "config", "--file", filepath.Join(gitDir, "config"), "core.worktree", worktree).
+			Timeout(5 * time.Second).
+			CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to relink submodule %s: %s: %w", path, string(output), err)
+		}
+	}
+	return nil
+}
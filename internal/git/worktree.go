@@ -1,6 +1,7 @@
 package git
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -27,8 +28,18 @@ type WorktreeOptions struct {
 	BasePath   string
 	BranchName string
 	RepoPath   string
-	Stdout     io.Writer
-	Stderr     io.Writer
+	// Branch is the branch to create, named after the worktree if empty.
+	Branch string
+	// Sparse limits the checkout to these directories (cone mode).
+	Sparse []string
+	// Submodules and LFS initialize submodules and fetch LFS objects when
+	// the checkout uses them.
+	Submodules bool
+	LFS        bool
+	// Detach checks out a detached HEAD instead of creating a branch.
+	Detach bool
+	Stdout io.Writer
+	Stderr io.Writer
 }
 
 type WorktreeResult struct {
@@ -36,34 +47,120 @@ type WorktreeResult struct {
 	Branch string
 }
 
-func CreateWorktree(opts WorktreeOptions) (*WorktreeResult, error) {
+// CreateWorktree adds a worktree. If ctx is canceled, the git command running
+// is killed and the partial worktree removed.
+func CreateWorktree(ctx context.Context, opts WorktreeOptions) (*WorktreeResult, error) {
 	worktreePath := filepath.Join(opts.BasePath, opts.Name)
 
-	var cmd *run.Cmd
-	if opts.BranchName != "" {
-		cmd = run.Command("git", "worktree", "add", worktreePath, "-b", opts.Name, opts.BranchName)
+	branch := opts.Branch
+	if branch == "" {
+		branch = opts.Name
+	}
+	args := []string{"worktree", "add"}
+	if len(opts.Sparse) > 0 {
+		args = append(args, "--no-checkout")
+	}
+	if opts.Detach {
+		branch = ""
+		args = append(args, "--detach", worktreePath)
+	} else if opts.BranchName != "" {
+		args = append(args, worktreePath, "-b", branch, opts.BranchName)
 	} else {
-		cmd = run.Command("git", "worktree", "add", worktreePath, "-b", opts.Name)
+		args = append(args, worktreePath, "-b", branch)
+	}
+
+	if err := runWorktreeGit(ctx, opts, opts.RepoPath, gitTimeout, args...); err != nil {
+		if ctx.Err() != nil {
+			RemoveWorktree(opts.RepoPath, worktreePath)
+		}
+		return nil, err
+	}
+
+	if len(opts.Sparse) > 0 {
+		sparseArgs := append([]string{"sparse-checkout", "set", "--cone"}, opts.Sparse...)
+		if err := runWorktreeGit(ctx, opts, worktreePath, gitTimeout, sparseArgs...); err != nil {
+			RemoveWorktree(opts.RepoPath, worktreePath)
+			return nil, err
+		}
+		if err := runWorktreeGit(ctx, opts, worktreePath, gitTimeout, "checkout"); err != nil {
+			RemoveWorktree(opts.RepoPath, worktreePath)
+			return nil, err
+		}
+	}
+
+	if opts.Submodules {
+		if err := initSubmodules(ctx, opts, worktreePath); err != nil {
+			RemoveWorktree(opts.RepoPath, worktreePath)
+			return nil, err
+		}
+	}
+	if opts.LFS {
+		if err := pullLFS(ctx, opts, worktreePath); err != nil {
+			RemoveWorktree(opts.RepoPath, worktreePath)
+			return nil, err
+		}
 	}
 
-	if opts.RepoPath != "" {
-		cmd = cmd.Dir(opts.RepoPath)
+	return &WorktreeResult{Path: worktreePath, Branch: branch}, nil
+}
+
+func runWorktreeGit(ctx context.Context, opts WorktreeOptions, dir string, timeout time.Duration, args ...string) error {
+	name := args[0]
+	if len(args) > 1 && !strings.HasPrefix(args[1], "-") {
+		name += " " + args[1]
+	}
+
+	cmd := run.Command("git", args...).Context(ctx)
+	if dir != "" {
+		cmd = cmd.Dir(dir)
 	}
 
 	if opts.Stdout != nil && opts.Stderr != nil {
-		cmd = cmd.Stdout(opts.Stdout).Stderr(opts.Stderr).Timeout(gitTimeout)
+		cmd = cmd.Stdout(opts.Stdout).Stderr(opts.Stderr).Timeout(timeout)
 		if err := cmd.Run(); err != nil {
-			return nil, fmt.Errorf("git worktree add failed: %w", err)
+			if ctx.Err() != nil {
+				return err
+			}
+			return fmt.Errorf("git %s failed: %w", name, err)
 		}
-		return &WorktreeResult{Path: worktreePath, Branch: opts.Name}, nil
+		return nil
 	}
 
-	output, err := cmd.Timeout(gitTimeout).CombinedOutput()
+	output, err := cmd.Timeout(timeout).CombinedOutput()
 	if err != nil {
-		return nil, fmt.Errorf("git worktree add failed: %s: %w", string(output), err)
+		if ctx.Err() != nil {
+			return err
+		}
+		return fmt.Errorf("git %s failed: %s: %w", name, string(output), err)
+	}
+	return nil
+}
+
+// SparsePatterns returns the sparse-checkout patterns of a worktree, or nil
+// if it has a full checkout.
+func SparsePatterns(worktreePath string) []string {
+	enabled, err := run.Command("git", "config", "--bool", "core.sparseCheckout").
+		Dir(worktreePath).
+		Timeout(5 * time.Second).
+		Output()
+	if err != nil || strings.TrimSpace(string(enabled)) != "true" {
+		return nil
 	}
 
-	return &WorktreeResult{Path: worktreePath, Branch: opts.Name}, nil
+	output, err := run.Command("git", "sparse-checkout", "list").
+		Dir(worktreePath).
+		Timeout(5 * time.Second).
+		Output()
+	if err != nil {
+		return nil
+	}
+	var patterns []string
+	for line := range strings.SplitSeq(strings.TrimSpace(string(output)), "\n") {
+		if line != "" {
+			patterns = append(patterns, line)
+		}
+	}
+	return patterns
 }
 
 func BranchExists(repoPath, branchName string) (bool, error) {
@@ -85,6 +182,96 @@ func RemoveWorktree(repoPath, worktreePath string) error {
 	return nil
 }
 
+func MoveWorktree(repoPath, oldPath, newPath string) error {
+	// git refuses to move worktrees with submodules; move the directory and
+	// fix the links ourselves.
+	gitDirs, err := submoduleGitDirs(oldPath)
+	if err != nil {
+		return err
+	}
+	if len(gitDirs) > 0 {
+		if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", filepath.Dir(newPath), err)
+		}
+		if err := os.Rename(oldPath, newPath); err != nil {
+			return fmt.Errorf("failed to move worktree: %w", err)
+		}
+		if err := RepairWorktrees(repoPath, newPath); err != nil {
+			return err
+		}
+		return relinkSubmodules(newPath, gitDirs)
+	}
+
+	output, err := run.Command("git", "worktree", "move", oldPath, newPath).
+		Dir(repoPath).
+		Timeout(gitTimeout).
+		CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git worktree move failed: %s: %w", string(output), err)
+	}
+	return nil
+}
+
+// RepairWorktrees fixes the links between a repository and its worktrees
+// after either moved.
+func RepairWorktrees(repoPath string, worktreePaths ...string) error {
+	args := append([]string{"worktree", "repair"}, worktreePaths...)
+	output, err := run.Command("git", args...).
+		Dir(repoPath).
+		Timeout(gitTimeout).
+		CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git worktree repair failed: %s: %w", string(output), err)
+	}
+	return nil
+}
+
+func RenameBranch(repoPath, oldName, newName string) error {
+	output, err := run.Command("git", "branch", "-m", oldName, newName).
+		Dir(repoPath).
+		Timeout(gitTimeout).
+		CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git branch rename failed: %s: %w", string(output), err)
+	}
+	return nil
+}
+
+// CreateBranchHere creates a branch at HEAD of a worktree and checks it out.
+func CreateBranchHere(worktreePath, branchName string) error {
+	output, err := run.Command("git", "switch", "-c", branchName).
+		Dir(worktreePath).
+		Timeout(gitTimeout).
+		CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git switch failed: %s: %w", string(output), err)
+	}
+	return nil
+}
+
+// Detach checks out a detached HEAD in a worktree.
+func Detach(worktreePath string) error {
+	output, err := run.Command("git", "switch", "--detach").
+		Dir(worktreePath).
+		Timeout(gitTimeout).
+		CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git switch failed: %s: %w", string(output), err)
+	}
+	return nil
+}
+
+func HeadCommit(repoPath string) (string, error) {
+	output, err := run.Command("git", "rev-parse", "HEAD").
+		Dir(repoPath).
+		Timeout(5 * time.Second).
+		Output()
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse failed: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
 func DeleteBranch(repoPath, branchName string) error {
 	output, err := run.Command("git", "branch", "-D", branchName).
 		Dir(repoPath).
@@ -96,6 +283,66 @@ func DeleteBranch(repoPath, branchName string) error {
 	return nil
 }
 
+func CurrentBranch(repoPath string) (string, error) {
+	output, err := run.Command("git", "rev-parse", "--abbrev-ref", "HEAD").
+		Dir(repoPath).
+		Timeout(5 * time.Second).
+		Output()
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse failed: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+func DefaultBranch(repoPath string) (string, error) {
+	output, err := run.Command("git", "symbolic-ref", "--short", "refs/remotes/origin/HEAD").
+		Dir(repoPath).
+		Timeout(5 * time.Second).
+		Output()
+	if err == nil {
+		ref := strings.TrimSpace(string(output))
+		return strings.TrimPrefix(ref, "origin/"), nil
+	}
+
+	for _, candidate := range []string{"main", "master"} {
+		if exists, _ := BranchExists(repoPath, candidate); exists {
+			return candidate, nil
+		}
+	}
+
+	return CurrentBranch(repoPath)
+}
+
+func MergedBranches(repoPath, base string) (map[string]bool, error) {
+	output, err := run.Command("git", "branch", "--merged", base, "--format=%(refname:short)").
+		Dir(repoPath).
+		Timeout(gitTimeout).
+		Output()
+	if err != nil {
+		return nil, fmt.Errorf("git branch --merged failed: %w", err)
+	}
+
+	merged := make(map[string]bool)
+	for line := range strings.SplitSeq(strings.TrimSpace(string(output)), "\n") {
+		if line == "" || line == base {
+			continue
+		}
+		merged[line] = true
+	}
+	return merged, nil
+}
+
+func IsDirty(worktreePath string) (bool, error) {
+	output, err := run.Command("git", "status", "--porcelain").
+		Dir(worktreePath).
+		Timeout(gitTimeout).
+		Output()
+	if err != nil {
+		return false, fmt.Errorf("git status failed: %w", err)
+	}
+	return strings.TrimSpace(string(output)) != "", nil
+}
+
 type BranchInfo struct {
 	Name   string `json:"name"`
 	Commit string `json:"commit"`
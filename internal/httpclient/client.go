@@ -13,6 +13,9 @@ import (
 
 const DefaultServerURL = "http://localhost:19876"
 
+// ClientHeader identifies requests made by the piko CLI.
+const ClientHeader = "X-Piko-Client"
+
 type Client struct {
 	baseURL string
 	http    *http.Client
@@ -72,14 +75,23 @@ func (c *Client) buildURL(path string, params url.Values) string {
 	return u
 }
 
-func (c *Client) Get(path string, params url.Values) (*http.Response, error) {
-	resp, err := c.http.Get(c.buildURL(path, params))
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	req.Header.Set(ClientHeader, "cli")
+	resp, err := c.http.Do(req)
 	if err != nil {
 		return nil, wrapError(err)
 	}
 	return resp, nil
 }
 
+func (c *Client) Get(path string, params url.Values) (*http.Response, error) {
+	req, err := http.NewRequest("GET", c.buildURL(path, params), nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(req)
+}
+
 func (c *Client) Post(path string, body any, params url.Values) (*http.Response, error) {
 	var reader io.Reader
 	if body != nil {
@@ -90,23 +102,33 @@ func (c *Client) Post(path string, body any, params url.Values) (*http.Response,
 		reader = bytes.NewReader(data)
 	}
 
-	resp, err := c.http.Post(c.buildURL(path, params), "application/json", reader)
+	req, err := http.NewRequest("POST", c.buildURL(path, params), reader)
 	if err != nil {
-		return nil, wrapError(err)
+		return nil, err
 	}
-	return resp, nil
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(req)
 }
 
-func (c *Client) Delete(path string, params url.Values) (*http.Response, error) {
-	req, err := http.NewRequest("DELETE", c.buildURL(path, params), nil)
+func (c *Client) Put(path string, body any, params url.Values) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+	req, err := http.NewRequest("PUT", c.buildURL(path, params), bytes.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
-	resp, err := c.http.Do(req)
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(req)
+}
+
+func (c *Client) Delete(path string, params url.Values) (*http.Response, error) {
+	req, err := http.NewRequest("DELETE", c.buildURL(path, params), nil)
 	if err != nil {
-		return nil, wrapError(err)
+		return nil, err
 	}
-	return resp, nil
+	return c.do(req)
 }
 
 func (c *Client) IsServerRunning() bool {
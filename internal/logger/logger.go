@@ -61,6 +61,27 @@ func (l *WriterLogger) Warnf(format string, args ...any) {
 	fmt.Fprintf(l.Err, "Warning: "+format+"\n", args...)
 }
 
+type PrefixLogger struct {
+	Prefix string
+	Next   Logger
+}
+
+func (l *PrefixLogger) Info(msg string) {
+	l.Next.Info(l.Prefix + msg)
+}
+
+func (l *PrefixLogger) Infof(format string, args ...any) {
+	l.Next.Infof("%s"+format, append([]any{l.Prefix}, args...)...)
+}
+
+func (l *PrefixLogger) Warn(msg string) {
+	l.Next.Warn(l.Prefix + msg)
+}
+
+func (l *PrefixLogger) Warnf(format string, args ...any) {
+	l.Next.Warnf("%s"+format, append([]any{l.Prefix}, args...)...)
+}
+
 type FileLogger struct {
 	file  *os.File
 	start time.Time
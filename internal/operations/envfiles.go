@@ -0,0 +1,110 @@
+package operations
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/gwuah/piko/internal/config"
+	"github.com/gwuah/piko/internal/env"
+	"github.com/gwuah/piko/internal/state"
+)
+
+// WriteEnvFiles renders the env_files declared in .piko.yml into the
+// environment's worktree and returns the paths it wrote.
+func WriteEnvFiles(cfg *config.Config, pikoEnv *env.PikoEnv) ([]string, error) {
+	paths := make([]string, 0, len(cfg.EnvFiles))
+	for path := range cfg.EnvFiles {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if !filepath.IsLocal(path) {
+			return nil, fmt.Errorf("env file %q must be a relative path inside the worktree", path)
+		}
+
+		file := cfg.EnvFiles[path]
+		text := file.Template
+		if file.From != "" {
+			if !filepath.IsLocal(file.From) {
+				return nil, fmt.Errorf("template %q for %s must be a relative path inside the worktree", file.From, path)
+			}
+			data, err := os.ReadFile(filepath.Join(pikoEnv.EnvPath, file.From))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read template for %s: %w", path, err)
+			}
+			text = string(data)
+		}
+
+		rendered, err := pikoEnv.Render(path, text)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render %s: %w", path, err)
+		}
+
+		target := filepath.Join(pikoEnv.EnvPath, path)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create directory for %s: %w", path, err)
+		}
+		if err := os.WriteFile(target, []byte(rendered), 0644); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", path, err)
+		}
+	}
+	return paths, nil
+}
+
+// WriteSimpleEnvFiles renders the env files of a simple mode environment with
+// its reserved ports and links.
+func WriteSimpleEnvFiles(db *state.DB, project *state.Project, environment *state.Environment, log Logger) error {
+	cfg, err := config.Load(environment.Path)
+	if err != nil {
+		return err
+	}
+	if len(cfg.EnvFiles) == 0 {
+		return nil
+	}
+
+	reserved, err := ListEnvironmentPorts(db, environment)
+	if err != nil {
+		return err
+	}
+	links, err := ResolveLinks(db, project, environment)
+	if err != nil {
+		return err
+	}
+
+	written, err := WriteEnvFiles(cfg, env.Build(project, environment, LinkAllocations(reserved, links)))
+	if err != nil {
+		return err
+	}
+	for _, path := range written {
+		log.Infof("Wrote %s", path)
+	}
+	return nil
+}
+
+// injectedEnvironment resolves inject_env into KEY=value entries per service:
+// the PIKO_* variables followed by the rendered extras.
+func injectedEnvironment(cfg *config.Config, pikoEnv *env.PikoEnv) (map[string][]string, error) {
+	vars := make(map[string][]string, len(cfg.InjectEnv))
+	for service, extras := range cfg.InjectEnv {
+		entries := pikoEnv.ToEnvSlice()
+
+		keys := make([]string, 0, len(extras))
+		for key := range extras {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			value, err := pikoEnv.Render(service+"."+key, extras[key])
+			if err != nil {
+				return nil, fmt.Errorf("failed to render inject_env %s.%s: %w", service, key, err)
+			}
+			entries = append(entries, key+"="+value)
+		}
+		vars[service] = entries
+	}
+	return vars, nil
+}
@@ -1,24 +1,39 @@
 package operations
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gwuah/piko/internal/config"
 	"github.com/gwuah/piko/internal/docker"
 	"github.com/gwuah/piko/internal/env"
+	"github.com/gwuah/piko/internal/files"
 	"github.com/gwuah/piko/internal/git"
 	"github.com/gwuah/piko/internal/ports"
+	"github.com/gwuah/piko/internal/run"
 	"github.com/gwuah/piko/internal/state"
 	"github.com/gwuah/piko/internal/tmux"
 )
 
+// cleanupTimeout bounds the docker work that has to run even though the
+// operation's context is done, like taking down the containers it started.
+const cleanupTimeout = 2 * time.Minute
+
+func cleanupContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), cleanupTimeout)
+}
+
 type OutputWriters struct {
 	GitStdout     io.Writer
 	GitStderr     io.Writer
+	FilesStdout   io.Writer
 	DockerStdout  io.Writer
 	DockerStderr  io.Writer
 	PrepareStdout io.Writer
@@ -28,12 +43,29 @@ type OutputWriters struct {
 }
 
 type CreateEnvironmentOptions struct {
-	DB          *state.DB
-	Project     *state.Project
-	Name        string
-	Branch      string
-	Logger      Logger
-	Output      *OutputWriters
+	DB      *state.DB
+	Project *state.Project
+	Name    string
+	Branch  string
+	Logger  Logger
+	Output  *OutputWriters
+
+	// Paths are sparse-checkout directories, overriding sparse in .piko.yml.
+	Paths []string
+
+	// Metadata describes the environment. The owner defaults to the current
+	// user.
+	Metadata *state.Metadata
+
+	// Pool creates a spare environment on a detached HEAD, without a tmux
+	// session, for a later create to claim.
+	Pool bool
+
+	// Source is recorded in the event log; it defaults to SourceCLI.
+	Source string
+
+	// Wait waits for conflicting operations to finish instead of failing.
+	Wait bool
 }
 
 type CreateEnvironmentResult struct {
@@ -43,12 +75,72 @@ type CreateEnvironmentResult struct {
 	DataDir     string
 }
 
-func CreateEnvironment(opts CreateEnvironmentOptions) (*CreateEnvironmentResult, error) {
+func environmentMetadata(opts CreateEnvironmentOptions) *state.Metadata {
+	metadata := &state.Metadata{}
+	if opts.Metadata != nil {
+		*metadata = *opts.Metadata
+	}
+	if metadata.Owner == "" {
+		metadata.Owner = currentUser()
+	}
+	return metadata
+}
+
+// sparsePatterns validates sparse-checkout directories and adds the
+// project's compose directory, which has to be checked out.
+func sparsePatterns(project *state.Project, paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	var patterns []string
+	for _, path := range paths {
+		clean := filepath.Clean(path)
+		if !filepath.IsLocal(clean) {
+			return nil, fmt.Errorf("invalid sparse path %q: must be a directory inside the repository", path)
+		}
+		if clean == "." {
+			return nil, nil
+		}
+		patterns = append(patterns, filepath.ToSlash(clean))
+	}
+
+	if project.ComposeDir != "" {
+		composeDir := filepath.ToSlash(filepath.Clean(project.ComposeDir))
+		covered := false
+		for _, pattern := range patterns {
+			if composeDir == pattern || strings.HasPrefix(composeDir, pattern+"/") {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			patterns = append(patterns, composeDir)
+		}
+	}
+	return patterns, nil
+}
+
+// CreateEnvironment creates an environment. If ctx is canceled, the running
+// step is stopped and everything created so far is removed.
+func CreateEnvironment(ctx context.Context, opts CreateEnvironmentOptions) (_ *CreateEnvironmentResult, err error) {
 	log := opts.Logger
 	if log == nil {
 		log = &SilentLogger{}
 	}
 
+	unlock, err := lockEnvironments(ctx, opts.DB, opts.Project, "create", opts.Wait, opts.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	ev := startEvent(opts.DB, log, opts.Project, opts.Name, "create", opts.Source)
+	if opts.Pool {
+		ev.detail("spare")
+	}
+	defer func() { ev.end(err) }()
+
 	exists, err := opts.DB.EnvironmentExists(opts.Project.ID, opts.Name)
 	if err != nil {
 		return nil, fmt.Errorf("failed to check environment: %w", err)
@@ -62,6 +154,19 @@ func CreateEnvironment(opts CreateEnvironmentOptions) (*CreateEnvironmentResult,
 		cfg = &config.Config{}
 	}
 
+	if !opts.Pool && opts.Branch == "" && len(opts.Paths) == 0 && cfg.Pool.Size > 0 {
+		result, err := claimPoolEnvironment(ctx, opts.DB, opts.Project, opts.Name, cfg, log)
+		if err != nil {
+			log.Warnf("failed to claim pool environment, creating a new one: %v", err)
+		}
+		if result != nil {
+			if err := opts.DB.SetEnvironmentMetadata(result.Environment.ID, environmentMetadata(opts)); err != nil {
+				return nil, err
+			}
+			return result, nil
+		}
+	}
+
 	projectComposeDir := opts.Project.RootPath
 	if opts.Project.ComposeDir != "" {
 		projectComposeDir = filepath.Join(opts.Project.RootPath, opts.Project.ComposeDir)
@@ -75,7 +180,21 @@ func CreateEnvironment(opts CreateEnvironmentOptions) (*CreateEnvironmentResult,
 		}
 	}
 
-	worktreesDir := opts.Project.WorktreesDir()
+	names, err := ResolveNames(opts.Project, cfg, opts.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	sparse := opts.Paths
+	if len(sparse) == 0 {
+		sparse = cfg.Sparse
+	}
+	sparse, err = sparsePatterns(opts.Project, sparse)
+	if err != nil {
+		return nil, err
+	}
+
+	worktreesDir := filepath.Dir(names.Path)
 	if err := os.MkdirAll(worktreesDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create worktrees directory: %w", err)
 	}
@@ -84,17 +203,32 @@ func CreateEnvironment(opts CreateEnvironmentOptions) (*CreateEnvironmentResult,
 		Name:       opts.Name,
 		BasePath:   worktreesDir,
 		BranchName: opts.Branch,
+		Branch:     names.Branch,
+		Sparse:     sparse,
+		Submodules: cfg.Git.SubmodulesEnabled(),
+		LFS:        cfg.Git.LFSEnabled(),
 		RepoPath:   opts.Project.RootPath,
+		Detach:     opts.Pool,
 	}
 	if opts.Output != nil {
 		wtOpts.Stdout = opts.Output.GitStdout
 		wtOpts.Stderr = opts.Output.GitStderr
 	}
-	wt, err := git.CreateWorktree(wtOpts)
+	wt, err := git.CreateWorktree(ctx, wtOpts)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		return nil, fmt.Errorf("failed to create worktree: %w", err)
 	}
-	log.Infof("Created worktree at %s (branch: %s)", wt.Path, wt.Branch)
+	if wt.Branch == "" {
+		log.Infof("Created worktree at %s (detached)", wt.Path)
+	} else {
+		log.Infof("Created worktree at %s (branch: %s)", wt.Path, wt.Branch)
+	}
+	if len(sparse) > 0 {
+		log.Infof("Sparse checkout of %s", strings.Join(sparse, ", "))
+	}
 
 	dataDir := filepath.Join(opts.Project.RootPath, ".piko", "data", opts.Name)
 	if err := os.MkdirAll(dataDir, 0755); err != nil {
@@ -110,6 +244,27 @@ func CreateEnvironment(opts CreateEnvironmentOptions) (*CreateEnvironmentResult,
 		git.RemoveWorktree(opts.Project.RootPath, wt.Path)
 	}
 
+	if err := ctx.Err(); err != nil {
+		cleanup()
+		return nil, err
+	}
+
+	if len(cfg.Files) > 0 {
+		var filesOut io.Writer = os.Stdout
+		if opts.Output != nil && opts.Output.FilesStdout != nil {
+			filesOut = opts.Output.FilesStdout
+		}
+
+		log.Info("Copying files into worktree...")
+		if err := files.Populate(ctx, opts.Project.RootPath, wt.Path, cfg.Files, filesOut); err != nil {
+			cleanup()
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+	}
+
 	composeDir := wt.Path
 	if opts.Project.ComposeDir != "" {
 		composeDir = filepath.Join(wt.Path, opts.Project.ComposeDir)
@@ -120,7 +275,7 @@ func CreateEnvironment(opts CreateEnvironmentOptions) (*CreateEnvironmentResult,
 
 	dockerProject := ""
 	if !isSimpleMode {
-		dockerProject = fmt.Sprintf("piko-%s-%s", opts.Project.Name, opts.Name)
+		dockerProject = names.DockerProject
 	}
 	sessionName := tmux.SessionName(opts.Project.Name, opts.Name)
 
@@ -131,6 +286,9 @@ func CreateEnvironment(opts CreateEnvironmentOptions) (*CreateEnvironmentResult,
 		Path:          wt.Path,
 		DockerProject: dockerProject,
 	}
+	if opts.Pool {
+		environment.Pool = state.PoolWarming
+	}
 	envID, err := opts.DB.InsertEnvironment(environment)
 	if err != nil {
 		cleanup()
@@ -144,33 +302,48 @@ func CreateEnvironment(opts CreateEnvironmentOptions) (*CreateEnvironmentResult,
 		cleanup()
 	}
 
+	if !opts.Pool {
+		if err := opts.DB.SetEnvironmentMetadata(environment.ID, environmentMetadata(opts)); err != nil {
+			cleanupWithDB()
+			return nil, err
+		}
+	}
+
 	var allocations []ports.Allocation
 
 	if isSimpleMode {
 		log.Info("Simple mode (no docker-compose found)")
-	} else {
-		composeConfig, err := docker.ParseComposeConfig(composeDir)
+
+		allocations, err = ReservePorts(opts.DB, opts.Project, environment)
 		if err != nil {
 			cleanupWithDB()
-			return nil, fmt.Errorf("failed to parse compose config: %w", err)
+			return nil, fmt.Errorf("failed to reserve ports: %w", err)
+		}
+		for _, alloc := range allocations {
+			log.Infof("Reserved port %d for %s", alloc.HostPort, alloc.Service)
 		}
 
-		servicePorts := composeConfig.GetServicePorts()
-		allocations = ports.Allocate(envID, servicePorts)
-
-		composeProject := composeConfig.Project()
-		docker.ApplyOverrides(composeProject, opts.Project.Name, opts.Name, allocations)
-		pikoComposePath := filepath.Join(composeDir, "docker-compose.piko.yml")
-		if err := docker.WriteProjectFile(pikoComposePath, composeProject); err != nil {
+		if err := WriteSimpleEnvFiles(opts.DB, opts.Project, environment, log); err != nil {
 			cleanupWithDB()
-			return nil, fmt.Errorf("failed to write compose file: %w", err)
+			return nil, err
+		}
+	} else {
+		allocations, err = WriteComposeFile(opts.DB, opts.Project, environment)
+		if err != nil {
+			cleanupWithDB()
+			return nil, err
 		}
 		log.Info("Generated docker-compose.piko.yml")
 	}
 
+	if err := ctx.Err(); err != nil {
+		cleanupWithDB()
+		return nil, err
+	}
+
 	if cfg.Scripts.Prepare != "" {
 		pikoEnv := env.Build(opts.Project, environment, allocations)
-		runner := config.NewScriptRunner(wt.Path, pikoEnv.ToEnvSlice())
+		runner := config.NewScriptRunner(wt.Path, pikoEnv.ToEnvSlice()).WithContext(ctx)
 		if opts.Output != nil && opts.Output.PrepareStdout != nil && opts.Output.PrepareStderr != nil {
 			runner.WithOutput(opts.Output.PrepareStdout, opts.Output.PrepareStderr)
 		}
@@ -178,14 +351,22 @@ func CreateEnvironment(opts CreateEnvironmentOptions) (*CreateEnvironmentResult,
 		log.Info("Running prepare script...")
 		if err := runner.RunPrepare(cfg.Scripts.Prepare); err != nil {
 			cleanupWithDB()
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
 			return nil, fmt.Errorf("prepare script failed: %w", err)
 		}
 		log.Info("Ran prepare script")
 	}
 
+	if err := ctx.Err(); err != nil {
+		cleanupWithDB()
+		return nil, err
+	}
+
 	cleanupWithContainers := cleanupWithDB
 	if !isSimpleMode {
-		composeCmd := exec.Command("docker", "compose",
+		composeCmd := run.CommandContext(ctx, "docker", "compose",
 			"-p", dockerProject,
 			"-f", "docker-compose.piko.yml",
 			"up", "-d")
@@ -195,23 +376,39 @@ func CreateEnvironment(opts CreateEnvironmentOptions) (*CreateEnvironmentResult,
 			composeCmd.Stderr = opts.Output.DockerStderr
 		}
 
+		stopContainers := func() {
+			log.Infof("Stopping containers (%s)", dockerProject)
+			cleanupCtx, cancel := cleanupContext()
+			defer cancel()
+			if err := composeProject(cleanupCtx, composeDir, dockerProject, "down"); err != nil {
+				log.Warnf("failed to stop containers: %v", err)
+			}
+		}
 		if err := composeCmd.Run(); err != nil {
+			if ctx.Err() != nil {
+				stopContainers()
+				cleanupWithDB()
+				return nil, ctx.Err()
+			}
 			cleanupWithDB()
 			return nil, fmt.Errorf("failed to start containers: %w", err)
 		}
 		log.Infof("Started containers (%s)", dockerProject)
 
 		cleanupWithContainers = func() {
-			stopCmd := exec.Command("docker", "compose", "-p", dockerProject, "down")
-			stopCmd.Dir = composeDir
-			stopCmd.Run()
+			stopContainers()
 			cleanupWithDB()
 		}
 	}
 
+	if err := ctx.Err(); err != nil {
+		cleanupWithContainers()
+		return nil, err
+	}
+
 	if cfg.Scripts.Setup != "" {
 		pikoEnv := env.Build(opts.Project, environment, allocations)
-		runner := config.NewScriptRunner(wt.Path, pikoEnv.ToEnvSlice())
+		runner := config.NewScriptRunner(wt.Path, pikoEnv.ToEnvSlice()).WithContext(ctx)
 		if opts.Output != nil && opts.Output.SetupStdout != nil && opts.Output.SetupStderr != nil {
 			runner.WithOutput(opts.Output.SetupStdout, opts.Output.SetupStderr)
 		}
@@ -219,25 +416,53 @@ func CreateEnvironment(opts CreateEnvironmentOptions) (*CreateEnvironmentResult,
 		log.Info("Running setup script...")
 		if err := runner.RunSetup(cfg.Scripts.Setup); err != nil {
 			cleanupWithContainers()
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
 			return nil, fmt.Errorf("setup script failed: %w", err)
 		}
 		log.Info("Ran setup script")
 	}
 
+	if opts.Pool {
+		if _, err := opts.DB.SetEnvironmentPool(environment.ID, state.PoolWarming, state.PoolReady); err != nil {
+			cleanupWithContainers()
+			return nil, err
+		}
+		environment.Pool = state.PoolReady
+	} else {
+		createSession(opts.Project, environment, cfg, allocations, log)
+	}
+
+	log.Info("Environment ready")
+
+	return &CreateEnvironmentResult{
+		Environment: environment,
+		SessionName: sessionName,
+		IsSimple:    isSimpleMode,
+		DataDir:     dataDir,
+	}, nil
+}
+
+// createSession starts the environment's tmux session with its service
+// windows, shells and PIKO_* variables.
+func createSession(project *state.Project, environment *state.Environment, cfg *config.Config, allocations []ports.Allocation, log Logger) {
 	var services []string
-	if !isSimpleMode {
-		composeConfig, _ := docker.ParseComposeConfig(composeDir)
+	if environment.DockerProject != "" {
+		composeConfig, _ := docker.ParseComposeConfig(composeDirFor(project, environment))
 		if composeConfig != nil {
 			services = composeConfig.GetServiceNames()
 		}
 	}
 
+	sessionName := tmux.SessionName(project.Name, environment.Name)
 	tmuxCfg := tmux.SessionConfig{
 		SessionName:   sessionName,
-		WorkDir:       wt.Path,
-		DockerProject: dockerProject,
+		WorkDir:       environment.Path,
+		DockerProject: environment.DockerProject,
 		Services:      services,
 		Shells:        cfg.Shells,
+		Env:           env.Build(project, environment, allocations).ToEnvSlice(),
 	}
 
 	if err := tmux.CreateFullSession(tmuxCfg); err != nil {
@@ -245,15 +470,6 @@ func CreateEnvironment(opts CreateEnvironmentOptions) (*CreateEnvironmentResult,
 	} else {
 		log.Infof("Created tmux session %s", sessionName)
 	}
-
-	log.Info("Environment ready")
-
-	return &CreateEnvironmentResult{
-		Environment: environment,
-		SessionName: sessionName,
-		IsSimple:    isSimpleMode,
-		DataDir:     dataDir,
-	}, nil
 }
 
 type DestroyOutputWriters struct {
@@ -271,22 +487,54 @@ type DestroyEnvironmentOptions struct {
 	DeleteBranch  bool
 	Logger        Logger
 	Output        *DestroyOutputWriters
+
+	// Source is recorded in the event log; it defaults to SourceCLI.
+	Source string
+
+	// Wait waits for conflicting operations to finish instead of failing.
+	Wait bool
+
+	// GitLock, if set, is held while the worktree and branch are removed, so
+	// destroys running side by side in one repository don't contend for
+	// git's index and ref locks.
+	GitLock sync.Locker
+
+	// StopProcesses stops the environment's supervised processes, which run
+	// in the worktree, before it is removed.
+	StopProcesses func(*state.Environment)
 }
 
-func DestroyEnvironment(opts DestroyEnvironmentOptions) error {
+// DestroyEnvironment removes an environment. ctx can cancel it while the
+// destroy script runs, before anything is removed; after that the destroy
+// runs to the end.
+func DestroyEnvironment(ctx context.Context, opts DestroyEnvironmentOptions) error {
+	unlock, err := lockEnvironments(ctx, opts.DB, opts.Project, "destroy", opts.Wait, opts.Environment.Name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return destroyEnvironment(ctx, opts)
+}
+
+func destroyEnvironment(ctx context.Context, opts DestroyEnvironmentOptions) (err error) {
 	log := opts.Logger
 	if log == nil {
 		log = &SilentLogger{}
 	}
 
+	ev := startEvent(opts.DB, log, opts.Project, opts.Environment.Name, "destroy", opts.Source)
+	ev.detail(destroyDetail(opts))
+	defer func() { ev.end(err) }()
+
 	cfg, err := config.Load(opts.Project.RootPath)
 	if err != nil {
 		cfg = &config.Config{}
 	}
 
 	if cfg.Scripts.Destroy != "" {
-		pikoEnv := env.Build(opts.Project, opts.Environment, []ports.Allocation{})
-		runner := config.NewScriptRunner(opts.Environment.Path, pikoEnv.ToEnvSlice())
+		allocations, _ := ListEnvironmentPorts(opts.DB, opts.Environment)
+		pikoEnv := env.Build(opts.Project, opts.Environment, allocations)
+		runner := config.NewScriptRunner(opts.Environment.Path, pikoEnv.ToEnvSlice()).WithContext(ctx)
 		if opts.Output != nil && opts.Output.DestroyStdout != nil && opts.Output.DestroyStderr != nil {
 			runner.WithOutput(opts.Output.DestroyStdout, opts.Output.DestroyStderr)
 		}
@@ -296,6 +544,14 @@ func DestroyEnvironment(opts DestroyEnvironmentOptions) error {
 			log.Warnf("destroy script failed: %v", err)
 		}
 	}
+	if err := ctx.Err(); err != nil {
+		log.Info("Destroy canceled; nothing was removed")
+		return err
+	}
+
+	if opts.StopProcesses != nil {
+		opts.StopProcesses(opts.Environment)
+	}
 
 	isSimpleMode := opts.Environment.DockerProject == ""
 
@@ -305,12 +561,15 @@ func DestroyEnvironment(opts DestroyEnvironmentOptions) error {
 			composeDir = filepath.Join(opts.Environment.Path, opts.Project.ComposeDir)
 		}
 
-		var composeCmd *exec.Cmd
+		// Past the cancellation check destroy runs to the end, so the
+		// containers come down even if ctx is canceled now.
+		downCtx, cancel := cleanupContext()
+		defer cancel()
+		args := []string{"compose", "-p", opts.Environment.DockerProject, "down"}
 		if opts.RemoveVolumes {
-			composeCmd = exec.Command("docker", "compose", "-p", opts.Environment.DockerProject, "down", "-v")
-		} else {
-			composeCmd = exec.Command("docker", "compose", "-p", opts.Environment.DockerProject, "down")
+			args = append(args, "-v")
 		}
+		composeCmd := run.CommandContext(downCtx, "docker", args...)
 		composeCmd.Dir = composeDir
 		if opts.Output != nil && opts.Output.DockerStdout != nil && opts.Output.DockerStderr != nil {
 			composeCmd.Stdout = opts.Output.DockerStdout
@@ -327,20 +586,29 @@ func DestroyEnvironment(opts DestroyEnvironmentOptions) error {
 		}
 	}
 
+	if opts.GitLock != nil {
+		opts.GitLock.Lock()
+	}
 	if err := git.RemoveWorktree(opts.Project.RootPath, opts.Environment.Path); err != nil {
 		log.Warnf("failed to remove worktree: %v", err)
 	} else {
 		log.Info("Removed worktree")
 	}
 
-	if opts.DeleteBranch {
-		if err := git.DeleteBranch(opts.Project.RootPath, opts.Environment.Name); err != nil {
-			log.Warnf("failed to delete branch: %v", err)
+	// Detached worktrees, like pool spares, have no branch.
+	if branch := opts.Environment.Branch; branch != "" {
+		if opts.DeleteBranch {
+			if err := git.DeleteBranch(opts.Project.RootPath, branch); err != nil {
+				log.Warnf("failed to delete branch: %v", err)
+			} else {
+				log.Infof("Deleted branch %s", branch)
+			}
 		} else {
-			log.Infof("Deleted branch %s", opts.Environment.Name)
+			log.Infof("Branch %q preserved (commits remain). Use --force to delete.", branch)
 		}
-	} else {
-		log.Infof("Branch %q preserved (commits remain). Use --force to delete.", opts.Environment.Name)
+	}
+	if opts.GitLock != nil {
+		opts.GitLock.Unlock()
 	}
 
 	dataDir := filepath.Join(opts.Project.RootPath, ".piko", "data", opts.Environment.Name)
@@ -350,11 +618,20 @@ func DestroyEnvironment(opts DestroyEnvironmentOptions) error {
 		log.Info("Removed data directory")
 	}
 
+	dependents, err := opts.DB.ListLinksToEnvironment(opts.Environment.ID)
+	if err != nil {
+		log.Warnf("failed to list links: %v", err)
+	}
+
 	if err := opts.DB.DeleteEnvironment(opts.Project.ID, opts.Environment.Name); err != nil {
 		return fmt.Errorf("failed to remove from database: %w", err)
 	}
 	log.Info("Removed from database")
 
+	for _, link := range dependents {
+		unlinkDestroyed(opts.DB, opts.Project, link, log)
+	}
+
 	sessionName := tmux.SessionName(opts.Project.Name, opts.Environment.Name)
 	if tmux.SessionExists(sessionName) {
 		if err := tmux.KillSession(sessionName); err != nil {
@@ -367,20 +644,58 @@ func DestroyEnvironment(opts DestroyEnvironmentOptions) error {
 	return nil
 }
 
+func destroyDetail(opts DestroyEnvironmentOptions) string {
+	var parts []string
+	if opts.RemoveVolumes {
+		parts = append(parts, "volumes removed")
+	}
+	if opts.DeleteBranch && opts.Environment.Branch != "" {
+		parts = append(parts, "branch deleted")
+	}
+	return strings.Join(parts, ", ")
+}
+
 type UpEnvironmentOptions struct {
 	DB          *state.DB
 	Project     *state.Project
 	Environment *state.Environment
 	Logger      Logger
+
+	// Source is recorded in the event log; it defaults to SourceCLI.
+	Source string
+
+	// Wait waits for conflicting operations to finish instead of failing.
+	Wait bool
 }
 
-func UpEnvironment(opts UpEnvironmentOptions) error {
+// UpEnvironment starts an environment's containers. If ctx is canceled while
+// they start, the ones already started are stopped again.
+func UpEnvironment(ctx context.Context, opts UpEnvironmentOptions) (err error) {
 	log := opts.Logger
 	if log == nil {
 		log = &SilentLogger{}
 	}
 
+	unlock, err := lockEnvironments(ctx, opts.DB, opts.Project, "up", opts.Wait, opts.Environment.Name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	ev := startEvent(opts.DB, log, opts.Project, opts.Environment.Name, "up", opts.Source)
+	defer func() { ev.end(err) }()
+
 	if opts.Environment.DockerProject == "" {
+		if _, err := ReservePorts(opts.DB, opts.Project, opts.Environment); err != nil {
+			return fmt.Errorf("failed to reserve ports: %w", err)
+		}
+		if err := WriteSimpleEnvFiles(opts.DB, opts.Project, opts.Environment, log); err != nil {
+			return err
+		}
+		if err := opts.DB.SetEnvironmentHibernated(opts.Environment.ID, false); err != nil {
+			log.Warnf("failed to clear hibernation: %v", err)
+		}
+		opts.DB.TouchEnvironment(opts.Environment.ID)
 		log.Info("Simple mode environment - no containers to start")
 		log.Info("Use 'piko env attach' to access the tmux session")
 		return nil
@@ -395,31 +710,49 @@ func UpEnvironment(opts UpEnvironmentOptions) error {
 		composeDir = filepath.Join(opts.Environment.Path, opts.Project.ComposeDir)
 	}
 
-	composeConfig, err := docker.ParseComposeConfig(composeDir)
-	if err != nil {
-		return fmt.Errorf("failed to parse compose config: %w", err)
+	if _, err := WriteComposeFile(opts.DB, opts.Project, opts.Environment); err != nil {
+		return err
 	}
 
-	servicePorts := composeConfig.GetServicePorts()
-	allocations := ports.Allocate(opts.Environment.ID, servicePorts)
-
-	composeProject := composeConfig.Project()
-	docker.ApplyOverrides(composeProject, opts.Project.Name, opts.Environment.Name, allocations)
-	pikoComposePath := filepath.Join(composeDir, "docker-compose.piko.yml")
-	docker.WriteProjectFile(pikoComposePath, composeProject)
+	links, err := ResolveLinks(opts.DB, opts.Project, opts.Environment)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		if link.Network != "" && !docker.NetworkExists(link.Network) {
+			return fmt.Errorf("%s is linked to %s, which is not running (start it with: piko env up %s)",
+				link.Service, link.Target.Name, link.Target.Name)
+		}
+	}
 
-	composeCmd := exec.Command("docker", "compose",
+	composeCmd := run.CommandContext(ctx, "docker", "compose",
 		"-p", opts.Environment.DockerProject,
 		"-f", "docker-compose.piko.yml",
-		"up", "-d")
+		"up", "-d", "--remove-orphans")
 	composeCmd.Dir = composeDir
 
 	output, err := composeCmd.CombinedOutput()
 	if err != nil {
+		if ctx.Err() != nil {
+			log.Infof("Stopping containers (%s)", opts.Environment.DockerProject)
+			cleanupCtx, cancel := cleanupContext()
+			defer cancel()
+			if err := composeProject(cleanupCtx, composeDir, opts.Environment.DockerProject, "down"); err != nil {
+				log.Warnf("failed to stop containers: %v", err)
+			}
+			return ctx.Err()
+		}
 		return fmt.Errorf("failed to start containers: %s", string(output))
 	}
 
 	log.Infof("Started containers (%s)", opts.Environment.DockerProject)
+
+	if err := opts.DB.SetEnvironmentHibernated(opts.Environment.ID, false); err != nil {
+		log.Warnf("failed to clear hibernation: %v", err)
+	}
+	if err := opts.DB.TouchEnvironment(opts.Environment.ID); err != nil {
+		log.Warnf("failed to record activity: %v", err)
+	}
 	return nil
 }
 
@@ -428,14 +761,32 @@ type DownEnvironmentOptions struct {
 	Project     *state.Project
 	Environment *state.Environment
 	Logger      Logger
+
+	// Source is recorded in the event log; it defaults to SourceCLI.
+	Source string
+
+	// Wait waits for conflicting operations to finish instead of failing.
+	Wait bool
+}
+
+func DownEnvironment(ctx context.Context, opts DownEnvironmentOptions) error {
+	unlock, err := lockEnvironments(ctx, opts.DB, opts.Project, "down", opts.Wait, opts.Environment.Name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return downEnvironment(ctx, opts)
 }
 
-func DownEnvironment(opts DownEnvironmentOptions) error {
+func downEnvironment(ctx context.Context, opts DownEnvironmentOptions) (err error) {
 	log := opts.Logger
 	if log == nil {
 		log = &SilentLogger{}
 	}
 
+	ev := startEvent(opts.DB, log, opts.Project, opts.Environment.Name, "down", opts.Source)
+	defer func() { ev.end(err) }()
+
 	if opts.Environment.DockerProject == "" {
 		log.Info("Simple mode environment - no containers to stop")
 		return nil
@@ -446,11 +797,14 @@ func DownEnvironment(opts DownEnvironmentOptions) error {
 		composeDir = filepath.Join(opts.Environment.Path, opts.Project.ComposeDir)
 	}
 
-	composeCmd := exec.Command("docker", "compose", "-p", opts.Environment.DockerProject, "down")
+	composeCmd := run.CommandContext(ctx, "docker", "compose", "-p", opts.Environment.DockerProject, "down")
 	composeCmd.Dir = composeDir
 
 	output, err := composeCmd.CombinedOutput()
 	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		return fmt.Errorf("failed to stop containers: %s", string(output))
 	}
 
@@ -464,14 +818,30 @@ type RestartEnvironmentOptions struct {
 	Environment *state.Environment
 	Service     string
 	Logger      Logger
+
+	// Source is recorded in the event log; it defaults to SourceCLI.
+	Source string
+
+	// Wait waits for conflicting operations to finish instead of failing.
+	Wait bool
 }
 
-func RestartEnvironment(opts RestartEnvironmentOptions) error {
+func RestartEnvironment(ctx context.Context, opts RestartEnvironmentOptions) (err error) {
 	log := opts.Logger
 	if log == nil {
 		log = &SilentLogger{}
 	}
 
+	unlock, err := lockEnvironments(ctx, opts.DB, opts.Project, "restart", opts.Wait, opts.Environment.Name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	ev := startEvent(opts.DB, log, opts.Project, opts.Environment.Name, "restart", opts.Source)
+	ev.detail(opts.Service)
+	defer func() { ev.end(err) }()
+
 	if opts.Environment.DockerProject == "" {
 		log.Info("Simple mode environment - no containers to restart")
 		return nil
@@ -484,14 +854,17 @@ func RestartEnvironment(opts RestartEnvironmentOptions) error {
 
 	var composeCmd *exec.Cmd
 	if opts.Service != "" {
-		composeCmd = exec.Command("docker", "compose", "-p", opts.Environment.DockerProject, "restart", opts.Service)
+		composeCmd = run.CommandContext(ctx, "docker", "compose", "-p", opts.Environment.DockerProject, "restart", opts.Service)
 	} else {
-		composeCmd = exec.Command("docker", "compose", "-p", opts.Environment.DockerProject, "restart")
+		composeCmd = run.CommandContext(ctx, "docker", "compose", "-p", opts.Environment.DockerProject, "restart")
 	}
 	composeCmd.Dir = composeDir
 
 	output, err := composeCmd.CombinedOutput()
 	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		return fmt.Errorf("failed to restart containers: %s", string(output))
 	}
 
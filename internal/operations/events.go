@@ -0,0 +1,76 @@
+package operations
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gwuah/piko/internal/state"
+)
+
+// Where an operation was started from: the piko CLI, a client of the API
+// (such as the web UI), or piko server itself (idle reaper, pool).
+const (
+	SourceCLI    = "cli"
+	SourceAPI    = "api"
+	SourceServer = "server"
+)
+
+type event struct {
+	db    *state.DB
+	log   Logger
+	event state.Event
+}
+
+// RunOperation runs fn under the environment's lock and records it in the
+// event log, for operations that happen outside this package (e.g. the server
+// starting supervised processes).
+func RunOperation(ctx context.Context, db *state.DB, project *state.Project, environment, action, source string, fn func() error) error {
+	unlock, err := lockEnvironments(ctx, db, project, action, false, environment)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	ev := startEvent(db, &SilentLogger{}, project, environment, action, source)
+	err = fn()
+	ev.end(err)
+	return err
+}
+
+// startEvent begins recording an operation; end records its outcome.
+func startEvent(db *state.DB, log Logger, project *state.Project, environment, action, source string) *event {
+	if source == "" {
+		source = SourceCLI
+	}
+	return &event{
+		db:  db,
+		log: log,
+		event: state.Event{
+			ProjectID:   project.ID,
+			Environment: environment,
+			Action:      action,
+			Source:      source,
+			Actor:       currentUser(),
+			StartedAt:   time.Now(),
+		},
+	}
+}
+
+func (e *event) detail(detail string) {
+	e.event.Detail = detail
+}
+
+func (e *event) end(err error) {
+	e.event.Duration = time.Since(e.event.StartedAt)
+	e.event.Status = state.EventOK
+	if errors.Is(err, context.Canceled) {
+		e.event.Status = state.EventCanceled
+	} else if err != nil {
+		e.event.Status = state.EventFailed
+		e.event.Error = err.Error()
+	}
+	if err := e.db.AddEvent(&e.event); err != nil {
+		e.log.Warnf("%v", err)
+	}
+}
@@ -0,0 +1,176 @@
+package operations
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gwuah/piko/internal/config"
+	"github.com/gwuah/piko/internal/state"
+)
+
+type IdlePolicy struct {
+	HibernateAfter time.Duration
+	ExpireAfter    time.Duration
+	DestroyExpired bool
+}
+
+type IdleAction string
+
+const (
+	IdleNone      IdleAction = ""
+	IdleHibernate IdleAction = "hibernate"
+	IdleExpire    IdleAction = "expire"
+)
+
+// ResolveIdlePolicy applies an environment's own overrides on top of the
+// project's idle settings from .piko.yml.
+func ResolveIdlePolicy(cfg *config.Config, environment *state.Environment) IdlePolicy {
+	policy := IdlePolicy{
+		HibernateAfter: time.Duration(cfg.Idle.HibernateAfter),
+		ExpireAfter:    time.Duration(cfg.Idle.ExpireAfter),
+		DestroyExpired: cfg.Idle.DestroyExpired,
+	}
+
+	if environment.HibernateAfter.Valid {
+		policy.HibernateAfter = time.Duration(environment.HibernateAfter.Int64) * time.Second
+	}
+	if environment.ExpireAfter.Valid {
+		policy.ExpireAfter = time.Duration(environment.ExpireAfter.Int64) * time.Second
+	}
+	if environment.DestroyExpired.Valid {
+		policy.DestroyExpired = environment.DestroyExpired.Bool
+	}
+
+	return policy
+}
+
+func (p IdlePolicy) Action(environment *state.Environment, now time.Time) IdleAction {
+	idle := now.Sub(environment.LastActivityAt)
+
+	if p.ExpireAfter > 0 && idle >= p.ExpireAfter && !environment.ExpiredAt.Valid {
+		return IdleExpire
+	}
+
+	if p.HibernateAfter > 0 && idle >= p.HibernateAfter && !environment.HibernatedAt.Valid {
+		return IdleHibernate
+	}
+
+	return IdleNone
+}
+
+type HibernateEnvironmentOptions struct {
+	DB          *state.DB
+	Project     *state.Project
+	Environment *state.Environment
+	Logger      Logger
+
+	// Source is recorded in the event log; it defaults to SourceCLI.
+	Source string
+
+	// StopProcesses stops the environment's supervised processes.
+	StopProcesses func(*state.Environment)
+}
+
+func HibernateEnvironment(ctx context.Context, opts HibernateEnvironmentOptions) error {
+	unlock, err := lockEnvironments(ctx, opts.DB, opts.Project, "hibernate", false, opts.Environment.Name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return hibernateEnvironment(ctx, opts)
+}
+
+func hibernateEnvironment(ctx context.Context, opts HibernateEnvironmentOptions) (err error) {
+	log := opts.Logger
+	if log == nil {
+		log = &SilentLogger{}
+	}
+
+	ev := startEvent(opts.DB, log, opts.Project, opts.Environment.Name, "hibernate", opts.Source)
+	defer func() { ev.end(err) }()
+
+	if opts.StopProcesses != nil {
+		opts.StopProcesses(opts.Environment)
+		log.Info("Stopped processes")
+	}
+
+	err = downEnvironment(ctx, DownEnvironmentOptions{
+		DB:          opts.DB,
+		Project:     opts.Project,
+		Environment: opts.Environment,
+		Logger:      log,
+		Source:      opts.Source,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := opts.DB.SetEnvironmentHibernated(opts.Environment.ID, true); err != nil {
+		return err
+	}
+	log.Info("Hibernated idle environment")
+	return nil
+}
+
+type ExpireEnvironmentOptions struct {
+	DB          *state.DB
+	Project     *state.Project
+	Environment *state.Environment
+	Destroy     bool
+	Logger      Logger
+
+	// Source is recorded in the event log; it defaults to SourceCLI.
+	Source string
+
+	// StopProcesses stops the environment's supervised processes.
+	StopProcesses func(*state.Environment)
+}
+
+func ExpireEnvironment(ctx context.Context, opts ExpireEnvironmentOptions) (err error) {
+	log := opts.Logger
+	if log == nil {
+		log = &SilentLogger{}
+	}
+
+	unlock, err := lockEnvironments(ctx, opts.DB, opts.Project, "expire", false, opts.Environment.Name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	ev := startEvent(opts.DB, log, opts.Project, opts.Environment.Name, "expire", opts.Source)
+	defer func() { ev.end(err) }()
+
+	if opts.Destroy {
+		log.Info("Destroying expired environment")
+		return destroyEnvironment(ctx, DestroyEnvironmentOptions{
+			DB:            opts.DB,
+			Project:       opts.Project,
+			Environment:   opts.Environment,
+			RemoveVolumes: true,
+			Logger:        log,
+			Source:        opts.Source,
+			StopProcesses: opts.StopProcesses,
+		})
+	}
+
+	if !opts.Environment.HibernatedAt.Valid {
+		if err := hibernateEnvironment(ctx, HibernateEnvironmentOptions{
+			DB:            opts.DB,
+			Project:       opts.Project,
+			Environment:   opts.Environment,
+			Logger:        log,
+			Source:        opts.Source,
+			StopProcesses: opts.StopProcesses,
+		}); err != nil {
+			return fmt.Errorf("failed to hibernate expired environment: %w", err)
+		}
+	}
+
+	if err := opts.DB.SetEnvironmentExpired(opts.Environment.ID); err != nil {
+		return err
+	}
+	log.Info("Marked environment as expired")
+	return nil
+}
@@ -0,0 +1,166 @@
+package operations
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/gwuah/piko/internal/config"
+	"github.com/gwuah/piko/internal/docker"
+	"github.com/gwuah/piko/internal/env"
+	"github.com/gwuah/piko/internal/ports"
+	"github.com/gwuah/piko/internal/state"
+)
+
+type ResolvedLink struct {
+	Service     string
+	Target      *state.Environment
+	Network     string
+	Container   string
+	Allocations []ports.Allocation
+}
+
+// ResolveLinks loads an environment's links together with what is needed to
+// reach the linked service: the target's docker network and container, and
+// the host ports allocated to the service in the target environment.
+func ResolveLinks(db *state.DB, project *state.Project, environment *state.Environment) ([]ResolvedLink, error) {
+	links, err := db.ListEnvironmentLinks(environment.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var resolved []ResolvedLink
+	for _, link := range links {
+		target, err := db.GetEnvironmentByID(link.TargetEnvironmentID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load linked environment for %s: %w", link.Service, err)
+		}
+
+		r := ResolvedLink{Service: link.Service, Target: target}
+		if target.DockerProject != "" {
+			r.Network = target.DockerProject
+			r.Container = fmt.Sprintf("%s-%s-1", target.DockerProject, link.Service)
+
+			composeConfig, err := docker.ParseComposeConfig(composeDirFor(project, target))
+			if err == nil {
+				if svc, ok := composeConfig.Project().Services[link.Service]; ok && svc.ContainerName != "" {
+					r.Container = svc.ContainerName
+				}
+				for _, alloc := range ports.Allocate(target.ID, composeConfig.GetServicePorts()) {
+					if alloc.Service == link.Service {
+						r.Allocations = append(r.Allocations, alloc)
+					}
+				}
+			}
+		}
+		resolved = append(resolved, r)
+	}
+	return resolved, nil
+}
+
+// LinkAllocations replaces the port allocations of linked services with the
+// ones of the environments they are linked to, so PIKO_<SERVICE>_PORT points
+// at the other environment.
+func LinkAllocations(allocations []ports.Allocation, links []ResolvedLink) []ports.Allocation {
+	linked := make(map[string]bool)
+	for _, link := range links {
+		linked[link.Service] = true
+	}
+
+	var result []ports.Allocation
+	for _, alloc := range allocations {
+		if !linked[alloc.Service] {
+			result = append(result, alloc)
+		}
+	}
+	for _, link := range links {
+		result = append(result, link.Allocations...)
+	}
+	return result
+}
+
+// WriteComposeFile generates docker-compose.piko.yml for an environment with
+// its port allocations, network and volume names, injected environment and
+// links applied, and renders the environment's env files.
+func WriteComposeFile(db *state.DB, project *state.Project, environment *state.Environment) ([]ports.Allocation, error) {
+	composeDir := composeDirFor(project, environment)
+
+	composeConfig, err := docker.ParseComposeConfig(composeDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse compose config: %w", err)
+	}
+
+	allocations := ports.Allocate(environment.ID, composeConfig.GetServicePorts())
+
+	composeProject := composeConfig.Project()
+	docker.ApplyOverrides(composeProject, environment.DockerProject, allocations)
+
+	links, err := ResolveLinks(db, project, environment)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := config.Load(environment.Path)
+	if err != nil {
+		return nil, err
+	}
+	pikoEnv := env.Build(project, environment, LinkAllocations(allocations, links))
+	injected, err := injectedEnvironment(cfg, pikoEnv)
+	if err != nil {
+		return nil, err
+	}
+	for service := range injected {
+		if _, ok := composeProject.Services[service]; !ok && service != "*" {
+			return nil, fmt.Errorf("inject_env: service %q not found in compose file", service)
+		}
+	}
+	docker.ApplyEnvironment(composeProject, injected)
+
+	var dockerLinks []docker.Link
+	for _, link := range links {
+		if link.Network == "" {
+			continue
+		}
+		dockerLinks = append(dockerLinks, docker.Link{
+			Service:   link.Service,
+			Network:   link.Network,
+			Container: link.Container,
+		})
+	}
+	docker.ApplyLinks(composeProject, dockerLinks)
+
+	pikoComposePath := filepath.Join(composeDir, "docker-compose.piko.yml")
+	if err := docker.WriteProjectFile(pikoComposePath, composeProject); err != nil {
+		return nil, fmt.Errorf("failed to write compose file: %w", err)
+	}
+
+	if _, err := WriteEnvFiles(cfg, pikoEnv); err != nil {
+		return nil, err
+	}
+	return allocations, nil
+}
+
+// unlinkDestroyed regenerates the compose file of an environment whose link
+// target was destroyed. The link row itself is removed by the foreign key.
+func unlinkDestroyed(db *state.DB, project *state.Project, link *state.EnvironmentLink, log Logger) {
+	dependent, err := db.GetEnvironmentByID(link.EnvironmentID)
+	if err != nil {
+		return
+	}
+	log.Warnf("removed link %s/%s -> this environment", dependent.Name, link.Service)
+
+	if dependent.DockerProject == "" {
+		return
+	}
+	if _, err := WriteComposeFile(db, project, dependent); err != nil {
+		log.Warnf("failed to regenerate compose file for %s: %v", dependent.Name, err)
+		return
+	}
+	log.Infof("Run 'piko env up %s' to start its own %s again", dependent.Name, link.Service)
+}
+
+func composeDirFor(project *state.Project, environment *state.Environment) string {
+	if project.ComposeDir != "" {
+		return filepath.Join(environment.Path, project.ComposeDir)
+	}
+	return environment.Path
+}
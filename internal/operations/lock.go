@@ -0,0 +1,154 @@
+package operations
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gwuah/piko/internal/state"
+)
+
+const (
+	// lockTTL is how long a lock outlives a holder that stops refreshing it
+	// (e.g. on another host, or a hung process).
+	lockTTL = 5 * time.Minute
+
+	lockRefreshInterval = time.Minute
+	lockPollInterval    = 500 * time.Millisecond
+)
+
+// LockedError is returned when another operation holds a conflicting lock.
+type LockedError struct {
+	Lock *state.Lock
+}
+
+func (e *LockedError) Error() string {
+	target := fmt.Sprintf("environment %q", e.Lock.Environment)
+	if e.Lock.Environment == "" {
+		target = "project"
+	}
+	return fmt.Sprintf("%s is busy: %s started %s ago by pid %d (%s); wait for it or retry with --wait",
+		target, e.Lock.Action, time.Since(e.Lock.AcquiredAt).Round(time.Second), e.Lock.PID, e.Lock.Command)
+}
+
+// lockEnvironments locks environments of a project for action, in name
+// order. With wait, it waits for conflicting operations to finish instead of
+// failing, until ctx is canceled. The returned function releases the locks.
+func lockEnvironments(ctx context.Context, db *state.DB, project *state.Project, action string, wait bool, names ...string) (func(), error) {
+	names = append([]string(nil), names...)
+	sort.Strings(names)
+
+	var unlocks []func()
+	unlockAll := func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+	for _, name := range names {
+		unlock, err := acquireLock(ctx, db, project, name, action, wait)
+		if err != nil {
+			unlockAll()
+			return nil, err
+		}
+		unlocks = append(unlocks, unlock)
+	}
+	return unlockAll, nil
+}
+
+// lockProject locks a whole project for action.
+func lockProject(ctx context.Context, db *state.DB, project *state.Project, action string, wait bool) (func(), error) {
+	return acquireLock(ctx, db, project, "", action, wait)
+}
+
+func acquireLock(ctx context.Context, db *state.DB, project *state.Project, environment, action string, wait bool) (func(), error) {
+	hostname, _ := os.Hostname()
+	lock := &state.Lock{
+		ProjectID:   project.ID,
+		Environment: environment,
+		Holder:      randomSuffix() + randomSuffix(),
+		Action:      action,
+		PID:         os.Getpid(),
+		Hostname:    hostname,
+		Command:     lockCommand(),
+	}
+
+	for {
+		lock.AcquiredAt = time.Now()
+		lock.ExpiresAt = lock.AcquiredAt.Add(lockTTL)
+		conflict, err := db.TryLock(lock)
+		if err != nil {
+			return nil, err
+		}
+		if conflict == nil {
+			break
+		}
+		if !wait {
+			return nil, &LockedError{Lock: conflict}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(lockRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				lock.ExpiresAt = time.Now().Add(lockTTL)
+				db.RefreshLock(lock)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		db.Unlock(lock)
+	}, nil
+}
+
+// WaitForEnvironment blocks until no operation holds a lock on the
+// environment (or its project), or ctx is canceled.
+func WaitForEnvironment(ctx context.Context, db *state.DB, project *state.Project, name string, log Logger) error {
+	announced := false
+	for {
+		locks, err := db.ListLocks(project.ID)
+		if err != nil {
+			return err
+		}
+		var busy *state.Lock
+		for _, l := range locks {
+			if (l.Environment == "" || name == "" || l.Environment == name) && !l.Stale() {
+				busy = l
+				break
+			}
+		}
+		if busy == nil {
+			return nil
+		}
+		if !announced && log != nil {
+			log.Infof("Waiting for %s (pid %d) to finish...", busy.Action, busy.PID)
+			announced = true
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+func lockCommand() string {
+	args := append([]string{filepath.Base(os.Args[0])}, os.Args[1:]...)
+	return strings.Join(args, " ")
+}
@@ -0,0 +1,147 @@
+package operations
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gwuah/piko/internal/env"
+	"github.com/gwuah/piko/internal/git"
+	"github.com/gwuah/piko/internal/ports"
+	"github.com/gwuah/piko/internal/state"
+	"github.com/gwuah/piko/internal/tmux"
+)
+
+type MoveProjectOptions struct {
+	DB      *state.DB
+	Project *state.Project
+	NewRoot string
+	Logger  Logger
+
+	// Source is recorded in the event log; it defaults to SourceCLI.
+	Source string
+
+	// Wait waits for conflicting operations to finish instead of failing.
+	Wait bool
+}
+
+// MoveProject points a project at its repository's new location. If the
+// repository is still at the old root, it is moved first. Environment paths
+// under the old root are rewritten, worktree links repaired, generated
+// compose and env files rewritten, and tmux sessions pointed at the new
+// worktree paths.
+func MoveProject(ctx context.Context, opts MoveProjectOptions) (_ *state.Project, err error) {
+	log := opts.Logger
+	if log == nil {
+		log = &SilentLogger{}
+	}
+
+	unlock, err := lockProject(ctx, opts.DB, opts.Project, "move", opts.Wait)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	ev := startEvent(opts.DB, log, opts.Project, "", "move", opts.Source)
+	ev.detail(opts.Project.RootPath + " → " + opts.NewRoot)
+	defer func() { ev.end(err) }()
+
+	oldRoot := opts.Project.RootPath
+	newRoot, err := filepath.Abs(opts.NewRoot)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path %s: %w", opts.NewRoot, err)
+	}
+	if newRoot == oldRoot {
+		return nil, fmt.Errorf("project %s is already at %s", opts.Project.Name, newRoot)
+	}
+	if exists, err := opts.DB.ProjectExistsByPath(newRoot); err != nil {
+		return nil, err
+	} else if exists {
+		return nil, fmt.Errorf("another project is registered at %s", newRoot)
+	}
+
+	_, oldErr := os.Stat(oldRoot)
+	_, newErr := os.Stat(newRoot)
+	switch {
+	case oldErr == nil && newErr == nil:
+		return nil, fmt.Errorf("both %s and %s exist; move the repository yourself or pick a new path", oldRoot, newRoot)
+	case oldErr == nil:
+		if err := os.Rename(oldRoot, newRoot); err != nil {
+			return nil, fmt.Errorf("failed to move repository: %w", err)
+		}
+		log.Infof("Moved %s to %s", oldRoot, newRoot)
+	case newErr != nil:
+		return nil, fmt.Errorf("%s does not exist", newRoot)
+	}
+	if !git.IsGitRepo(newRoot) {
+		return nil, fmt.Errorf("%s is not a git repository", newRoot)
+	}
+
+	if _, err := opts.DB.Backup("move"); err != nil {
+		return nil, err
+	}
+	if err := opts.DB.RelocateProject(opts.Project.ID, oldRoot, newRoot); err != nil {
+		return nil, err
+	}
+	log.Infof("Updated project root to %s", newRoot)
+
+	project, err := opts.DB.GetProjectByID(opts.Project.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	environments, err := opts.DB.ListEnvironmentsByProject(project.ID)
+	if err != nil {
+		return nil, err
+	}
+	spares, err := opts.DB.ListPoolEnvironments(project.ID)
+	if err != nil {
+		return nil, err
+	}
+	environments = append(environments, spares...)
+
+	var paths []string
+	for _, environment := range environments {
+		paths = append(paths, environment.Path)
+	}
+	if len(paths) > 0 {
+		if err := git.RepairWorktrees(project.RootPath, paths...); err != nil {
+			log.Warnf("%v", err)
+		} else {
+			log.Infof("Repaired %d worktree(s)", len(paths))
+		}
+	}
+
+	for _, environment := range environments {
+		var allocations []ports.Allocation
+		if environment.DockerProject != "" {
+			allocations, err = WriteComposeFile(opts.DB, project, environment)
+		} else {
+			allocations, err = ReservePorts(opts.DB, project, environment)
+			if err == nil {
+				err = WriteSimpleEnvFiles(opts.DB, project, environment, &SilentLogger{})
+			}
+		}
+		if err != nil {
+			log.Warnf("%s: failed to regenerate files: %v", environment.Name, err)
+		}
+
+		sessionName := tmux.SessionName(project.Name, environment.Name)
+		if !tmux.SessionExists(sessionName) {
+			continue
+		}
+		if err := tmux.SetSessionDir(sessionName, environment.Path); err != nil {
+			log.Warnf("%s: %v", environment.Name, err)
+			continue
+		}
+		pikoEnv := env.Build(project, environment, allocations)
+		if err := tmux.SetEnvironment(sessionName, pikoEnv.ToEnvSlice()); err != nil {
+			log.Warnf("%s: %v", environment.Name, err)
+			continue
+		}
+		log.Infof("Updated tmux session %s", sessionName)
+	}
+
+	return project, nil
+}
@@ -0,0 +1,91 @@
+package operations
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/gwuah/piko/internal/config"
+	"github.com/gwuah/piko/internal/state"
+)
+
+// EnvironmentNames are the worktree path, branch and docker project of an
+// environment, resolved from the project's naming templates. They are stored
+// with the environment, so changing the templates only affects new ones.
+type EnvironmentNames struct {
+	Path          string
+	Branch        string
+	DockerProject string
+}
+
+type namingData struct {
+	Project string
+	Name    string
+	User    string
+}
+
+// ResolveNames applies the naming templates in cfg to an environment name.
+func ResolveNames(project *state.Project, cfg *config.Config, name string) (*EnvironmentNames, error) {
+	naming := cfg.Naming
+	data := namingData{Project: project.Name, Name: name, User: currentUser()}
+
+	worktreeDir, err := renderName("worktree_dir", naming.WorktreeDir, config.DefaultWorktreeDir, data)
+	if err != nil {
+		return nil, err
+	}
+	if rest, ok := strings.CutPrefix(worktreeDir, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve worktree_dir: %w", err)
+		}
+		worktreeDir = filepath.Join(home, rest)
+	}
+	if !filepath.IsAbs(worktreeDir) {
+		worktreeDir = filepath.Join(project.RootPath, worktreeDir)
+	}
+
+	branch, err := renderName("branch", naming.Branch, config.DefaultBranch, data)
+	if err != nil {
+		return nil, err
+	}
+
+	dockerProject, err := renderName("docker_project", naming.DockerProject, config.DefaultDockerProject, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EnvironmentNames{
+		Path:          filepath.Join(filepath.Clean(worktreeDir), name),
+		Branch:        branch,
+		DockerProject: dockerProject,
+	}, nil
+}
+
+func renderName(field, text, fallback string, data namingData) (string, error) {
+	if text == "" {
+		text = fallback
+	}
+	tmpl, err := template.New(field).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("naming.%s: %w", field, err)
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("naming.%s: %w", field, err)
+	}
+	value := strings.TrimSpace(out.String())
+	if value == "" {
+		return "", fmt.Errorf("naming.%s: %q renders to an empty name", field, text)
+	}
+	return value, nil
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
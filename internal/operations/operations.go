@@ -6,3 +6,4 @@ type Logger = logger.Logger
 type StdoutLogger = logger.StdoutLogger
 type SilentLogger = logger.SilentLogger
 type WriterLogger = logger.WriterLogger
+type PrefixLogger = logger.PrefixLogger
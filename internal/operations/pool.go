@@ -0,0 +1,230 @@
+package operations
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+
+	"github.com/gwuah/piko/internal/config"
+	"github.com/gwuah/piko/internal/docker"
+	"github.com/gwuah/piko/internal/git"
+	"github.com/gwuah/piko/internal/ports"
+	"github.com/gwuah/piko/internal/state"
+	"github.com/gwuah/piko/internal/tmux"
+)
+
+const poolNamePrefix = "pool-"
+
+type FillPoolOptions struct {
+	DB      *state.DB
+	Project *state.Project
+	Logger  Logger
+
+	// Source is recorded in the event log; it defaults to SourceCLI.
+	Source string
+
+	// StopProcesses stops the supervised processes of spares being removed.
+	StopProcesses func(*state.Environment)
+}
+
+// FillPool brings a project's pool to the size configured in .piko.yml:
+// spares that are no longer at the project's HEAD, or beyond the configured
+// size, are destroyed and missing ones created. It returns the number of
+// spares created.
+func FillPool(ctx context.Context, opts FillPoolOptions) (int, error) {
+	log := opts.Logger
+	if log == nil {
+		log = &SilentLogger{}
+	}
+
+	cfg, err := config.Load(opts.Project.RootPath)
+	if err != nil {
+		return 0, err
+	}
+
+	head, err := git.HeadCommit(opts.Project.RootPath)
+	if err != nil {
+		return 0, err
+	}
+
+	spares, err := opts.DB.ListPoolEnvironments(opts.Project.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, spare := range spares {
+		switch spare.Pool {
+		case state.PoolWarming:
+			count++
+		case state.PoolReady:
+			if count < cfg.Pool.Size && poolCommit(spare) == head {
+				count++
+				continue
+			}
+			if claimed, _ := opts.DB.SetEnvironmentPool(spare.ID, state.PoolReady, state.PoolClaimed); !claimed {
+				continue
+			}
+			log.Infof("Removing spare %s", spare.Name)
+			if err := destroySpare(ctx, opts.DB, opts.Project, spare, opts.Source, opts.StopProcesses, log); err != nil {
+				log.Warnf("failed to remove spare %s: %v", spare.Name, err)
+			}
+		}
+	}
+
+	created := 0
+	for ; count < cfg.Pool.Size; count++ {
+		if err := ctx.Err(); err != nil {
+			return created, err
+		}
+		name := poolNamePrefix + randomSuffix()
+		log.Infof("Creating spare %s", name)
+		_, err := CreateEnvironment(ctx, CreateEnvironmentOptions{
+			DB:      opts.DB,
+			Project: opts.Project,
+			Name:    name,
+			Logger:  &PrefixLogger{Prefix: name + ": ", Next: log},
+			Pool:    true,
+			Source:  opts.Source,
+		})
+		if err != nil {
+			return created, fmt.Errorf("failed to create spare %s: %w", name, err)
+		}
+		created++
+	}
+	return created, nil
+}
+
+// ResetPool destroys spares left half-built, e.g. by a server that stopped
+// while creating them.
+func ResetPool(ctx context.Context, db *state.DB, project *state.Project, log Logger) {
+	spares, err := db.ListPoolEnvironments(project.ID)
+	if err != nil {
+		return
+	}
+	for _, spare := range spares {
+		if spare.Pool == state.PoolReady {
+			continue
+		}
+		log.Infof("Removing unfinished spare %s", spare.Name)
+		if err := destroySpare(ctx, db, project, spare, SourceServer, nil, log); err != nil {
+			log.Warnf("failed to remove spare %s: %v", spare.Name, err)
+		}
+	}
+}
+
+// DrainPool destroys all ready spares of a project.
+func DrainPool(ctx context.Context, db *state.DB, project *state.Project, log Logger) (int, error) {
+	spares, err := db.ListPoolEnvironments(project.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, spare := range spares {
+		if claimed, _ := db.SetEnvironmentPool(spare.ID, state.PoolReady, state.PoolClaimed); !claimed {
+			continue
+		}
+		if err := destroySpare(ctx, db, project, spare, "", nil, log); err != nil {
+			return removed, fmt.Errorf("failed to remove spare %s: %w", spare.Name, err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// claimPoolEnvironment takes a ready spare at the project's HEAD and renames
+// it, as RenameEnvironment does, to the environment name. It returns nil when
+// no spare is available.
+func claimPoolEnvironment(ctx context.Context, db *state.DB, project *state.Project, name string, cfg *config.Config, log Logger) (*CreateEnvironmentResult, error) {
+	head, err := git.HeadCommit(project.RootPath)
+	if err != nil {
+		return nil, err
+	}
+
+	spares, err := db.ListPoolEnvironments(project.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, spare := range spares {
+		if spare.Pool != state.PoolReady || poolCommit(spare) != head {
+			continue
+		}
+		claimed, err := db.SetEnvironmentPool(spare.ID, state.PoolReady, state.PoolClaimed)
+		if err != nil {
+			return nil, err
+		}
+		if !claimed {
+			continue
+		}
+		spare.Pool = state.PoolClaimed
+
+		log.Infof("Claiming spare %s", spare.Name)
+		environment, err := applyRename(ctx, RenameEnvironmentOptions{
+			DB:          db,
+			Project:     project,
+			Environment: spare,
+			NewName:     name,
+		}, log)
+		if err != nil {
+			db.SetEnvironmentPool(spare.ID, state.PoolClaimed, state.PoolReady)
+			return nil, err
+		}
+
+		if _, err := db.SetEnvironmentPool(environment.ID, state.PoolClaimed, ""); err != nil {
+			return nil, err
+		}
+		environment.Pool = ""
+		db.TouchEnvironment(environment.ID)
+
+		var allocations []ports.Allocation
+		if environment.DockerProject != "" {
+			if composeConfig, err := docker.ParseComposeConfig(composeDirFor(project, environment)); err == nil {
+				allocations = ports.Allocate(environment.ID, composeConfig.GetServicePorts())
+			}
+		} else {
+			allocations, _ = ReservePorts(db, project, environment)
+		}
+		createSession(project, environment, cfg, allocations, log)
+
+		log.Info("Environment ready")
+		return &CreateEnvironmentResult{
+			Environment: environment,
+			SessionName: tmux.SessionName(project.Name, environment.Name),
+			IsSimple:    environment.DockerProject == "",
+			DataDir:     filepath.Join(project.RootPath, ".piko", "data", environment.Name),
+		}, nil
+	}
+	return nil, nil
+}
+
+func destroySpare(ctx context.Context, db *state.DB, project *state.Project, spare *state.Environment, source string, stopProcesses func(*state.Environment), log Logger) error {
+	return DestroyEnvironment(ctx, DestroyEnvironmentOptions{
+		DB:            db,
+		Project:       project,
+		Environment:   spare,
+		RemoveVolumes: true,
+		Logger:        &PrefixLogger{Prefix: spare.Name + ": ", Next: log},
+		Source:        source,
+		StopProcesses: stopProcesses,
+	})
+}
+
+// poolCommit is the commit a spare was created at; spares stay on a detached
+// HEAD until claimed.
+func poolCommit(spare *state.Environment) string {
+	commit, err := git.HeadCommit(spare.Path)
+	if err != nil {
+		return ""
+	}
+	return commit
+}
+
+func randomSuffix() string {
+	b := make([]byte, 3)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
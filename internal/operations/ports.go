@@ -0,0 +1,88 @@
+package operations
+
+import (
+	"github.com/gwuah/piko/internal/config"
+	"github.com/gwuah/piko/internal/ports"
+	"github.com/gwuah/piko/internal/state"
+)
+
+// ReservePorts returns the named ports declared in .piko.yml for a simple mode
+// environment, reserving and persisting a host port for each name that
+// doesn't have one yet. Docker environments get their ports from compose.
+func ReservePorts(db *state.DB, project *state.Project, environment *state.Environment) ([]ports.Allocation, error) {
+	if environment.DockerProject != "" {
+		return nil, nil
+	}
+
+	cfg, err := config.Load(environment.Path)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Ports) == 0 {
+		return nil, nil
+	}
+
+	reserved, err := db.ListEnvironmentPorts(environment.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, name := range cfg.Ports {
+		if _, ok := reserved[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) > 0 {
+		taken, err := db.ReservedPorts()
+		if err != nil {
+			return nil, err
+		}
+		allocations, err := ports.Reserve(environment.ID, missing, taken)
+		if err != nil {
+			return nil, err
+		}
+		for _, alloc := range allocations {
+			if err := db.InsertEnvironmentPort(environment.ID, alloc.Service, alloc.HostPort); err != nil {
+				return nil, err
+			}
+			reserved[alloc.Service] = alloc.HostPort
+		}
+	}
+
+	return portAllocations(cfg.Ports, reserved), nil
+}
+
+// ListEnvironmentPorts returns the ports already reserved for the named ports
+// declared in .piko.yml, without reserving any. Names that have no port yet
+// are left out until the environment is next brought up.
+func ListEnvironmentPorts(db *state.DB, environment *state.Environment) ([]ports.Allocation, error) {
+	if environment.DockerProject != "" {
+		return nil, nil
+	}
+
+	cfg, err := config.Load(environment.Path)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Ports) == 0 {
+		return nil, nil
+	}
+
+	reserved, err := db.ListEnvironmentPorts(environment.ID)
+	if err != nil {
+		return nil, err
+	}
+	return portAllocations(cfg.Ports, reserved), nil
+}
+
+func portAllocations(names []string, reserved map[string]int) []ports.Allocation {
+	allocations := make([]ports.Allocation, 0, len(names))
+	for _, name := range names {
+		if port, ok := reserved[name]; ok {
+			allocations = append(allocations, ports.Allocation{Service: name, HostPort: port})
+		}
+	}
+	return allocations
+}
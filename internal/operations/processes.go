@@ -0,0 +1,62 @@
+package operations
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/gwuah/piko/internal/config"
+	"github.com/gwuah/piko/internal/env"
+	"github.com/gwuah/piko/internal/state"
+	"github.com/gwuah/piko/internal/supervisor"
+)
+
+// ProcessSpecs returns the background processes declared in the environment's
+// .piko.yml, with PIKO_* variables and logs under the data directory.
+func ProcessSpecs(db *state.DB, project *state.Project, environment *state.Environment) ([]supervisor.Spec, error) {
+	cfg, err := config.Load(environment.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	links, err := ResolveLinks(db, project, environment)
+	if err != nil {
+		return nil, err
+	}
+	reserved, err := ListEnvironmentPorts(db, environment)
+	if err != nil {
+		return nil, err
+	}
+	pikoEnv := env.Build(project, environment, LinkAllocations(reserved, links))
+
+	names, err := config.ProcessOrder(cfg.Processes)
+	if err != nil {
+		return nil, err
+	}
+
+	specs := make([]supervisor.Spec, 0, len(names))
+	for _, name := range names {
+		process := cfg.Processes[name]
+		specs = append(specs, supervisor.Spec{
+			Name:    name,
+			Command: process.Command,
+			Dir:     process.WorkDir(environment.Path),
+			Env:     append(pikoEnv.ToEnvSlice(), process.EnvSlice()...),
+			LogPath: supervisor.LogPath(pikoEnv.DataDir, name),
+		})
+	}
+	return specs, nil
+}
+
+// FindProcessSpec returns the spec of a single named process.
+func FindProcessSpec(specs []supervisor.Spec, name string) (supervisor.Spec, error) {
+	for _, spec := range specs {
+		if spec.Name == name {
+			return spec, nil
+		}
+	}
+	return supervisor.Spec{}, fmt.Errorf("process %q not defined in .piko.yml", name)
+}
+
+func ProcessLogPath(project *state.Project, environment *state.Environment, name string) string {
+	return supervisor.LogPath(filepath.Join(project.RootPath, ".piko", "data", environment.Name), name)
+}
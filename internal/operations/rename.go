@@ -0,0 +1,345 @@
+package operations
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gwuah/piko/internal/config"
+	"github.com/gwuah/piko/internal/docker"
+	"github.com/gwuah/piko/internal/env"
+	"github.com/gwuah/piko/internal/git"
+	"github.com/gwuah/piko/internal/ports"
+	"github.com/gwuah/piko/internal/run"
+	"github.com/gwuah/piko/internal/state"
+	"github.com/gwuah/piko/internal/tmux"
+)
+
+type RenameEnvironmentOptions struct {
+	DB          *state.DB
+	Project     *state.Project
+	Environment *state.Environment
+	NewName     string
+	Logger      Logger
+
+	// Source is recorded in the event log; it defaults to SourceCLI.
+	Source string
+
+	// Wait waits for conflicting operations to finish instead of failing.
+	Wait bool
+}
+
+// RenameEnvironment moves everything derived from an environment's name to a
+// new name: the branch (when it is named after the environment, or created
+// when the worktree is detached), the worktree and data directories, the tmux
+// session, and the docker project with its network and volumes. Completed
+// steps are undone when a later one fails or ctx is canceled.
+func RenameEnvironment(ctx context.Context, opts RenameEnvironmentOptions) (*state.Environment, error) {
+	unlock, err := lockEnvironments(ctx, opts.DB, opts.Project, "rename", opts.Wait, opts.Environment.Name, opts.NewName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return renameEnvironment(ctx, opts)
+}
+
+func renameEnvironment(ctx context.Context, opts RenameEnvironmentOptions) (_ *state.Environment, err error) {
+	log := opts.Logger
+	if log == nil {
+		log = &SilentLogger{}
+	}
+
+	ev := startEvent(opts.DB, log, opts.Project, opts.Environment.Name, "rename", opts.Source)
+	ev.detail("from " + opts.Environment.Name)
+	defer func() {
+		if err == nil {
+			ev.event.Environment = opts.NewName
+		}
+		ev.end(err)
+	}()
+
+	if err := ValidateEnvironmentName(opts.NewName); err != nil {
+		return nil, err
+	}
+	if opts.NewName == opts.Environment.Name {
+		return nil, fmt.Errorf("environment is already named %q", opts.NewName)
+	}
+
+	exists, err := opts.DB.EnvironmentExists(opts.Project.ID, opts.NewName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check environment: %w", err)
+	}
+	if exists {
+		return nil, fmt.Errorf("environment %q already exists", opts.NewName)
+	}
+	return applyRename(ctx, opts, log)
+}
+
+// applyRename does the work of a rename, without the checks and event of
+// RenameEnvironment. Claiming a pool spare uses it to give the spare the new
+// environment's name.
+func applyRename(ctx context.Context, opts RenameEnvironmentOptions, log Logger) (*state.Environment, error) {
+	old := *opts.Environment
+	renamed := old
+	renamed.Name = opts.NewName
+
+	cfg, err := config.Load(opts.Project.RootPath)
+	if err != nil {
+		return nil, err
+	}
+	oldNames, err := ResolveNames(opts.Project, cfg, old.Name)
+	if err != nil {
+		return nil, err
+	}
+	newNames, err := ResolveNames(opts.Project, cfg, opts.NewName)
+	if err != nil {
+		return nil, err
+	}
+
+	var undo []func()
+	rollback := func() {
+		log.Warn("Rolling back rename")
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+	}
+
+	// Names that don't follow the current templates (set before they
+	// changed) are kept as they are.
+	if old.DockerProject != "" && old.DockerProject == oldNames.DockerProject {
+		renamed.DockerProject = newNames.DockerProject
+	}
+	moveDocker := renamed.DockerProject != old.DockerProject
+
+	wasRunning := false
+	if old.DockerProject != "" {
+		if err := docker.CheckDockerAvailable(); err != nil {
+			return nil, err
+		}
+
+		// Containers belong to the old project name and worktree; remove
+		// them (volumes stay) and recreate them under the new ones.
+		composeDir := composeDirFor(opts.Project, &old)
+		wasRunning = docker.GetProjectStatus(composeDir, old.DockerProject) == docker.StatusRunning
+		if err := composeProject(ctx, composeDir, old.DockerProject, "down"); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("failed to stop containers: %w", err)
+		}
+		if wasRunning {
+			log.Infof("Stopped containers (%s)", old.DockerProject)
+			undo = append(undo, func() {
+				cleanupCtx, cancel := cleanupContext()
+				defer cancel()
+				composeProject(cleanupCtx, composeDirFor(opts.Project, &old), old.DockerProject, "up", "-d")
+			})
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		rollback()
+		return nil, err
+	}
+
+	switch old.Branch {
+	case oldNames.Branch:
+		if err := git.RenameBranch(opts.Project.RootPath, old.Branch, newNames.Branch); err != nil {
+			rollback()
+			return nil, err
+		}
+		renamed.Branch = newNames.Branch
+		undo = append(undo, func() { git.RenameBranch(opts.Project.RootPath, newNames.Branch, old.Branch) })
+		log.Infof("Renamed branch %s → %s", old.Branch, newNames.Branch)
+	case "":
+		if err := git.CreateBranchHere(old.Path, newNames.Branch); err != nil {
+			rollback()
+			return nil, err
+		}
+		renamed.Branch = newNames.Branch
+		undo = append(undo, func() {
+			git.Detach(old.Path)
+			git.DeleteBranch(opts.Project.RootPath, newNames.Branch)
+		})
+		log.Infof("Created branch %s", newNames.Branch)
+	}
+
+	if old.Path == oldNames.Path {
+		renamed.Path = newNames.Path
+		if err := git.MoveWorktree(opts.Project.RootPath, old.Path, renamed.Path); err != nil {
+			rollback()
+			return nil, err
+		}
+		undo = append(undo, func() { git.MoveWorktree(opts.Project.RootPath, renamed.Path, old.Path) })
+		log.Infof("Moved worktree to %s", renamed.Path)
+	}
+
+	oldDataDir := filepath.Join(opts.Project.RootPath, ".piko", "data", old.Name)
+	newDataDir := filepath.Join(opts.Project.RootPath, ".piko", "data", opts.NewName)
+	if _, err := os.Stat(oldDataDir); err == nil {
+		if err := os.Rename(oldDataDir, newDataDir); err != nil {
+			rollback()
+			return nil, fmt.Errorf("failed to move data directory: %w", err)
+		}
+		undo = append(undo, func() { os.Rename(newDataDir, oldDataDir) })
+	}
+
+	oldSession := tmux.SessionName(opts.Project.Name, old.Name)
+	newSession := tmux.SessionName(opts.Project.Name, opts.NewName)
+	if tmux.SessionExists(oldSession) {
+		if err := tmux.RenameSession(oldSession, newSession); err != nil {
+			rollback()
+			return nil, fmt.Errorf("failed to rename tmux session: %w", err)
+		}
+		undo = append(undo, func() { tmux.RenameSession(newSession, oldSession) })
+		log.Infof("Renamed tmux session to %s", newSession)
+	}
+
+	var oldVolumes []string
+	if moveDocker {
+		composeConfig, err := docker.ParseComposeConfig(composeDirFor(opts.Project, &renamed))
+		if err != nil {
+			rollback()
+			return nil, fmt.Errorf("failed to parse compose config: %w", err)
+		}
+		for name := range composeConfig.Project().Volumes {
+			src := fmt.Sprintf("%s_%s", old.DockerProject, name)
+			dst := fmt.Sprintf("%s_%s", renamed.DockerProject, name)
+			if !docker.VolumeExists(src) {
+				continue
+			}
+			log.Infof("Copying volume %s → %s", src, dst)
+			if err := docker.CopyVolume(ctx, src, dst); err != nil {
+				rollback()
+				return nil, err
+			}
+			undo = append(undo, func() { docker.RemoveVolume(dst) })
+			oldVolumes = append(oldVolumes, src)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		rollback()
+		return nil, err
+	}
+
+	if err := opts.DB.UpdateEnvironment(&renamed); err != nil {
+		rollback()
+		return nil, err
+	}
+	undo = append(undo, func() { opts.DB.UpdateEnvironment(&old) })
+
+	// Earlier events follow the environment to its new name.
+	if err := opts.DB.RenameEventsEnvironment(opts.Project.ID, old.Name, renamed.Name); err != nil {
+		rollback()
+		return nil, err
+	}
+	undo = append(undo, func() { opts.DB.RenameEventsEnvironment(opts.Project.ID, renamed.Name, old.Name) })
+
+	var allocations []ports.Allocation
+	if renamed.DockerProject != "" {
+		allocations, err = WriteComposeFile(opts.DB, opts.Project, &renamed)
+		if err != nil {
+			rollback()
+			return nil, err
+		}
+		undo = append(undo, func() {
+			restored := old
+			restored.Path = renamed.Path
+			WriteComposeFile(opts.DB, opts.Project, &restored)
+		})
+
+		if wasRunning {
+			if err := composeProject(ctx, composeDirFor(opts.Project, &renamed), renamed.DockerProject, "up", "-d"); err != nil {
+				cleanupCtx, cancel := cleanupContext()
+				composeProject(cleanupCtx, composeDirFor(opts.Project, &renamed), renamed.DockerProject, "down")
+				cancel()
+				rollback()
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				return nil, fmt.Errorf("failed to start containers: %w", err)
+			}
+			log.Infof("Started containers (%s)", renamed.DockerProject)
+		}
+
+		for _, volume := range oldVolumes {
+			if err := docker.RemoveVolume(volume); err != nil {
+				log.Warnf("%v", err)
+			}
+		}
+	} else {
+		if err := WriteSimpleEnvFiles(opts.DB, opts.Project, &renamed, log); err != nil {
+			rollback()
+			return nil, err
+		}
+		allocations, _ = ListEnvironmentPorts(opts.DB, &renamed)
+	}
+
+	// The session still starts new windows in the old worktree, with the
+	// old PIKO_* variables.
+	if tmux.SessionExists(newSession) {
+		if err := tmux.SetSessionDir(newSession, renamed.Path); err != nil {
+			log.Warnf("%v", err)
+		} else if err := tmux.SetEnvironment(newSession, env.Build(opts.Project, &renamed, allocations).ToEnvSlice()); err != nil {
+			log.Warnf("%v", err)
+		} else {
+			log.Infof("Updated tmux session %s", newSession)
+		}
+	}
+
+	relinkDependents(opts.DB, opts.Project, &renamed, log)
+	return &renamed, nil
+}
+
+// ValidateEnvironmentName rejects names that can't be used in branch, path,
+// tmux session and docker project names.
+func ValidateEnvironmentName(name string) error {
+	if name == "" {
+		return fmt.Errorf("environment name is required")
+	}
+	if strings.HasPrefix(name, "-") || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid environment name %q", name)
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return fmt.Errorf("invalid environment name %q (use letters, digits, '-', '_' and '.')", name)
+		}
+	}
+	return nil
+}
+
+// relinkDependents regenerates the compose files of environments linked to a
+// renamed one, whose container and network names changed.
+func relinkDependents(db *state.DB, project *state.Project, environment *state.Environment, log Logger) {
+	if environment.DockerProject == "" {
+		return
+	}
+	links, err := db.ListLinksToEnvironment(environment.ID)
+	if err != nil {
+		return
+	}
+	for _, link := range links {
+		dependent, err := db.GetEnvironmentByID(link.EnvironmentID)
+		if err != nil || dependent.DockerProject == "" {
+			continue
+		}
+		if _, err := WriteComposeFile(db, project, dependent); err != nil {
+			log.Warnf("failed to update %s's link to %s: %v", dependent.Name, link.Service, err)
+			continue
+		}
+		log.Infof("Updated %s's link to %s; restart it with 'piko env up %s'", dependent.Name, link.Service, dependent.Name)
+	}
+}
+
+func composeProject(ctx context.Context, composeDir, dockerProject string, args ...string) error {
+	cmdArgs := append([]string{"compose", "-p", dockerProject, "-f", "docker-compose.piko.yml"}, args...)
+	cmd := run.CommandContext(ctx, "docker", cmdArgs...)
+	cmd.Dir = composeDir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s", string(output))
+	}
+	return nil
+}
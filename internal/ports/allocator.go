@@ -1,6 +1,9 @@
 package ports
 
-import "fmt"
+import (
+	"fmt"
+	"net"
+)
 
 const (
 	BasePort             = 10000
@@ -40,6 +43,35 @@ func Allocate(worktreeID int64, servicePorts map[string][]int) []Allocation {
 	return allocations
 }
 
+// Reserve picks host ports for named ports from the environment's range,
+// skipping ports in taken and ports something is already listening on.
+func Reserve(worktreeID int64, names []string, taken map[int]bool) ([]Allocation, error) {
+	basePort := BasePort + (int(worktreeID) * PortRangePerWorktree)
+
+	var allocations []Allocation
+	next := basePort
+	for _, name := range names {
+		for next < basePort+PortRangePerWorktree && (taken[next] || !isFree(next)) {
+			next++
+		}
+		if next >= basePort+PortRangePerWorktree {
+			return nil, fmt.Errorf("no free port left in %d-%d for %s", basePort, basePort+PortRangePerWorktree-1, name)
+		}
+		allocations = append(allocations, Allocation{Service: name, HostPort: next})
+		next++
+	}
+	return allocations, nil
+}
+
+func isFree(port int) bool {
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
+
 func (a Allocation) String() string {
 	return fmt.Sprintf("%s:%d -> %d", a.Service, a.ContainerPort, a.HostPort)
 }
@@ -52,3 +84,15 @@ func AllocationsToMap(allocations []Allocation) map[string]int {
 	}
 	return result
 }
+
+var httpPorts = []int{80, 443, 3000, 3001, 4000, 5000, 5173, 8000, 8080, 8081, 8888, 9000}
+
+// IsHTTP reports whether a container port is commonly used for HTTP.
+func IsHTTP(port int) bool {
+	for _, p := range httpPorts {
+		if port == p {
+			return true
+		}
+	}
+	return false
+}
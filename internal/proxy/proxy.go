@@ -0,0 +1,393 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gwuah/piko/internal/certs"
+	"github.com/gwuah/piko/internal/docker"
+	"github.com/gwuah/piko/internal/ports"
+	"github.com/gwuah/piko/internal/state"
+)
+
+const (
+	DefaultPort     = 19880
+	DefaultTLSPort  = 19443
+	refreshInterval = 10 * time.Second
+)
+
+type Route struct {
+	Host          string `json:"host"`
+	Project       string `json:"project"`
+	Environment   string `json:"environment"`
+	Service       string `json:"service"`
+	ContainerPort int    `json:"containerPort"`
+	HostPort      int    `json:"hostPort"`
+}
+
+// Proxy routes <service>.<env>.<project>.localhost to the host port docker
+// published for that service. Additional ports of a service are reachable as
+// <service>-<containerPort>.<env>.<project>.localhost.
+type Proxy struct {
+	db      *state.DB
+	port    int
+	tlsPort int
+	ca      *certs.CA
+
+	containers *docker.ContainerCache
+
+	mu          sync.RWMutex
+	routes      map[string]Route
+	server      *http.Server
+	listener    net.Listener
+	tlsServer   *http.Server
+	tlsListener net.Listener
+	refresh     chan struct{}
+	done        chan struct{}
+}
+
+func New(db *state.DB, port int) *Proxy {
+	return &Proxy{
+		db:      db,
+		port:    port,
+		routes:  make(map[string]Route),
+		refresh: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+}
+
+// WithContainers makes the proxy read container ports from cache while it
+// is following docker, instead of asking docker on every rebuild.
+func (p *Proxy) WithContainers(cache *docker.ContainerCache) *Proxy {
+	p.containers = cache
+	return p
+}
+
+// WithTLS additionally serves the routes over HTTPS on port, terminating TLS
+// with certificates issued on demand by ca.
+func (p *Proxy) WithTLS(port int, ca *certs.CA) *Proxy {
+	p.tlsPort = port
+	p.ca = ca
+	return p
+}
+
+func (p *Proxy) Port() int {
+	return p.port
+}
+
+func (p *Proxy) TLSPort() int {
+	return p.tlsPort
+}
+
+func Hostname(service, envName, projectName string) string {
+	return fmt.Sprintf("%s.%s.%s.localhost", label(service), label(envName), label(projectName))
+}
+
+func PortHostname(service string, containerPort int, envName, projectName string) string {
+	return Hostname(fmt.Sprintf("%s-%d", service, containerPort), envName, projectName)
+}
+
+func label(s string) string {
+	s = strings.ToLower(s)
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' {
+			return r
+		}
+		return '-'
+	}, s)
+}
+
+// URL returns the proxied URL for a published service port, or "" when the
+// proxy isn't listening.
+func (p *Proxy) URL(service string, containerPort int, envName, projectName string) string {
+	host := Hostname(service, envName, projectName)
+
+	p.mu.RLock()
+	route, ok := p.routes[host]
+	listening := p.listener != nil
+	p.mu.RUnlock()
+
+	if !listening {
+		return ""
+	}
+
+	if !ok || route.ContainerPort != containerPort {
+		host = PortHostname(service, containerPort, envName, projectName)
+	}
+	if p.ca != nil {
+		return fmt.Sprintf("https://%s:%d", host, p.tlsPort)
+	}
+	return fmt.Sprintf("http://%s:%d", host, p.port)
+}
+
+func (p *Proxy) Routes() []Route {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	routes := make([]Route, 0, len(p.routes))
+	for _, r := range p.routes {
+		routes = append(routes, r)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Host < routes[j].Host })
+	return routes
+}
+
+// Refresh asks the route table to be rebuilt without waiting for the next tick.
+func (p *Proxy) Refresh() {
+	select {
+	case p.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// Listen binds the proxy's ports. Nothing is served until Serve is called.
+func (p *Proxy) Listen() error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", p.port))
+	if err != nil {
+		return err
+	}
+
+	var tlsListener net.Listener
+	if p.ca != nil {
+		tlsListener, err = net.Listen("tcp", fmt.Sprintf(":%d", p.tlsPort))
+		if err != nil {
+			listener.Close()
+			return err
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.listener = listener
+	p.server = &http.Server{
+		Handler:           p,
+		ReadHeaderTimeout: 15 * time.Second,
+	}
+	if tlsListener != nil {
+		p.tlsListener = tlsListener
+		p.tlsServer = &http.Server{
+			Handler:           p,
+			ReadHeaderTimeout: 15 * time.Second,
+			TLSConfig: &tls.Config{
+				GetCertificate: p.ca.GetCertificate,
+				MinVersion:     tls.VersionTLS12,
+			},
+		}
+	}
+	return nil
+}
+
+// Serve routes requests on the ports bound by Listen until Shutdown.
+func (p *Proxy) Serve() error {
+	p.rebuild()
+	go p.watch()
+
+	p.mu.RLock()
+	server, listener := p.server, p.listener
+	tlsServer, tlsListener := p.tlsServer, p.tlsListener
+	p.mu.RUnlock()
+	if server == nil {
+		return fmt.Errorf("proxy is not listening")
+	}
+
+	errCh := make(chan error, 2)
+	listeners := 1
+	go func() {
+		errCh <- server.Serve(listener)
+	}()
+	if tlsServer != nil {
+		listeners++
+		go func() {
+			errCh <- tlsServer.ServeTLS(tlsListener, "", "")
+		}()
+	}
+
+	for range listeners {
+		if err := <-errCh; err != http.ErrServerClosed {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Proxy) Shutdown(ctx context.Context) error {
+	close(p.done)
+
+	p.mu.RLock()
+	server, tlsServer := p.server, p.tlsServer
+	p.mu.RUnlock()
+
+	if tlsServer != nil {
+		tlsServer.Shutdown(ctx)
+	}
+	if server == nil {
+		return nil
+	}
+	return server.Shutdown(ctx)
+}
+
+func (p *Proxy) watch() {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.rebuild()
+		case <-p.refresh:
+			p.rebuild()
+		}
+	}
+}
+
+func (p *Proxy) rebuild() {
+	projects, err := p.db.ListProjects()
+	if err != nil {
+		log.Printf("[proxy] failed to list projects: %v", err)
+		return
+	}
+
+	routes := make(map[string]Route)
+	for _, project := range projects {
+		environments, err := p.db.ListEnvironmentsByProject(project.ID)
+		if err != nil {
+			continue
+		}
+		for _, e := range environments {
+			if e.DockerProject == "" {
+				continue
+			}
+			composeDir := e.Path
+			if project.ComposeDir != "" {
+				composeDir = filepath.Join(e.Path, project.ComposeDir)
+			}
+			containers, ok := p.containers.Project(e.DockerProject)
+			if !ok {
+				var err error
+				containers, err = docker.ProjectContainers(composeDir, e.DockerProject)
+				if err != nil {
+					continue
+				}
+			}
+			for _, r := range containerRoutes(project.Name, e.Name, containers) {
+				routes[r.Host] = r
+			}
+		}
+	}
+
+	p.mu.Lock()
+	p.routes = routes
+	p.mu.Unlock()
+}
+
+func containerRoutes(projectName, envName string, containers []docker.Container) []Route {
+	byService := make(map[string][]docker.Publisher)
+	for _, c := range containers {
+		if c.State != "running" {
+			continue
+		}
+		for _, pub := range c.Publishers {
+			if pub.PublishedPort == 0 {
+				continue
+			}
+			byService[c.Service] = append(byService[c.Service], pub)
+		}
+	}
+
+	var routes []Route
+	for service, pubs := range byService {
+		sort.Slice(pubs, func(i, j int) bool { return pubs[i].TargetPort < pubs[j].TargetPort })
+
+		primary := pubs[0]
+		for _, pub := range pubs {
+			if ports.IsHTTP(pub.TargetPort) {
+				primary = pub
+				break
+			}
+		}
+
+		routes = append(routes, Route{
+			Host:          Hostname(service, envName, projectName),
+			Project:       projectName,
+			Environment:   envName,
+			Service:       service,
+			ContainerPort: primary.TargetPort,
+			HostPort:      primary.PublishedPort,
+		})
+		for _, pub := range pubs {
+			routes = append(routes, Route{
+				Host:          PortHostname(service, pub.TargetPort, envName, projectName),
+				Project:       projectName,
+				Environment:   envName,
+				Service:       service,
+				ContainerPort: pub.TargetPort,
+				HostPort:      pub.PublishedPort,
+			})
+		}
+	}
+	return routes
+}
+
+func (p *Proxy) lookup(host string) (Route, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	route, ok := p.routes[host]
+	return route, ok
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route, ok := p.lookup(r.Host)
+	if !ok {
+		p.Refresh()
+		p.serveNotFound(w, r)
+		return
+	}
+
+	target := &url.URL{Scheme: "http", Host: fmt.Sprintf("127.0.0.1:%d", route.HostPort)}
+	rp := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			pr.Out.Host = pr.In.Host
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			http.Error(w, fmt.Sprintf("%s/%s %s is not reachable on port %d: %v",
+				route.Project, route.Environment, route.Service, route.HostPort, err), http.StatusBadGateway)
+		},
+	}
+	rp.ServeHTTP(w, r)
+}
+
+func (p *Proxy) serveNotFound(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+
+	fmt.Fprintf(w, "piko proxy: no environment serves %s\n\n", r.Host)
+	routes := p.Routes()
+	if len(routes) == 0 {
+		fmt.Fprintln(w, "No routes (are any environments running?)")
+		return
+	}
+	fmt.Fprintln(w, "Known routes:")
+	for _, route := range routes {
+		fmt.Fprintf(w, "  %s -> localhost:%d\n", p.URL(route.Service, route.ContainerPort, route.Environment, route.Project), route.HostPort)
+	}
+}
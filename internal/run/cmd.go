@@ -6,11 +6,33 @@ import (
 	"fmt"
 	"io"
 	"os/exec"
+	"syscall"
 	"time"
 )
 
 const DefaultTimeout = 30 * time.Second
 
+// killGrace is how long a canceled command's process group has to exit after
+// SIGTERM before it is killed.
+const killGrace = 5 * time.Second
+
+// CommandContext is exec.CommandContext for commands that start children of
+// their own (shells, docker compose). The command runs in its own process
+// group, so it doesn't get the terminal's Ctrl-C directly, and when ctx is
+// done the whole group gets SIGTERM and, after killGrace, SIGKILL.
+func CommandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		pid := cmd.Process.Pid
+		err := syscall.Kill(-pid, syscall.SIGTERM)
+		time.AfterFunc(killGrace, func() { syscall.Kill(-pid, syscall.SIGKILL) })
+		return err
+	}
+	cmd.WaitDelay = killGrace + time.Second
+	return cmd
+}
+
 func Command(name string, args ...string) *Cmd {
 	return &Cmd{
 		name:    name,
@@ -20,10 +42,12 @@ func Command(name string, args ...string) *Cmd {
 }
 
 type Cmd struct {
+	ctx     context.Context
 	name    string
 	args    []string
 	dir     string
 	timeout time.Duration
+	stdin   io.Reader
 	stdout  io.Writer
 	stderr  io.Writer
 }
@@ -33,11 +57,22 @@ func (c *Cmd) Dir(dir string) *Cmd {
 	return c
 }
 
+// Context cancels the command when ctx is done.
+func (c *Cmd) Context(ctx context.Context) *Cmd {
+	c.ctx = ctx
+	return c
+}
+
 func (c *Cmd) Timeout(d time.Duration) *Cmd {
 	c.timeout = d
 	return c
 }
 
+func (c *Cmd) Stdin(r io.Reader) *Cmd {
+	c.stdin = r
+	return c
+}
+
 func (c *Cmd) Stdout(w io.Writer) *Cmd {
 	c.stdout = w
 	return c
@@ -49,13 +84,16 @@ func (c *Cmd) Stderr(w io.Writer) *Cmd {
 }
 
 func (c *Cmd) Run() error {
-	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	ctx, cancel := c.context()
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, c.name, c.args...)
+	cmd := CommandContext(ctx, c.name, c.args...)
 	if c.dir != "" {
 		cmd.Dir = c.dir
 	}
+	if c.stdin != nil {
+		cmd.Stdin = c.stdin
+	}
 	if c.stdout != nil {
 		cmd.Stdout = c.stdout
 	}
@@ -63,7 +101,24 @@ func (c *Cmd) Run() error {
 		cmd.Stderr = c.stderr
 	}
 
-	err := cmd.Run()
+	return c.err(ctx, cmd.Run())
+}
+
+func (c *Cmd) context() (context.Context, context.CancelFunc) {
+	parent := c.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	return context.WithTimeout(parent, c.timeout)
+}
+
+func (c *Cmd) err(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if c.ctx != nil && c.ctx.Err() != nil {
+		return c.ctx.Err()
+	}
 	if ctx.Err() == context.DeadlineExceeded {
 		return fmt.Errorf("command timed out after %v", c.timeout)
 	}
@@ -71,33 +126,39 @@ func (c *Cmd) Run() error {
 }
 
 func (c *Cmd) Output() ([]byte, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	ctx, cancel := c.context()
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, c.name, c.args...)
+	cmd := CommandContext(ctx, c.name, c.args...)
 	if c.dir != "" {
 		cmd.Dir = c.dir
 	}
+	if c.stdin != nil {
+		cmd.Stdin = c.stdin
+	}
 
 	output, err := cmd.Output()
-	if ctx.Err() == context.DeadlineExceeded {
-		return nil, fmt.Errorf("command timed out after %v", c.timeout)
+	if err != nil && ctx.Err() != nil {
+		return nil, c.err(ctx, err)
 	}
 	return output, err
 }
 
 func (c *Cmd) CombinedOutput() ([]byte, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	ctx, cancel := c.context()
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, c.name, c.args...)
+	cmd := CommandContext(ctx, c.name, c.args...)
 	if c.dir != "" {
 		cmd.Dir = c.dir
 	}
+	if c.stdin != nil {
+		cmd.Stdin = c.stdin
+	}
 
 	output, err := cmd.CombinedOutput()
-	if ctx.Err() == context.DeadlineExceeded {
-		return nil, fmt.Errorf("command timed out after %v", c.timeout)
+	if err != nil && ctx.Err() != nil {
+		return nil, c.err(ctx, err)
 	}
 	return output, err
 }
@@ -109,21 +170,24 @@ type RunResult struct {
 }
 
 func (c *Cmd) RunCapture() (*RunResult, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	ctx, cancel := c.context()
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, c.name, c.args...)
+	cmd := CommandContext(ctx, c.name, c.args...)
 	if c.dir != "" {
 		cmd.Dir = c.dir
 	}
+	if c.stdin != nil {
+		cmd.Stdin = c.stdin
+	}
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
 	err := cmd.Run()
-	if ctx.Err() == context.DeadlineExceeded {
-		return nil, fmt.Errorf("command timed out after %v", c.timeout)
+	if err != nil && ctx.Err() != nil {
+		return nil, c.err(ctx, err)
 	}
 
 	result := &RunResult{
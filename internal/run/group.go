@@ -0,0 +1,180 @@
+package run
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const groupStopTimeout = 10 * time.Second
+
+var prefixColors = []string{"\033[36m", "\033[33m", "\033[32m", "\033[35m", "\033[34m", "\033[31m"}
+
+type GroupProcess struct {
+	Name    string
+	Command string
+	Dir     string
+	Env     []string
+}
+
+// Group runs processes together in the foreground, Procfile style: output is
+// interleaved line by line with a colored name prefix, and when one process
+// exits the others are stopped.
+type Group struct {
+	out   io.Writer
+	procs []GroupProcess
+	width int
+	mu    sync.Mutex
+}
+
+func NewGroup(out io.Writer) *Group {
+	return &Group{out: out}
+}
+
+func (g *Group) Add(p GroupProcess) {
+	g.procs = append(g.procs, p)
+}
+
+// Run starts the processes in the order they were added and waits until one
+// exits or a signal arrives, then stops the rest. It returns the error of the
+// first process that exited.
+func (g *Group) Run(signals <-chan os.Signal) error {
+	g.width = len("piko")
+	for _, p := range g.procs {
+		g.width = max(g.width, len(p.Name))
+	}
+
+	type exit struct {
+		name string
+		err  error
+	}
+	exits := make(chan exit, len(g.procs))
+
+	var cmds []*exec.Cmd
+	var writers []*prefixWriter
+	for i, p := range g.procs {
+		prefix := fmt.Sprintf("%s%-*s |\033[0m ", prefixColors[i%len(prefixColors)], g.width, p.Name)
+		w := &prefixWriter{group: g, prefix: prefix}
+		writers = append(writers, w)
+
+		cmd := exec.Command("sh", "-c", p.Command)
+		cmd.Dir = p.Dir
+		cmd.Env = append(os.Environ(), p.Env...)
+		cmd.Stdout = w
+		cmd.Stderr = w
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+		if err := cmd.Start(); err != nil {
+			g.stop(cmds)
+			return fmt.Errorf("failed to start %s: %w", p.Name, err)
+		}
+		w.Write([]byte(fmt.Sprintf("started (pid %d)\n", cmd.Process.Pid)))
+		cmds = append(cmds, cmd)
+
+		go func() {
+			exits <- exit{p.Name, cmd.Wait()}
+		}()
+	}
+
+	var first exit
+	select {
+	case first = <-exits:
+		g.notice(fmt.Sprintf("%s exited (%s), stopping all processes", first.name, describeExit(first.err)))
+	case sig := <-signals:
+		g.notice(fmt.Sprintf("received %s, stopping all processes", sig))
+	}
+
+	g.signal(cmds, syscall.SIGTERM)
+	remaining := len(cmds)
+	if first.name != "" {
+		remaining--
+	}
+
+	timeout := time.After(groupStopTimeout)
+	for remaining > 0 {
+		select {
+		case <-exits:
+			remaining--
+		case <-signals:
+			g.signal(cmds, syscall.SIGKILL)
+		case <-timeout:
+			g.signal(cmds, syscall.SIGKILL)
+		}
+	}
+
+	for _, w := range writers {
+		w.flush()
+	}
+	return first.err
+}
+
+func (g *Group) stop(cmds []*exec.Cmd) {
+	g.signal(cmds, syscall.SIGTERM)
+	for _, cmd := range cmds {
+		cmd.Wait()
+	}
+}
+
+func (g *Group) signal(cmds []*exec.Cmd, sig syscall.Signal) {
+	for _, cmd := range cmds {
+		if cmd.Process != nil {
+			syscall.Kill(-cmd.Process.Pid, sig)
+		}
+	}
+}
+
+func (g *Group) writeLine(line string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	io.WriteString(g.out, line)
+}
+
+func (g *Group) notice(msg string) {
+	g.writeLine(fmt.Sprintf("\033[2m%-*s |\033[0m %s\n", g.width, "piko", msg))
+}
+
+func describeExit(err error) string {
+	if err == nil {
+		return "status 0"
+	}
+	return err.Error()
+}
+
+// prefixWriter buffers partial lines so output of different processes is
+// interleaved by line, never mid-line.
+type prefixWriter struct {
+	group  *Group
+	prefix string
+	mu     sync.Mutex
+	buf    []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.group.writeLine(w.prefix + string(w.buf[:i+1]))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *prefixWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) > 0 {
+		w.group.writeLine(w.prefix + string(w.buf) + "\n")
+		w.buf = nil
+	}
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"github.com/gwuah/piko/internal/docker"
+)
+
+// onContainersChange tells clients that an environment's containers changed
+// state, e.g. one exited or became healthy.
+func (s *Server) onContainersChange(dockerProject string) {
+	environments, err := s.db.ListAllEnvironments()
+	if err != nil {
+		return
+	}
+	for _, e := range environments {
+		if e.DockerProject == dockerProject {
+			s.broadcastStateChange("containers_changed", e.ProjectID, e.Name)
+			return
+		}
+	}
+}
+
+// projectContainers returns an environment's containers and their overall
+// status from the container cache, or from docker while the cache isn't
+// following docker events.
+func (s *Server) projectContainers(composeDir, dockerProject string) (docker.ContainerStatus, []docker.Container) {
+	if containers, ok := s.containers.Project(dockerProject); ok {
+		return docker.StatusOf(containers), containers
+	}
+	containers, err := docker.ProjectContainers(composeDir, dockerProject)
+	if err != nil {
+		return docker.StatusUnknown, nil
+	}
+	return docker.StatusOf(containers), containers
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gwuah/piko/internal/config"
+	"github.com/gwuah/piko/internal/state"
+)
+
+const defaultEventLimit = 100
+
+type EventResponse struct {
+	ID          int64     `json:"id"`
+	ProjectID   int64     `json:"projectId"`
+	Project     string    `json:"project"`
+	Environment string    `json:"environment,omitempty"`
+	Action      string    `json:"action"`
+	Source      string    `json:"source"`
+	Actor       string    `json:"actor,omitempty"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	Detail      string    `json:"detail,omitempty"`
+	StartedAt   time.Time `json:"startedAt"`
+	DurationMs  int64     `json:"durationMs"`
+}
+
+// handleListEvents serves the event log, newest first. Filters: project (ID),
+// environment, action, since (RFC 3339 time or a duration such as 2h or 7d)
+// and limit.
+func (s *Server) handleListEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := state.EventFilter{
+		Environment: query.Get("environment"),
+		Action:      query.Get("action"),
+		Limit:       defaultEventLimit,
+	}
+
+	if v := query.Get("project"); v != "" {
+		projectID, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, SuccessResponse{Success: false, Error: "invalid project ID"})
+			return
+		}
+		filter.ProjectID = projectID
+	}
+	if v := query.Get("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			d, durationErr := config.ParseDuration(v)
+			if durationErr != nil {
+				writeJSON(w, http.StatusBadRequest, SuccessResponse{Success: false, Error: "invalid since (use RFC 3339 or a duration)"})
+				return
+			}
+			since = time.Now().Add(-d)
+		}
+		filter.Since = since
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			writeJSON(w, http.StatusBadRequest, SuccessResponse{Success: false, Error: "invalid limit"})
+			return
+		}
+		filter.Limit = limit
+	}
+
+	events, err := s.db.ListEvents(filter)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, SuccessResponse{Success: false, Error: err.Error()})
+		return
+	}
+
+	response := make([]EventResponse, 0, len(events))
+	for _, e := range events {
+		response = append(response, EventResponse{
+			ID:          e.ID,
+			ProjectID:   e.ProjectID,
+			Project:     e.ProjectName,
+			Environment: e.Environment,
+			Action:      e.Action,
+			Source:      e.Source,
+			Actor:       e.Actor,
+			Status:      e.Status,
+			Error:       e.Error,
+			Detail:      e.Detail,
+			StartedAt:   e.StartedAt,
+			DurationMs:  e.Duration.Milliseconds(),
+		})
+	}
+	writeJSON(w, http.StatusOK, response)
+}
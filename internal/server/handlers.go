@@ -12,13 +12,13 @@ import (
 
 	"github.com/gwuah/piko/internal/docker"
 	"github.com/gwuah/piko/internal/git"
+	"github.com/gwuah/piko/internal/httpclient"
 	"github.com/gwuah/piko/internal/operations"
-	"github.com/gwuah/piko/internal/run"
+	"github.com/gwuah/piko/internal/ports"
 	"github.com/gwuah/piko/internal/state"
+	"github.com/gwuah/piko/internal/supervisor"
 )
 
-const handlerDockerTimeout = 10 * time.Second
-
 type ProjectResponse struct {
 	ID          int64  `json:"id"`
 	Name        string `json:"name"`
@@ -51,11 +51,27 @@ type EnvironmentResponse struct {
 	Mode       string          `json:"mode"`
 	DataDir    string          `json:"dataDir,omitempty"`
 	EnvID      int64           `json:"envId,omitempty"`
+
+	LastActivityAt time.Time  `json:"lastActivityAt"`
+	HibernatedAt   *time.Time `json:"hibernatedAt,omitempty"`
+	ExpiredAt      *time.Time `json:"expiredAt,omitempty"`
+
+	Processes []supervisor.Status `json:"processes,omitempty"`
+
+	Metadata *state.Metadata `json:"metadata,omitempty"`
 }
 
 type CreateRequest struct {
-	Name   string `json:"name"`
-	Branch string `json:"branch"`
+	Name     string          `json:"name"`
+	Branch   string          `json:"branch"`
+	Paths    []string        `json:"paths,omitempty"`
+	Metadata *state.Metadata `json:"metadata,omitempty"`
+	Wait     bool            `json:"wait,omitempty"`
+}
+
+type RenameRequest struct {
+	Name string `json:"name"`
+	Wait bool   `json:"wait,omitempty"`
 }
 
 type SuccessResponse struct {
@@ -100,6 +116,17 @@ func (s *Server) handleListEnvironments(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	tags := r.URL.Query()["tag"]
+	labels := make(map[string]string)
+	for _, label := range r.URL.Query()["label"] {
+		key, value, ok := strings.Cut(label, "=")
+		if !ok || key == "" {
+			writeJSON(w, http.StatusBadRequest, SuccessResponse{Success: false, Error: fmt.Sprintf("invalid label %q (use key=value)", label)})
+			return
+		}
+		labels[key] = value
+	}
+
 	environments, err := s.db.ListEnvironmentsByProject(project.ID)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, SuccessResponse{Success: false, Error: err.Error()})
@@ -108,6 +135,15 @@ func (s *Server) handleListEnvironments(w http.ResponseWriter, r *http.Request)
 
 	response := make([]EnvironmentResponse, 0, len(environments))
 	for _, e := range environments {
+		metadata, err := s.db.GetEnvironmentMetadata(e.ID)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, SuccessResponse{Success: false, Error: err.Error()})
+			return
+		}
+		if !metadata.Matches(tags, labels) {
+			continue
+		}
+
 		isSimpleMode := e.DockerProject == ""
 
 		envResp := EnvironmentResponse{
@@ -116,20 +152,25 @@ func (s *Server) handleListEnvironments(w http.ResponseWriter, r *http.Request)
 			Path:   e.Path,
 			EnvID:  e.ID,
 		}
+		applyIdleState(&envResp, e)
+		applyMetadata(&envResp, metadata)
 
 		if isSimpleMode {
 			envResp.Mode = "simple"
 			envResp.Status = "simple"
 			envResp.DataDir = filepath.Join(project.RootPath, ".piko", "data", e.Name)
+			s.applyProcesses(&envResp, project, e)
 		} else {
 			envResp.Mode = "docker"
 			composeDir := e.Path
 			if project.ComposeDir != "" {
 				composeDir = filepath.Join(e.Path, project.ComposeDir)
 			}
-			envResp.Status = string(docker.GetProjectStatus(composeDir, e.DockerProject))
-			envResp.Ports, envResp.Containers, envResp.Running, envResp.Total = s.getEnvironmentDetails(composeDir, e.DockerProject)
+			status, containers := s.projectContainers(composeDir, e.DockerProject)
+			envResp.Status = string(status)
+			envResp.Ports, envResp.Containers, envResp.Running, envResp.Total = s.getEnvironmentDetails(project, e, containers)
 		}
+		envResp.Status = idleStatus(envResp.Status, e)
 
 		response = append(response, envResp)
 	}
@@ -160,58 +201,40 @@ func (s *Server) handleGetEnvironment(w http.ResponseWriter, r *http.Request) {
 		Path:   environment.Path,
 		EnvID:  environment.ID,
 	}
+	applyIdleState(&envResp, environment)
+	if metadata, err := s.db.GetEnvironmentMetadata(environment.ID); err == nil {
+		applyMetadata(&envResp, metadata)
+	}
 
 	if isSimpleMode {
 		envResp.Mode = "simple"
 		envResp.Status = "simple"
 		envResp.DataDir = filepath.Join(project.RootPath, ".piko", "data", environment.Name)
+		s.applyProcesses(&envResp, project, environment)
 	} else {
 		envResp.Mode = "docker"
 		composeDir := environment.Path
 		if project.ComposeDir != "" {
 			composeDir = filepath.Join(environment.Path, project.ComposeDir)
 		}
-		envResp.Status = string(docker.GetProjectStatus(composeDir, environment.DockerProject))
-		envResp.Ports, envResp.Containers, envResp.Running, envResp.Total = s.getEnvironmentDetails(composeDir, environment.DockerProject)
+		status, containers := s.projectContainers(composeDir, environment.DockerProject)
+		envResp.Status = string(status)
+		envResp.Ports, envResp.Containers, envResp.Running, envResp.Total = s.getEnvironmentDetails(project, environment, containers)
 	}
+	envResp.Status = idleStatus(envResp.Status, environment)
 
 	writeJSON(w, http.StatusOK, envResp)
 }
 
-func (s *Server) getEnvironmentDetails(composeDir, dockerProject string) ([]PortMapping, []ContainerInfo, int, int) {
+func (s *Server) getEnvironmentDetails(project *state.Project, environment *state.Environment, projectContainers []docker.Container) ([]PortMapping, []ContainerInfo, int, int) {
 	var portMappings []PortMapping
 	var containers []ContainerInfo
 	running := 0
 	total := 0
 
-	output, err := run.Command("docker", "compose", "-p", dockerProject, "ps", "--format", "json").
-		Dir(composeDir).
-		Timeout(handlerDockerTimeout).
-		Output()
-	if err != nil {
-		return portMappings, containers, running, total
-	}
-
 	seenPorts := make(map[string]bool)
 
-	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
-		if line == "" {
-			continue
-		}
-		var c struct {
-			Service    string `json:"Service"`
-			Name       string `json:"Name"`
-			State      string `json:"State"`
-			Health     string `json:"Health"`
-			Publishers []struct {
-				TargetPort    int `json:"TargetPort"`
-				PublishedPort int `json:"PublishedPort"`
-			} `json:"Publishers"`
-		}
-		if err := json.Unmarshal([]byte(line), &c); err != nil {
-			continue
-		}
-
+	for _, c := range projectContainers {
 		total++
 		if c.State == "running" {
 			running++
@@ -238,8 +261,13 @@ func (s *Server) getEnvironmentDetails(composeDir, dockerProject string) ([]Port
 				ContainerPort: pub.TargetPort,
 				HostPort:      pub.PublishedPort,
 			}
-			if isHTTPPort(pub.TargetPort) {
+			if ports.IsHTTP(pub.TargetPort) {
 				pm.URL = fmt.Sprintf("http://localhost:%d", pub.PublishedPort)
+				if s.proxy != nil {
+					if url := s.proxy.URL(c.Service, pub.TargetPort, environment.Name, project.Name); url != "" {
+						pm.URL = url
+					}
+				}
 			}
 			portMappings = append(portMappings, pm)
 		}
@@ -248,14 +276,59 @@ func (s *Server) getEnvironmentDetails(composeDir, dockerProject string) ([]Port
 	return portMappings, containers, running, total
 }
 
-func isHTTPPort(port int) bool {
-	httpPorts := []int{80, 443, 3000, 3001, 4000, 5000, 5173, 8000, 8080, 8081, 8888, 9000}
-	for _, p := range httpPorts {
-		if port == p {
-			return true
+// applyProcesses reports a simple mode environment's reserved ports and
+// supervised processes the way ports and containers are reported for docker
+// environments.
+func (s *Server) applyProcesses(resp *EnvironmentResponse, project *state.Project, environment *state.Environment) {
+	reserved, _ := operations.ListEnvironmentPorts(s.db, environment)
+	for _, alloc := range reserved {
+		resp.Ports = append(resp.Ports, PortMapping{
+			Service:  alloc.Service,
+			HostPort: alloc.HostPort,
+			URL:      fmt.Sprintf("http://localhost:%d", alloc.HostPort),
+		})
+	}
+
+	resp.Processes = s.processStatus(project, environment)
+	for _, p := range resp.Processes {
+		resp.Total++
+		if p.State == supervisor.StateRunning {
+			resp.Running++
 		}
+		resp.Containers = append(resp.Containers, ContainerInfo{Name: p.Name, State: string(p.State)})
+	}
+	if resp.Running > 0 {
+		resp.Status = string(docker.StatusRunning)
+	}
+}
+
+func applyIdleState(resp *EnvironmentResponse, e *state.Environment) {
+	resp.LastActivityAt = e.LastActivityAt
+	if e.HibernatedAt.Valid {
+		resp.HibernatedAt = &e.HibernatedAt.Time
+	}
+	if e.ExpiredAt.Valid {
+		resp.ExpiredAt = &e.ExpiredAt.Time
 	}
-	return false
+}
+
+func applyMetadata(resp *EnvironmentResponse, m *state.Metadata) {
+	if !m.IsEmpty() {
+		resp.Metadata = m
+	}
+}
+
+func idleStatus(status string, e *state.Environment) string {
+	if status == string(docker.StatusRunning) {
+		return status
+	}
+	if e.ExpiredAt.Valid {
+		return "expired"
+	}
+	if e.HibernatedAt.Valid {
+		return "hibernated"
+	}
+	return status
 }
 
 func (s *Server) handleCreateEnvironment(w http.ResponseWriter, r *http.Request) {
@@ -276,44 +349,71 @@ func (s *Server) handleCreateEnvironment(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	result, err := operations.CreateEnvironment(operations.CreateEnvironmentOptions{
-		DB:      s.db,
-		Project: project,
-		Name:    req.Name,
-		Branch:  req.Branch,
-		Logger:  &operations.SilentLogger{},
-	})
+	source := eventSource(r)
+	job, err := s.submitJob(project, "create", req.Name, req, source, s.createJob(project, req, source))
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, SuccessResponse{Success: false, Error: err.Error()})
 		return
 	}
+	writeJSON(w, http.StatusAccepted, s.jobResponse(job))
+}
+
+func (s *Server) handleRenameEnvironment(w http.ResponseWriter, r *http.Request) {
+	var req RenameRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, SuccessResponse{Success: false, Error: "invalid request body"})
+		return
+	}
 
-	status := "running"
-	mode := "docker"
-	if result.IsSimple {
-		status = "simple"
-		mode = "simple"
+	if err := operations.ValidateEnvironmentName(req.Name); err != nil {
+		writeJSON(w, http.StatusBadRequest, SuccessResponse{Success: false, Error: err.Error()})
+		return
 	}
 
-	s.broadcastStateChange("env_created", project.ID, result.Environment.Name)
-	writeJSON(w, http.StatusOK, SuccessResponse{
-		Success: true,
-		Environment: &EnvironmentResponse{
-			Name:    result.Environment.Name,
-			Status:  status,
-			Branch:  result.Environment.Branch,
-			Path:    result.Environment.Path,
-			Mode:    mode,
-			DataDir: result.DataDir,
-			EnvID:   result.Environment.ID,
-		},
-	})
+	project, environment, err := s.getEnvironmentFromPath(r)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, SuccessResponse{Success: false, Error: err.Error()})
+		return
+	}
+
+	source := eventSource(r)
+	job, err := s.submitJob(project, "rename", environment.Name, req, source, s.renameJob(project, environment, req, source))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, SuccessResponse{Success: false, Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusAccepted, s.jobResponse(job))
+}
+
+func (s *Server) handleSetMetadata(w http.ResponseWriter, r *http.Request) {
+	var metadata state.Metadata
+	if err := json.NewDecoder(r.Body).Decode(&metadata); err != nil {
+		writeJSON(w, http.StatusBadRequest, SuccessResponse{Success: false, Error: "invalid request body"})
+		return
+	}
+
+	project, environment, err := s.getEnvironmentFromPath(r)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, SuccessResponse{Success: false, Error: err.Error()})
+		return
+	}
+
+	if err := s.db.SetEnvironmentMetadata(environment.ID, &metadata); err != nil {
+		writeJSON(w, http.StatusInternalServerError, SuccessResponse{Success: false, Error: err.Error()})
+		return
+	}
+
+	s.broadcastStateChange("env_updated", project.ID, environment.Name)
+	writeJSON(w, http.StatusOK, SuccessResponse{Success: true})
 }
 
 func (s *Server) handleDestroyEnvironment(w http.ResponseWriter, r *http.Request) {
 	name := r.PathValue("name")
-	keepVolumes := r.URL.Query().Get("keep-volumes") == "true"
-	deleteBranch := r.URL.Query().Get("force") == "true"
+	req := DestroyJobRequest{
+		RemoveVolumes: r.URL.Query().Get("keep-volumes") != "true",
+		DeleteBranch:  r.URL.Query().Get("force") == "true",
+		Wait:          r.URL.Query().Get("wait") == "true",
+	}
 
 	project, err := s.getProjectFromPath(r)
 	if err != nil {
@@ -327,21 +427,13 @@ func (s *Server) handleDestroyEnvironment(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = operations.DestroyEnvironment(operations.DestroyEnvironmentOptions{
-		DB:            s.db,
-		Project:       project,
-		Environment:   environment,
-		RemoveVolumes: !keepVolumes,
-		DeleteBranch:  deleteBranch,
-		Logger:        &operations.SilentLogger{},
-	})
+	source := eventSource(r)
+	job, err := s.submitJob(project, "destroy", name, req, source, s.destroyJob(project, environment, req, source))
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, SuccessResponse{Success: false, Error: err.Error()})
 		return
 	}
-
-	s.broadcastStateChange("env_deleted", project.ID, name)
-	writeJSON(w, http.StatusOK, SuccessResponse{Success: true})
+	writeJSON(w, http.StatusAccepted, s.jobResponse(job))
 }
 
 func (s *Server) handleOpenInEditor(w http.ResponseWriter, r *http.Request) {
@@ -393,12 +485,25 @@ func (s *Server) handleUp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = operations.UpEnvironment(operations.UpEnvironmentOptions{
-		DB:          s.db,
-		Project:     project,
-		Environment: environment,
-		Logger:      &operations.SilentLogger{},
-	})
+	if environment.DockerProject == "" {
+		err = operations.RunOperation(s.ctx, s.db, project, environment.Name, "up", eventSource(r), func() error {
+			if _, err := operations.ReservePorts(s.db, project, environment); err != nil {
+				return fmt.Errorf("failed to reserve ports: %w", err)
+			}
+			if err := operations.WriteSimpleEnvFiles(s.db, project, environment, &operations.SilentLogger{}); err != nil {
+				return err
+			}
+			return s.startProcesses(project, environment, "")
+		})
+	} else {
+		err = operations.UpEnvironment(s.ctx, operations.UpEnvironmentOptions{
+			DB:          s.db,
+			Project:     project,
+			Environment: environment,
+			Logger:      &operations.SilentLogger{},
+			Source:      eventSource(r),
+		})
+	}
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, SuccessResponse{Success: false, Error: err.Error()})
 		return
@@ -423,12 +528,19 @@ func (s *Server) handleDown(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = operations.DownEnvironment(operations.DownEnvironmentOptions{
-		DB:          s.db,
-		Project:     project,
-		Environment: environment,
-		Logger:      &operations.SilentLogger{},
-	})
+	if environment.DockerProject == "" {
+		err = operations.RunOperation(s.ctx, s.db, project, environment.Name, "down", eventSource(r), func() error {
+			return s.stopProcesses(environment, "")
+		})
+	} else {
+		err = operations.DownEnvironment(s.ctx, operations.DownEnvironmentOptions{
+			DB:          s.db,
+			Project:     project,
+			Environment: environment,
+			Logger:      &operations.SilentLogger{},
+			Source:      eventSource(r),
+		})
+	}
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, SuccessResponse{Success: false, Error: err.Error()})
 		return
@@ -454,13 +566,19 @@ func (s *Server) handleRestart(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = operations.RestartEnvironment(operations.RestartEnvironmentOptions{
-		DB:          s.db,
-		Project:     project,
-		Environment: environment,
-		Service:     service,
-		Logger:      &operations.SilentLogger{},
-	})
+	if environment.DockerProject == "" {
+		s.stopProcesses(environment, service)
+		err = s.startProcesses(project, environment, service)
+	} else {
+		err = operations.RestartEnvironment(s.ctx, operations.RestartEnvironmentOptions{
+			DB:          s.db,
+			Project:     project,
+			Environment: environment,
+			Service:     service,
+			Logger:      &operations.SilentLogger{},
+			Source:      eventSource(r),
+		})
+	}
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, SuccessResponse{Success: false, Error: err.Error()})
 		return
@@ -499,6 +617,10 @@ type StateChangePayload struct {
 }
 
 func (s *Server) broadcastStateChange(eventType string, projectID int64, envName string) {
+	if s.proxy != nil {
+		s.proxy.Refresh()
+	}
+
 	payload, err := json.Marshal(StateChangePayload{
 		EventType: eventType,
 		ProjectID: projectID,
@@ -508,14 +630,18 @@ func (s *Server) broadcastStateChange(eventType string, projectID int64, envName
 		return
 	}
 
-	msg := OrchestraMessage{
+	s.hub.broadcast <- OrchestraMessage{
 		Type:      "state_change",
 		Payload:   payload,
 		Timestamp: time.Now(),
 	}
-	data, err := json.Marshal(msg)
-	if err != nil {
-		return
+}
+
+// eventSource tells requests from the piko CLI apart from other API clients
+// for the event log.
+func eventSource(r *http.Request) string {
+	if r.Header.Get(httpclient.ClientHeader) == "cli" {
+		return operations.SourceCLI
 	}
-	s.hub.broadcast <- data
+	return operations.SourceAPI
 }
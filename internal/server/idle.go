@@ -0,0 +1,119 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gwuah/piko/internal/config"
+	"github.com/gwuah/piko/internal/operations"
+	"github.com/gwuah/piko/internal/tmux"
+)
+
+const idleCheckInterval = time.Minute
+
+func (s *Server) runIdleReaper() {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.checkIdleEnvironments()
+		}
+	}
+}
+
+func (s *Server) checkIdleEnvironments() {
+	// Without knowing who is attached, any environment could look idle.
+	attached, err := tmux.AttachedSessions()
+	if err != nil {
+		log.Printf("[idle] skipping idle check: %v", err)
+		return
+	}
+
+	projects, err := s.db.ListProjects()
+	if err != nil {
+		log.Printf("[idle] failed to list projects: %v", err)
+		return
+	}
+
+	// Expiring may destroy environments; one backup covers the pass.
+	backedUp := false
+
+	now := time.Now()
+	for _, project := range projects {
+		environments, err := s.db.ListEnvironmentsByProject(project.ID)
+		if err != nil {
+			log.Printf("[idle] failed to list environments for %s: %v", project.Name, err)
+			continue
+		}
+		if len(environments) == 0 {
+			continue
+		}
+
+		cfg, err := config.Load(project.RootPath)
+		if err != nil {
+			cfg = &config.Config{}
+		}
+
+		for _, e := range environments {
+			if attached[tmux.SessionName(project.Name, e.Name)] {
+				s.db.TouchEnvironment(e.ID)
+				continue
+			}
+
+			policy := operations.ResolveIdlePolicy(cfg, e)
+			logger := &operations.PrefixLogger{
+				Prefix: fmt.Sprintf("[idle] %s/%s: ", project.Name, e.Name),
+				Next:   &operations.StdoutLogger{},
+			}
+
+			switch policy.Action(e, now) {
+			case operations.IdleHibernate:
+				err := operations.HibernateEnvironment(s.ctx, operations.HibernateEnvironmentOptions{
+					DB:            s.db,
+					Project:       project,
+					Environment:   e,
+					Logger:        logger,
+					Source:        operations.SourceServer,
+					StopProcesses: s.removeProcesses,
+				})
+				if err != nil {
+					logger.Warnf("failed to hibernate: %v", err)
+					continue
+				}
+				s.broadcastStateChange("env_updated", project.ID, e.Name)
+
+			case operations.IdleExpire:
+				if policy.DestroyExpired && !backedUp {
+					if _, err := s.db.Backup("expire"); err != nil {
+						logger.Warnf("failed to expire: %v", err)
+						continue
+					}
+					backedUp = true
+				}
+				err := operations.ExpireEnvironment(s.ctx, operations.ExpireEnvironmentOptions{
+					DB:            s.db,
+					Project:       project,
+					Environment:   e,
+					Destroy:       policy.DestroyExpired,
+					Logger:        logger,
+					Source:        operations.SourceServer,
+					StopProcesses: s.removeProcesses,
+				})
+				if err != nil {
+					logger.Warnf("failed to expire: %v", err)
+					continue
+				}
+				if policy.DestroyExpired {
+					s.broadcastStateChange("env_deleted", project.ID, e.Name)
+				} else {
+					s.broadcastStateChange("env_updated", project.ID, e.Name)
+				}
+			}
+		}
+	}
+}
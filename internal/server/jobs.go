@@ -0,0 +1,604 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/gwuah/piko/internal/operations"
+	"github.com/gwuah/piko/internal/state"
+	"github.com/gwuah/piko/internal/stream"
+	"github.com/gwuah/piko/internal/supervisor"
+)
+
+// jobLogPage is how many log lines are read from the database at a time.
+const jobLogPage = 500
+
+// jobStreamPump is how job output is written to websocket clients. A slow
+// client holds up reading the job's output from the database instead of
+// losing lines.
+var jobStreamPump = stream.PumpOptions{Policy: stream.Block}
+
+type JobResponse struct {
+	ID          int64           `json:"id"`
+	Kind        string          `json:"kind"`
+	ProjectID   int64           `json:"projectId"`
+	Project     string          `json:"project"`
+	Environment string          `json:"environment"`
+	Source      string          `json:"source"`
+	Status      string          `json:"status"`
+	Error       string          `json:"error,omitempty"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	Lines       int64           `json:"lines"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	StartedAt   *time.Time      `json:"startedAt,omitempty"`
+	FinishedAt  *time.Time      `json:"finishedAt,omitempty"`
+}
+
+type JobLogsResponse struct {
+	Lines []stream.LogMessage `json:"lines"`
+	Next  int64               `json:"next"`
+	Done  bool                `json:"done"`
+}
+
+// DestroyJobRequest is the request recorded for destroy jobs.
+type DestroyJobRequest struct {
+	RemoveVolumes bool `json:"removeVolumes"`
+	DeleteBranch  bool `json:"deleteBranch"`
+	Wait          bool `json:"wait,omitempty"`
+}
+
+// jobFunc does a job's work, writing its output through factory. The result
+// is stored with the job as JSON.
+type jobFunc func(ctx context.Context, factory *stream.WriterFactory, log operations.Logger) (any, error)
+
+// jobRun is a job running in this server process.
+type jobRun struct {
+	id     int64
+	kind   string
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	seq     int64
+	started bool
+	done    bool
+	changed chan struct{}
+}
+
+// notify wakes everyone following the job. It must be called with mu held.
+func (jr *jobRun) notify() {
+	close(jr.changed)
+	if !jr.done {
+		jr.changed = make(chan struct{})
+	}
+}
+
+type jobRegistry struct {
+	mu   sync.Mutex
+	runs map[int64]*jobRun
+	wg   sync.WaitGroup
+}
+
+func newJobRegistry() *jobRegistry {
+	return &jobRegistry{runs: make(map[int64]*jobRun)}
+}
+
+func (r *jobRegistry) get(id int64) *jobRun {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.runs[id]
+}
+
+// wait waits up to timeout for running jobs to finish and reports whether
+// they did.
+func (r *jobRegistry) wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// watch returns a channel that is closed when the job writes output or
+// finishes, and whether the job is still running.
+func (r *jobRegistry) watch(id int64) (<-chan struct{}, bool) {
+	jr := r.get(id)
+	if jr == nil {
+		return nil, false
+	}
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	return jr.changed, true
+}
+
+func (s *Server) submitJob(project *state.Project, kind, environment string, request any, source string, run jobFunc) (*state.Job, error) {
+	encoded, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	job := &state.Job{
+		ProjectID:   project.ID,
+		ProjectName: project.Name,
+		Kind:        kind,
+		Environment: environment,
+		Request:     string(encoded),
+		Source:      source,
+	}
+	if err := s.db.InsertJob(job); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	jr := &jobRun{
+		id:      job.ID,
+		kind:    kind,
+		cancel:  cancel,
+		changed: make(chan struct{}),
+	}
+	s.jobs.mu.Lock()
+	s.jobs.runs[job.ID] = jr
+	s.jobs.wg.Add(1)
+	s.jobs.mu.Unlock()
+
+	go s.runJob(ctx, jr, run)
+	return job, nil
+}
+
+func (s *Server) runJob(ctx context.Context, jr *jobRun, run jobFunc) {
+	defer func() {
+		jr.cancel()
+		s.jobs.mu.Lock()
+		delete(s.jobs.runs, jr.id)
+		s.jobs.wg.Done()
+		s.jobs.mu.Unlock()
+
+		jr.mu.Lock()
+		jr.done = true
+		jr.notify()
+		jr.mu.Unlock()
+	}()
+
+	jr.mu.Lock()
+	if ctx.Err() != nil {
+		jr.mu.Unlock()
+		s.finishJob(jr, nil, ctx.Err())
+		return
+	}
+	jr.started = true
+	jr.mu.Unlock()
+
+	if err := s.db.StartJob(jr.id); err != nil {
+		log.Printf("job %d: %v", jr.id, err)
+	}
+
+	factory := stream.NewWriterFactoryFunc(func(msg stream.LogMessage) error {
+		return s.appendJobLog(jr, msg)
+	}, os.Stdout)
+	pikoWriter := factory.Piko()
+	logger := &operations.WriterLogger{Out: pikoWriter, Err: pikoWriter}
+
+	result, err := run(ctx, factory, logger)
+	factory.Flush()
+	s.finishJob(jr, result, err)
+}
+
+func (s *Server) finishJob(jr *jobRun, result any, err error) {
+	status := state.JobSucceeded
+	var errMsg, encoded string
+	switch {
+	case errors.Is(err, context.Canceled):
+		status = state.JobCanceled
+		errMsg = "canceled"
+	case err != nil:
+		status = state.JobFailed
+		errMsg = err.Error()
+	case result != nil:
+		data, marshalErr := json.Marshal(result)
+		if marshalErr == nil {
+			encoded = string(data)
+		}
+	}
+	if err := s.db.FinishJob(jr.id, status, errMsg, encoded); err != nil {
+		log.Printf("job %d: %v", jr.id, err)
+	}
+}
+
+func (s *Server) appendJobLog(jr *jobRun, msg stream.LogMessage) error {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+
+	err := s.db.AppendJobLog(jr.id, &state.JobLog{
+		Seq:    jr.seq,
+		Source: msg.Source,
+		Stream: msg.Stream,
+		Data:   msg.Data,
+	})
+	if err != nil {
+		return err
+	}
+	jr.seq++
+	jr.notify()
+	return nil
+}
+
+// cancelJob cancels a job. Queued jobs stop before doing anything, running
+// creates and renames are rolled back, and running destroys can't be
+// canceled.
+func (s *Server) cancelJob(id int64) error {
+	jr := s.jobs.get(id)
+	if jr == nil {
+		return errors.New("job is not running")
+	}
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	if jr.started && jr.kind == "destroy" {
+		return errors.New("a destroy can't be canceled once it has started")
+	}
+	jr.cancel()
+	return nil
+}
+
+func (s *Server) createJob(project *state.Project, req CreateRequest, source string) jobFunc {
+	return func(ctx context.Context, factory *stream.WriterFactory, log operations.Logger) (any, error) {
+		gitStdout, gitStderr := factory.Git()
+		dockerStdout, dockerStderr := factory.Docker()
+		prepareStdout, prepareStderr := factory.Prepare()
+		setupStdout, setupStderr := factory.Setup()
+
+		result, err := operations.CreateEnvironment(ctx, operations.CreateEnvironmentOptions{
+			DB:       s.db,
+			Project:  project,
+			Name:     req.Name,
+			Branch:   req.Branch,
+			Paths:    req.Paths,
+			Metadata: req.Metadata,
+			Logger:   log,
+			Output: &operations.OutputWriters{
+				GitStdout:     gitStdout,
+				GitStderr:     gitStderr,
+				FilesStdout:   factory.Files(),
+				DockerStdout:  dockerStdout,
+				DockerStderr:  dockerStderr,
+				PrepareStdout: prepareStdout,
+				PrepareStderr: prepareStderr,
+				SetupStdout:   setupStdout,
+				SetupStderr:   setupStderr,
+			},
+			Source: source,
+			Wait:   req.Wait,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		s.broadcastStateChange("env_created", project.ID, result.Environment.Name)
+		s.wakePoolFiller()
+
+		mode := "docker"
+		status := "running"
+		if result.IsSimple {
+			mode = "simple"
+			status = "simple"
+		}
+		return &stream.Environment{
+			ID:     result.Environment.ID,
+			Name:   result.Environment.Name,
+			Branch: result.Environment.Branch,
+			Path:   result.Environment.Path,
+			Mode:   mode,
+			Status: status,
+		}, nil
+	}
+}
+
+func (s *Server) destroyJob(project *state.Project, environment *state.Environment, req DestroyJobRequest, source string) jobFunc {
+	return func(ctx context.Context, factory *stream.WriterFactory, log operations.Logger) (any, error) {
+		destroyStdout, destroyStderr := factory.Destroy()
+		dockerStdout, dockerStderr := factory.Docker()
+
+		if _, err := s.db.Backup("destroy"); err != nil {
+			return nil, err
+		}
+		err := operations.DestroyEnvironment(ctx, operations.DestroyEnvironmentOptions{
+			DB:            s.db,
+			Project:       project,
+			Environment:   environment,
+			RemoveVolumes: req.RemoveVolumes,
+			DeleteBranch:  req.DeleteBranch,
+			Logger:        log,
+			Output: &operations.DestroyOutputWriters{
+				DestroyStdout: destroyStdout,
+				DestroyStderr: destroyStderr,
+				DockerStdout:  dockerStdout,
+				DockerStderr:  dockerStderr,
+			},
+			Source:        source,
+			Wait:          req.Wait,
+			StopProcesses: s.removeProcesses,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		s.broadcastStateChange("env_deleted", project.ID, environment.Name)
+		return nil, nil
+	}
+}
+
+func (s *Server) renameJob(project *state.Project, environment *state.Environment, req RenameRequest, source string) jobFunc {
+	return func(ctx context.Context, factory *stream.WriterFactory, log operations.Logger) (any, error) {
+		// Supervised processes run in the old worktree; stop them for the
+		// rename and start them again in the new one.
+		var running []string
+		for _, st := range s.supervisor.Status(environment.ID) {
+			if st.State == supervisor.StateRunning || st.State == supervisor.StateRestarting {
+				running = append(running, st.Name)
+			}
+		}
+		s.supervisor.Remove(environment.ID)
+
+		renamed, err := operations.RenameEnvironment(ctx, operations.RenameEnvironmentOptions{
+			DB:          s.db,
+			Project:     project,
+			Environment: environment,
+			NewName:     req.Name,
+			Logger:      log,
+			Source:      source,
+			Wait:        req.Wait,
+		})
+		if err != nil {
+			renamed = environment
+		}
+		for _, name := range running {
+			s.startProcesses(project, renamed, name)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		s.broadcastStateChange("env_deleted", project.ID, environment.Name)
+		s.broadcastStateChange("env_created", project.ID, renamed.Name)
+		return nil, nil
+	}
+}
+
+func (s *Server) jobResponse(job *state.Job) JobResponse {
+	resp := JobResponse{
+		ID:          job.ID,
+		Kind:        job.Kind,
+		ProjectID:   job.ProjectID,
+		Project:     job.ProjectName,
+		Environment: job.Environment,
+		Source:      job.Source,
+		Status:      job.Status,
+		Error:       job.Error,
+		CreatedAt:   job.CreatedAt,
+	}
+	if job.Result != "" {
+		resp.Result = json.RawMessage(job.Result)
+	}
+	if job.StartedAt.Valid {
+		resp.StartedAt = &job.StartedAt.Time
+	}
+	if job.FinishedAt.Valid {
+		resp.FinishedAt = &job.FinishedAt.Time
+	}
+	resp.Lines, _ = s.db.CountJobLogs(job.ID)
+	return resp
+}
+
+func (s *Server) getJobFromPath(r *http.Request) (*state.Job, error) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid job ID")
+	}
+	return s.db.GetJob(id)
+}
+
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := state.JobFilter{Status: query.Get("status"), Limit: 50}
+	if v := query.Get("project"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, SuccessResponse{Success: false, Error: "invalid project ID"})
+			return
+		}
+		filter.ProjectID = id
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			writeJSON(w, http.StatusBadRequest, SuccessResponse{Success: false, Error: "invalid limit"})
+			return
+		}
+		filter.Limit = limit
+	}
+
+	jobs, err := s.db.ListJobs(filter)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, SuccessResponse{Success: false, Error: err.Error()})
+		return
+	}
+	response := make([]JobResponse, 0, len(jobs))
+	for _, job := range jobs {
+		response = append(response, s.jobResponse(job))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, err := s.getJobFromPath(r)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, SuccessResponse{Success: false, Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, s.jobResponse(job))
+}
+
+func (s *Server) handleJobLogs(w http.ResponseWriter, r *http.Request) {
+	job, err := s.getJobFromPath(r)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, SuccessResponse{Success: false, Error: err.Error()})
+		return
+	}
+	offset, err := jobOffset(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, SuccessResponse{Success: false, Error: err.Error()})
+		return
+	}
+
+	logs, err := s.db.JobLogs(job.ID, offset, jobLogPage)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, SuccessResponse{Success: false, Error: err.Error()})
+		return
+	}
+	response := JobLogsResponse{Lines: make([]stream.LogMessage, 0, len(logs)), Next: offset}
+	for _, l := range logs {
+		response.Lines = append(response.Lines, jobLogMessage(l))
+		response.Next = l.Seq + 1
+	}
+	response.Done = job.Done() && len(logs) < jobLogPage
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	job, err := s.getJobFromPath(r)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, SuccessResponse{Success: false, Error: err.Error()})
+		return
+	}
+	if job.Done() {
+		writeJSON(w, http.StatusConflict, SuccessResponse{Success: false, Error: "job already " + job.Status})
+		return
+	}
+	if err := s.cancelJob(job.ID); err != nil {
+		writeJSON(w, http.StatusConflict, SuccessResponse{Success: false, Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusAccepted, SuccessResponse{Success: true})
+}
+
+func (s *Server) handleJobLogsStream(w http.ResponseWriter, r *http.Request) {
+	job, err := s.getJobFromPath(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	offset, err := jobOffset(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("websocket upgrade failed: %v", err)
+		return
+	}
+	pump := stream.NewPump(conn, jobStreamPump)
+	defer pump.Close()
+
+	s.followJob(pump, job.ID, offset, readUntilClosed(conn))
+}
+
+// readUntilClosed discards what the client sends and closes the returned
+// channel once the connection is gone.
+func readUntilClosed(conn *websocket.Conn) <-chan struct{} {
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	return gone
+}
+
+// followJob sends a job's output from offset through pump as it is written,
+// then a completion message once the job has finished. It reports whether it
+// got as far as the job finishing; it stops early if the client goes away.
+func (s *Server) followJob(pump *stream.Pump, id, offset int64, gone <-chan struct{}) bool {
+	for {
+		changed, running := s.jobs.watch(id)
+
+		logs, err := s.db.JobLogs(id, offset, jobLogPage)
+		if err != nil {
+			stream.SendError(pump, err.Error())
+			return false
+		}
+		for _, l := range logs {
+			if err := pump.Send(jobLogMessage(l)); err != nil {
+				return false
+			}
+			offset = l.Seq + 1
+		}
+		if len(logs) == jobLogPage {
+			continue
+		}
+
+		if !running {
+			job, err := s.db.GetJob(id)
+			if err != nil {
+				stream.SendError(pump, err.Error())
+				return true
+			}
+			if job.Status != state.JobSucceeded {
+				stream.SendError(pump, job.Error)
+				return true
+			}
+			var environment *stream.Environment
+			if job.Result != "" {
+				environment = &stream.Environment{}
+				json.Unmarshal([]byte(job.Result), environment)
+			}
+			stream.SendComplete(pump, environment)
+			return true
+		}
+
+		select {
+		case <-changed:
+		case <-gone:
+			return false
+		case <-pump.Done():
+			return false
+		}
+	}
+}
+
+func jobLogMessage(l *state.JobLog) stream.LogMessage {
+	return stream.LogMessage{
+		Type:   "log",
+		Seq:    l.Seq,
+		Source: l.Source,
+		Stream: l.Stream,
+		Data:   l.Data,
+	}
+}
+
+func jobOffset(r *http.Request) (int64, error) {
+	v := r.URL.Query().Get("offset")
+	if v == "" {
+		return 0, nil
+	}
+	offset, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, errors.New("invalid offset")
+	}
+	return offset, nil
+}
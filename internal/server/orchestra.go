@@ -12,6 +12,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/gwuah/piko/internal/process"
+	"github.com/gwuah/piko/internal/stream"
 	"github.com/gwuah/piko/internal/tmux"
 )
 
@@ -36,6 +37,22 @@ type OrchestraMessage struct {
 	Type      string          `json:"type"`
 	Payload   json.RawMessage `json:"payload"`
 	Timestamp time.Time       `json:"timestamp"`
+
+	// Seq numbers the messages sent on one connection. A gap means the
+	// client fell behind and messages were dropped; it should reload.
+	Seq int64 `json:"seq,omitempty"`
+}
+
+func (m OrchestraMessage) WithSeq(seq int64) any {
+	m.Seq = seq
+	return m
+}
+
+// hubPump is how the hub writes to UI clients: a client that falls behind
+// loses its oldest messages, and notices from the gap in Seq.
+var hubPump = stream.PumpOptions{
+	Policy:       stream.DropOldest,
+	PingInterval: 30 * time.Second,
 }
 
 type CCNotification struct {
@@ -72,7 +89,7 @@ type RespondRequest struct {
 
 type Hub struct {
 	clients               map[*Client]bool
-	broadcast             chan []byte
+	broadcast             chan OrchestraMessage
 	register              chan *Client
 	unregister            chan *Client
 	done                  chan struct{}
@@ -84,14 +101,14 @@ type Hub struct {
 type Client struct {
 	hub      *Hub
 	conn     *websocket.Conn
-	send     chan []byte
+	pump     *stream.Pump
 	existing []*CCNotification
 }
 
 func NewHub() *Hub {
 	return &Hub{
 		clients:               make(map[*Client]bool),
-		broadcast:             make(chan []byte, 256),
+		broadcast:             make(chan OrchestraMessage, 256),
 		register:              make(chan *Client),
 		unregister:            make(chan *Client),
 		done:                  make(chan struct{}),
@@ -105,7 +122,7 @@ func (h *Hub) Run() {
 		select {
 		case <-h.done:
 			for client := range h.clients {
-				close(client.send)
+				client.pump.Close()
 			}
 			return
 		case client := <-h.register:
@@ -116,34 +133,23 @@ func (h *Hub) Run() {
 					log.Printf("failed to marshal existing notification payload: %v", err)
 					continue
 				}
-				msg := OrchestraMessage{
+				client.pump.Send(OrchestraMessage{
 					Type:      "notification",
 					Payload:   payload,
 					Timestamp: time.Now(),
-				}
-				data, err := json.Marshal(msg)
-				if err != nil {
-					log.Printf("failed to marshal existing notification message: %v", err)
-					continue
-				}
-				select {
-				case client.send <- data:
-				default:
-				}
+				})
 			}
 			client.existing = nil
 		case client := <-h.unregister:
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
-				close(client.send)
+				client.pump.Close()
 			}
 		case message := <-h.broadcast:
 			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
+				if err := client.pump.Send(message); err != nil {
 					delete(h.clients, client)
+					client.pump.Close()
 				}
 			}
 		}
@@ -167,17 +173,11 @@ func (h *Hub) AddNotification(n *CCNotification) {
 		log.Printf("failed to marshal notification payload: %v", err)
 		return
 	}
-	msg := OrchestraMessage{
+	h.broadcast <- OrchestraMessage{
 		Type:      "notification",
 		Payload:   payload,
 		Timestamp: time.Now(),
 	}
-	data, err := json.Marshal(msg)
-	if err != nil {
-		log.Printf("failed to marshal notification message: %v", err)
-		return
-	}
-	h.broadcast <- data
 }
 
 func (h *Hub) RemoveNotification(id string) *CCNotification {
@@ -197,17 +197,11 @@ func (h *Hub) RemoveNotification(id string) *CCNotification {
 			log.Printf("failed to marshal dismiss payload: %v", err)
 			return n
 		}
-		msg := OrchestraMessage{
+		h.broadcast <- OrchestraMessage{
 			Type:      "notification_dismissed",
 			Payload:   payload,
 			Timestamp: time.Now(),
 		}
-		data, err := json.Marshal(msg)
-		if err != nil {
-			log.Printf("failed to marshal dismiss message: %v", err)
-			return n
-		}
-		h.broadcast <- data
 	}
 
 	return n
@@ -260,34 +254,6 @@ func (c *Client) readPump() {
 	}
 }
 
-func (c *Client) writePump() {
-	ticker := time.NewTicker(30 * time.Second)
-	defer func() {
-		ticker.Stop()
-		c.conn.Close()
-	}()
-
-	for {
-		select {
-		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
-
-			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
-				return
-			}
-		case <-ticker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				return
-			}
-		}
-	}
-}
-
 func (s *Server) handleOrchestraWS(w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -300,11 +266,10 @@ func (s *Server) handleOrchestraWS(w http.ResponseWriter, r *http.Request) {
 	client := &Client{
 		hub:      s.hub,
 		conn:     conn,
-		send:     make(chan []byte, 256),
+		pump:     stream.NewPump(conn, hubPump),
 		existing: existing,
 	}
 
-	go client.writePump()
 	go client.readPump()
 
 	s.hub.register <- client
@@ -319,6 +284,8 @@ func (s *Server) handleOrchestraNotify(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Printf("[notify] received: project=%s env=%s pid=%d tmux_pane=%q type=%s tool=%s (decode took %v)", req.ProjectName, req.EnvName, req.ParentPID, req.TmuxTarget, req.NotificationType, req.ToolName, time.Since(start))
 
+	s.touchEnvironmentByName(req.ProjectName, req.EnvName)
+
 	tmuxTarget := req.TmuxTarget
 	if tmuxTarget == "" && req.ParentPID > 0 {
 		paneStart := time.Now()
@@ -439,3 +406,18 @@ func (s *Server) handleOrchestraDismiss(w http.ResponseWriter, r *http.Request)
 
 	writeJSON(w, http.StatusOK, SuccessResponse{Success: true})
 }
+
+func (s *Server) touchEnvironmentByName(projectName, envName string) {
+	if projectName == "" || envName == "" {
+		return
+	}
+	project, err := s.db.GetProjectByName(projectName)
+	if err != nil {
+		return
+	}
+	environment, err := s.db.GetEnvironmentByName(project.ID, envName)
+	if err != nil {
+		return
+	}
+	s.db.TouchEnvironment(environment.ID)
+}
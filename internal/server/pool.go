@@ -0,0 +1,84 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gwuah/piko/internal/operations"
+)
+
+const poolCheckInterval = time.Minute
+
+// runPoolFiller keeps each project's pool of spare environments filled. It
+// runs on a timer and right after a create, which may have claimed a spare.
+func (s *Server) runPoolFiller() {
+	s.resetPools()
+
+	ticker := time.NewTicker(poolCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		s.fillPools()
+
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		case <-s.poolWake:
+		}
+	}
+}
+
+// wakePoolFiller asks the pool filler to run now without blocking.
+func (s *Server) wakePoolFiller() {
+	select {
+	case s.poolWake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Server) resetPools() {
+	projects, err := s.db.ListProjects()
+	if err != nil {
+		log.Printf("[pool] failed to list projects: %v", err)
+		return
+	}
+	for _, project := range projects {
+		operations.ResetPool(s.ctx, s.db, project, s.poolLogger(project.Name))
+	}
+}
+
+func (s *Server) fillPools() {
+	projects, err := s.db.ListProjects()
+	if err != nil {
+		log.Printf("[pool] failed to list projects: %v", err)
+		return
+	}
+
+	for _, project := range projects {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+
+		_, err := operations.FillPool(s.ctx, operations.FillPoolOptions{
+			DB:            s.db,
+			Project:       project,
+			Logger:        s.poolLogger(project.Name),
+			Source:        operations.SourceServer,
+			StopProcesses: s.removeProcesses,
+		})
+		if err != nil {
+			log.Printf("[pool] %s: %v", project.Name, err)
+		}
+	}
+}
+
+func (s *Server) poolLogger(projectName string) operations.Logger {
+	return &operations.PrefixLogger{
+		Prefix: fmt.Sprintf("[pool] %s: ", projectName),
+		Next:   &operations.StdoutLogger{},
+	}
+}
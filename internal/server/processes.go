@@ -0,0 +1,184 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gwuah/piko/internal/operations"
+	"github.com/gwuah/piko/internal/state"
+	"github.com/gwuah/piko/internal/supervisor"
+)
+
+func (s *Server) onProcessChange(envID int64) {
+	environment, err := s.db.GetEnvironmentByID(envID)
+	if err != nil {
+		return
+	}
+	s.broadcastStateChange("env_updated", environment.ProjectID, environment.Name)
+}
+
+// processStatus lists the processes declared in .piko.yml, including ones
+// that were never started, merged with what the supervisor is running.
+func (s *Server) processStatus(project *state.Project, environment *state.Environment) []supervisor.Status {
+	running := make(map[string]supervisor.Status)
+	for _, st := range s.supervisor.Status(environment.ID) {
+		running[st.Name] = st
+	}
+
+	specs, _ := operations.ProcessSpecs(s.db, project, environment)
+	statuses := make([]supervisor.Status, 0, len(specs))
+	for _, spec := range specs {
+		if st, ok := running[spec.Name]; ok {
+			statuses = append(statuses, st)
+			delete(running, spec.Name)
+			continue
+		}
+		statuses = append(statuses, supervisor.Status{
+			Name:    spec.Name,
+			Command: spec.Command,
+			State:   supervisor.StateStopped,
+			LogPath: spec.LogPath,
+		})
+	}
+	for _, st := range running {
+		statuses = append(statuses, st)
+	}
+	return statuses
+}
+
+// startProcesses starts one named process, or all declared processes when
+// name is empty.
+func (s *Server) startProcesses(project *state.Project, environment *state.Environment, name string) error {
+	specs, err := operations.ProcessSpecs(s.db, project, environment)
+	if err != nil {
+		return err
+	}
+	if name != "" {
+		spec, err := operations.FindProcessSpec(specs, name)
+		if err != nil {
+			return err
+		}
+		specs = []supervisor.Spec{spec}
+	}
+
+	for _, spec := range specs {
+		if err := s.supervisor.Start(environment.ID, spec); err != nil {
+			return err
+		}
+	}
+	s.db.SetEnvironmentHibernated(environment.ID, false)
+	s.db.TouchEnvironment(environment.ID)
+	return nil
+}
+
+// removeProcesses stops an environment's processes and forgets them. It is
+// the StopProcesses hook of operations that hibernate, expire or destroy an
+// environment.
+func (s *Server) removeProcesses(environment *state.Environment) {
+	s.supervisor.Remove(environment.ID)
+}
+
+func (s *Server) stopProcesses(environment *state.Environment, name string) error {
+	if name == "" {
+		s.supervisor.StopEnvironment(environment.ID)
+		return nil
+	}
+	return s.supervisor.Stop(environment.ID, name)
+}
+
+func (s *Server) getEnvironmentFromPath(r *http.Request) (*state.Project, *state.Environment, error) {
+	project, err := s.getProjectFromPath(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	name := r.PathValue("name")
+	environment, err := s.db.GetEnvironmentByName(project.ID, name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("environment %q not found", name)
+	}
+	return project, environment, nil
+}
+
+func (s *Server) handleListProcesses(w http.ResponseWriter, r *http.Request) {
+	project, environment, err := s.getEnvironmentFromPath(r)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, SuccessResponse{Success: false, Error: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.processStatus(project, environment))
+}
+
+func (s *Server) handleStartProcesses(w http.ResponseWriter, r *http.Request) {
+	project, environment, err := s.getEnvironmentFromPath(r)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, SuccessResponse{Success: false, Error: err.Error()})
+		return
+	}
+
+	if err := s.startProcesses(project, environment, r.URL.Query().Get("process")); err != nil {
+		writeJSON(w, http.StatusBadRequest, SuccessResponse{Success: false, Error: err.Error()})
+		return
+	}
+
+	s.broadcastStateChange("env_updated", project.ID, environment.Name)
+	writeJSON(w, http.StatusOK, SuccessResponse{Success: true})
+}
+
+func (s *Server) handleStopProcesses(w http.ResponseWriter, r *http.Request) {
+	project, environment, err := s.getEnvironmentFromPath(r)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, SuccessResponse{Success: false, Error: err.Error()})
+		return
+	}
+
+	if err := s.stopProcesses(environment, r.URL.Query().Get("process")); err != nil {
+		writeJSON(w, http.StatusBadRequest, SuccessResponse{Success: false, Error: err.Error()})
+		return
+	}
+
+	s.broadcastStateChange("env_updated", project.ID, environment.Name)
+	writeJSON(w, http.StatusOK, SuccessResponse{Success: true})
+}
+
+func (s *Server) handleProcessLogs(w http.ResponseWriter, r *http.Request) {
+	project, environment, err := s.getEnvironmentFromPath(r)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, SuccessResponse{Success: false, Error: err.Error()})
+		return
+	}
+
+	// Only declared processes have logs; the name is never used as a path.
+	specs, err := operations.ProcessSpecs(s.db, project, environment)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, SuccessResponse{Success: false, Error: err.Error()})
+		return
+	}
+	spec, err := operations.FindProcessSpec(specs, r.PathValue("process"))
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, SuccessResponse{Success: false, Error: err.Error()})
+		return
+	}
+
+	lines := 200
+	if v := r.URL.Query().Get("lines"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			lines = n
+		}
+	}
+
+	data, err := supervisor.Tail(spec.LogPath, lines)
+	if os.IsNotExist(err) {
+		writeJSON(w, http.StatusNotFound, SuccessResponse{Success: false, Error: "no logs for this process"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, SuccessResponse{Success: false, Error: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(data)
+}
@@ -12,7 +12,10 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/gwuah/piko/internal/docker"
+	"github.com/gwuah/piko/internal/proxy"
 	"github.com/gwuah/piko/internal/state"
+	"github.com/gwuah/piko/internal/supervisor"
 	"github.com/gwuah/piko/internal/version"
 )
 
@@ -25,19 +28,59 @@ type Server struct {
 	server  *http.Server
 	hub     *Hub
 	devMode bool
+	done    chan struct{}
+	proxy   *proxy.Proxy
+
+	// ctx is canceled on shutdown; operations the server runs derive from
+	// it so they roll back instead of being cut off.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	poolWake   chan struct{}
+	jobs       *jobRegistry
+	containers *docker.ContainerCache
+
+	supervisor *supervisor.Supervisor
 }
 
+// jobShutdownGrace is how long shutdown waits for canceled jobs to roll
+// back.
+const jobShutdownGrace = 10 * time.Second
+
 func New(port int, db *state.DB) *Server {
-	return &Server{
+	s := &Server{
 		port:    port,
 		db:      db,
 		hub:     NewHub(),
 		devMode: os.Getenv("PIKO_DEV") == "1",
+		done:    make(chan struct{}),
+
+		poolWake: make(chan struct{}, 1),
+		jobs:     newJobRegistry(),
 	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.supervisor = supervisor.New(s.onProcessChange)
+	s.containers = docker.NewContainerCache(s.onContainersChange)
+	return s
+}
+
+func (s *Server) WithProxy(p *proxy.Proxy) *Server {
+	s.proxy = p.WithContainers(s.containers)
+	return s
 }
 
 func (s *Server) Start() error {
+	if removed, err := s.db.RemoveStaleLocks(); err == nil && removed > 0 {
+		fmt.Printf("→ Removed %d stale lock(s)\n", removed)
+	}
+	if failed, err := s.db.FailInterruptedJobs(); err == nil && failed > 0 {
+		fmt.Printf("→ Marked %d interrupted job(s) as failed\n", failed)
+	}
+
 	go s.hub.Run()
+	go s.containers.Run(s.ctx)
+	go s.runIdleReaper()
+	go s.runPoolFiller()
 
 	mux := http.NewServeMux()
 
@@ -48,7 +91,13 @@ func (s *Server) Start() error {
 	mux.HandleFunc("DELETE /api/ws/orchestra/notifications/{id}", s.handleOrchestraDismiss)
 	mux.HandleFunc("GET /api/ws/projects/{projectID}/environments/create/stream", s.handleCreateEnvironmentStream)
 	mux.HandleFunc("GET /api/ws/projects/{projectID}/environments/{name}/destroy/stream", s.handleDestroyEnvironmentStream)
+	mux.HandleFunc("GET /api/ws/jobs/{id}/logs", s.handleJobLogsStream)
 
+	mux.HandleFunc("GET /api/events", s.handleListEvents)
+	mux.HandleFunc("GET /api/jobs", s.handleListJobs)
+	mux.HandleFunc("GET /api/jobs/{id}", s.handleGetJob)
+	mux.HandleFunc("GET /api/jobs/{id}/logs", s.handleJobLogs)
+	mux.HandleFunc("POST /api/jobs/{id}/cancel", s.handleCancelJob)
 	mux.HandleFunc("GET /api/projects", s.handleListProjects)
 	mux.HandleFunc("GET /api/projects/{projectID}/branches", s.handleListBranches)
 	mux.HandleFunc("GET /api/projects/{projectID}/environments", s.handleListEnvironments)
@@ -59,6 +108,12 @@ func (s *Server) Start() error {
 	mux.HandleFunc("POST /api/projects/{projectID}/environments/{name}/down", s.handleDown)
 	mux.HandleFunc("POST /api/projects/{projectID}/environments/{name}/restart", s.handleRestart)
 	mux.HandleFunc("DELETE /api/projects/{projectID}/environments/{name}", s.handleDestroyEnvironment)
+	mux.HandleFunc("POST /api/projects/{projectID}/environments/{name}/rename", s.handleRenameEnvironment)
+	mux.HandleFunc("PUT /api/projects/{projectID}/environments/{name}/metadata", s.handleSetMetadata)
+	mux.HandleFunc("GET /api/projects/{projectID}/environments/{name}/processes", s.handleListProcesses)
+	mux.HandleFunc("POST /api/projects/{projectID}/environments/{name}/processes/start", s.handleStartProcesses)
+	mux.HandleFunc("POST /api/projects/{projectID}/environments/{name}/processes/stop", s.handleStopProcesses)
+	mux.HandleFunc("GET /api/projects/{projectID}/environments/{name}/processes/{process}/logs", s.handleProcessLogs)
 
 	if s.devMode {
 		mux.Handle("GET /", http.FileServer(http.Dir("internal/server/static")))
@@ -94,13 +149,37 @@ func (s *Server) Start() error {
 	go func() {
 		<-done
 		fmt.Println("\nShutting down...")
+		close(s.done)
+		s.cancel()
+		if !s.jobs.wait(jobShutdownGrace) {
+			fmt.Println("→ Gave up waiting for running jobs to roll back")
+		}
+		s.supervisor.Shutdown()
 		s.hub.Stop()
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
+		if s.proxy != nil {
+			s.proxy.Shutdown(ctx)
+		}
 		s.server.Shutdown(ctx)
 	}()
 
 	fmt.Printf("→ Piko server (%s) running at http://localhost:%d\n", version.Info(), s.port)
+	if s.proxy != nil {
+		if err := s.proxy.Listen(); err != nil {
+			fmt.Printf("→ Proxy failed: %v\n", err)
+		} else {
+			go func() {
+				if err := s.proxy.Serve(); err != nil {
+					fmt.Printf("→ Proxy failed: %v\n", err)
+				}
+			}()
+			fmt.Printf("→ Proxy routing http://<service>.<env>.<project>.localhost:%d\n", s.proxy.Port())
+			if s.proxy.TLSPort() > 0 {
+				fmt.Printf("→ Proxy routing https://<service>.<env>.<project>.localhost:%d\n", s.proxy.TLSPort())
+			}
+		}
+	}
 	if err := s.server.ListenAndServe(); err != http.ErrServerClosed {
 		return err
 	}
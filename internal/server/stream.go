@@ -5,18 +5,20 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 	"strconv"
 
-	"github.com/gwuah/piko/internal/operations"
+	"github.com/gorilla/websocket"
+	"github.com/gwuah/piko/internal/state"
 	"github.com/gwuah/piko/internal/stream"
 )
 
 type StreamCreateRequest struct {
-	Action      string `json:"action"`
-	Project     string `json:"project"`
-	Environment string `json:"environment"`
-	Branch      string `json:"branch"`
+	Action      string          `json:"action"`
+	Project     string          `json:"project"`
+	Environment string          `json:"environment"`
+	Branch      string          `json:"branch"`
+	Paths       []string        `json:"paths,omitempty"`
+	Metadata    *state.Metadata `json:"metadata,omitempty"`
 }
 
 func (s *Server) handleCreateEnvironmentStream(w http.ResponseWriter, r *http.Request) {
@@ -38,7 +40,8 @@ func (s *Server) handleCreateEnvironmentStream(w http.ResponseWriter, r *http.Re
 		log.Printf("websocket upgrade failed: %v", err)
 		return
 	}
-	defer conn.Close()
+	pump := stream.NewPump(conn, jobStreamPump)
+	defer pump.Close()
 
 	_, message, err := conn.ReadMessage()
 	if err != nil {
@@ -48,74 +51,28 @@ func (s *Server) handleCreateEnvironmentStream(w http.ResponseWriter, r *http.Re
 
 	var req StreamCreateRequest
 	if err := json.Unmarshal(message, &req); err != nil {
-		stream.SendError(conn, "invalid request format")
+		stream.SendError(pump, "invalid request format")
 		return
 	}
 
 	if req.Environment == "" {
-		stream.SendError(conn, "environment name is required")
+		stream.SendError(pump, "environment name is required")
 		return
 	}
 
-	factory := stream.NewWriterFactory(conn, os.Stdout)
-	gitStdout, gitStderr := factory.Git()
-	dockerStdout, dockerStderr := factory.Docker()
-	prepareStdout, prepareStderr := factory.Prepare()
-	setupStdout, setupStderr := factory.Setup()
-
-	pikoWriter := factory.Piko()
-	pikoLogger := &operations.WriterLogger{Out: pikoWriter, Err: pikoWriter}
-
-	result, err := operations.CreateEnvironment(operations.CreateEnvironmentOptions{
-		DB:      s.db,
-		Project: project,
-		Name:    req.Environment,
-		Branch:  req.Branch,
-		Logger:  pikoLogger,
-		Output: &operations.OutputWriters{
-			GitStdout:     gitStdout,
-			GitStderr:     gitStderr,
-			DockerStdout:  dockerStdout,
-			DockerStderr:  dockerStderr,
-			PrepareStdout: prepareStdout,
-			PrepareStderr: prepareStderr,
-			SetupStdout:   setupStdout,
-			SetupStderr:   setupStderr,
-		},
-	})
-
-	gitStdout.Flush()
-	gitStderr.Flush()
-	dockerStdout.Flush()
-	dockerStderr.Flush()
-	prepareStdout.Flush()
-	prepareStderr.Flush()
-	setupStdout.Flush()
-	setupStderr.Flush()
-	pikoWriter.Flush()
-
+	create := CreateRequest{
+		Name:     req.Environment,
+		Branch:   req.Branch,
+		Paths:    req.Paths,
+		Metadata: req.Metadata,
+	}
+	source := eventSource(r)
+	job, err := s.submitJob(project, "create", req.Environment, create, source, s.createJob(project, create, source))
 	if err != nil {
-		stream.SendError(conn, err.Error())
+		stream.SendError(pump, err.Error())
 		return
 	}
-
-	mode := "docker"
-	status := "running"
-	if result.IsSimple {
-		mode = "simple"
-		status = "simple"
-	}
-
-	s.broadcastStateChange("env_created", project.ID, result.Environment.Name)
-
-	stream.SendComplete(conn, &stream.Environment{
-		ID:     result.Environment.ID,
-		Name:   result.Environment.Name,
-		Branch: result.Environment.Branch,
-		Path:   result.Environment.Path,
-		Mode:   mode,
-		Status: status,
-	})
+	s.streamJob(conn, pump, job.ID)
 }
 
 type StreamDestroyRequest struct {
@@ -151,7 +108,8 @@ func (s *Server) handleDestroyEnvironmentStream(w http.ResponseWriter, r *http.R
 		log.Printf("websocket upgrade failed: %v", err)
 		return
 	}
-	defer conn.Close()
+	pump := stream.NewPump(conn, jobStreamPump)
+	defer pump.Close()
 
 	_, message, err := conn.ReadMessage()
 	if err != nil {
@@ -161,44 +119,35 @@ func (s *Server) handleDestroyEnvironmentStream(w http.ResponseWriter, r *http.R
 
 	var req StreamDestroyRequest
 	if err := json.Unmarshal(message, &req); err != nil {
-		stream.SendError(conn, "invalid request format")
+		stream.SendError(pump, "invalid request format")
 		return
 	}
 
-	factory := stream.NewWriterFactory(conn, os.Stdout)
-	destroyStdout, destroyStderr := factory.Destroy()
-	dockerStdout, dockerStderr := factory.Docker()
-	pikoWriter := factory.Piko()
-	pikoLogger := &operations.WriterLogger{Out: pikoWriter, Err: pikoWriter}
-
-	err = operations.DestroyEnvironment(operations.DestroyEnvironmentOptions{
-		DB:            s.db,
-		Project:       project,
-		Environment:   environment,
+	destroy := DestroyJobRequest{
 		RemoveVolumes: req.RemoveVolumes,
 		DeleteBranch:  req.DeleteBranch,
-		Logger:        pikoLogger,
-		Output: &operations.DestroyOutputWriters{
-			DestroyStdout: destroyStdout,
-			DestroyStderr: destroyStderr,
-			DockerStdout:  dockerStdout,
-			DockerStderr:  dockerStderr,
-		},
-	})
-
-	destroyStdout.Flush()
-	destroyStderr.Flush()
-	dockerStdout.Flush()
-	dockerStderr.Flush()
-	pikoWriter.Flush()
-
+	}
+	source := eventSource(r)
+	job, err := s.submitJob(project, "destroy", name, destroy, source, s.destroyJob(project, environment, destroy, source))
 	if err != nil {
-		stream.SendError(conn, err.Error())
+		stream.SendError(pump, err.Error())
 		return
 	}
+	s.streamJob(conn, pump, job.ID)
+}
 
-	s.broadcastStateChange("env_deleted", project.ID, name)
-	stream.SendComplete(conn, nil)
+// streamJob tells the client which job it started and follows its output.
+// If the client goes away before the job finishes, the job is canceled as
+// if by Ctrl-C; clients that only follow a job use /api/ws/jobs/{id}/logs.
+func (s *Server) streamJob(conn *websocket.Conn, pump *stream.Pump, id int64) {
+	if err := stream.SendJob(pump, id); err == nil {
+		if s.followJob(pump, id, 0, readUntilClosed(conn)) {
+			return
+		}
+	}
+	if s.cancelJob(id) == nil {
+		log.Printf("job %d: client disconnected, canceling", id)
+	}
 }
 
 type StreamLogger struct {
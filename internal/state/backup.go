@@ -0,0 +1,62 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// backupsKept is the number of rolling backups kept next to the database.
+const backupsKept = 10
+
+// BackupsDir is where backups of the database are written.
+func (db *DB) BackupsDir() string {
+	return filepath.Join(filepath.Dir(db.path), "backups")
+}
+
+// Backup writes a consistent copy of the database to the backups directory,
+// named after the time and reason, and removes all but the newest backups.
+func (db *DB) Backup(reason string) (string, error) {
+	dir := db.BackupsDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create backups directory: %w", err)
+	}
+
+	name := fmt.Sprintf("state-%s-%s.db", time.Now().UTC().Format("20060102-150405.000"), reason)
+	path := filepath.Join(dir, name)
+	if _, err := db.conn.Exec(`VACUUM INTO ?`, path); err != nil {
+		return "", fmt.Errorf("failed to back up database: %w", err)
+	}
+
+	backups, err := db.ListBackups()
+	if err == nil && len(backups) > backupsKept {
+		for _, old := range backups[backupsKept:] {
+			os.Remove(old)
+		}
+	}
+	return path, nil
+}
+
+// ListBackups returns the paths of existing backups, newest first.
+func (db *DB) ListBackups() ([]string, error) {
+	entries, err := os.ReadDir(db.BackupsDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "state-") && strings.HasSuffix(entry.Name(), ".db") {
+			backups = append(backups, filepath.Join(db.BackupsDir(), entry.Name()))
+		}
+	}
+	slices.Sort(backups)
+	slices.Reverse(backups)
+	return backups, nil
+}
@@ -9,29 +9,6 @@ import (
 	_ "modernc.org/sqlite"
 )
 
-const schema = `
-CREATE TABLE IF NOT EXISTS projects (
-    id INTEGER PRIMARY KEY,
-    name TEXT NOT NULL,
-    root_path TEXT UNIQUE NOT NULL,
-    compose_file TEXT DEFAULT 'docker-compose.yml',
-    compose_dir TEXT DEFAULT '',
-    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-);
-
-CREATE TABLE IF NOT EXISTS environments (
-    id INTEGER PRIMARY KEY,
-    project_id INTEGER REFERENCES projects(id) ON DELETE CASCADE,
-    name TEXT NOT NULL,
-    branch TEXT NOT NULL,
-    path TEXT NOT NULL,
-    docker_project TEXT NOT NULL,
-    tmux_session TEXT,
-    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-    UNIQUE(project_id, name)
-);
-`
-
 type DB struct {
 	conn *sql.DB
 	path string
@@ -60,7 +37,7 @@ func OpenCentral() (*DB, error) {
 }
 
 func Open(path string) (*DB, error) {
-	dsn := path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(ON)"
+	dsn := path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(ON)&_txlock=immediate"
 	conn, err := sql.Open("sqlite", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
@@ -82,10 +59,8 @@ func (db *DB) Close() error {
 	return db.conn.Close()
 }
 
+// Initialize brings the database schema up to date.
 func (db *DB) Initialize() error {
-	_, err := db.conn.Exec(schema)
-	if err != nil {
-		return fmt.Errorf("failed to create schema: %w", err)
-	}
-	return nil
+	_, err := db.Migrate()
+	return err
 }
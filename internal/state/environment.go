@@ -15,13 +15,32 @@ type Environment struct {
 	DockerProject string
 	TmuxSession   sql.NullString
 	CreatedAt     time.Time
+
+	LastActivityAt time.Time
+	HibernatedAt   sql.NullTime
+	ExpiredAt      sql.NullTime
+	HibernateAfter sql.NullInt64
+	ExpireAfter    sql.NullInt64
+	DestroyExpired sql.NullBool
+
+	// Pool is set on spare environments kept for CreateEnvironment to claim.
+	// Pool environments are left out of listings.
+	Pool PoolState
 }
 
+type PoolState string
+
+const (
+	PoolWarming PoolState = "warming"
+	PoolReady   PoolState = "ready"
+	PoolClaimed PoolState = "claimed"
+)
+
 func (db *DB) InsertEnvironment(e *Environment) (int64, error) {
 	result, err := db.conn.Exec(
-		`INSERT INTO environments (project_id, name, branch, path, docker_project, tmux_session)
-		 VALUES (?, ?, ?, ?, ?, ?)`,
-		e.ProjectID, e.Name, e.Branch, e.Path, e.DockerProject, e.TmuxSession,
+		`INSERT INTO environments (project_id, name, branch, path, docker_project, tmux_session, pool, last_activity_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		e.ProjectID, e.Name, e.Branch, e.Path, e.DockerProject, e.TmuxSession, e.Pool,
 	)
 	if err != nil {
 		return 0, fmt.Errorf("failed to insert environment: %w", err)
@@ -53,7 +72,7 @@ func (db *DB) GetEnvironmentByID(id int64) (*Environment, error) {
 
 func (db *DB) ListEnvironmentsByProject(projectID int64) ([]*Environment, error) {
 	rows, err := db.conn.Query(
-		`SELECT `+environmentColumns+` FROM environments WHERE project_id = ? ORDER BY created_at DESC`,
+		`SELECT `+environmentColumns+` FROM environments WHERE project_id = ? AND pool = '' ORDER BY created_at DESC`,
 		projectID,
 	)
 	if err != nil {
@@ -73,9 +92,32 @@ func (db *DB) ListEnvironmentsByProject(projectID int64) ([]*Environment, error)
 	return environments, rows.Err()
 }
 
+// ListPoolEnvironments lists a project's spare environments, oldest first.
+func (db *DB) ListPoolEnvironments(projectID int64) ([]*Environment, error) {
+	rows, err := db.conn.Query(
+		`SELECT `+environmentColumns+` FROM environments WHERE project_id = ? AND pool != '' ORDER BY created_at, id`,
+		projectID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pool environments: %w", err)
+	}
+	defer rows.Close()
+
+	var environments []*Environment
+	for rows.Next() {
+		e, err := scanEnvironment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan environment: %w", err)
+		}
+		environments = append(environments, e)
+	}
+
+	return environments, rows.Err()
+}
+
 func (db *DB) ListAllEnvironments() ([]*Environment, error) {
 	rows, err := db.conn.Query(
-		`SELECT ` + environmentColumns + ` FROM environments ORDER BY created_at DESC`,
+		`SELECT ` + environmentColumns + ` FROM environments WHERE pool = '' ORDER BY created_at DESC`,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list environments: %w", err)
@@ -123,13 +165,15 @@ type EnvironmentWithProject struct {
 }
 
 func (db *DB) FindEnvironmentGlobally(name string) ([]EnvironmentWithProject, error) {
+	// The project's columns are renamed so they don't clash with the
+	// environment's in environmentColumns.
 	rows, err := db.conn.Query(
-		`SELECT e.id, e.project_id, e.name, e.branch, e.path, e.docker_project, e.tmux_session, e.created_at,
-		        p.id, p.name, p.root_path, p.compose_file, COALESCE(p.compose_dir, ''), p.created_at
-		 FROM environments e
-		 JOIN projects p ON e.project_id = p.id
-		 WHERE e.name = ?
-		 ORDER BY e.created_at DESC`,
+		`SELECT `+environmentColumns+`, p_id, p_name, p_root_path, p_compose_file, p_compose_dir, p_created_at
+		 FROM environments
+		 JOIN (SELECT id AS p_id, name AS p_name, root_path AS p_root_path, compose_file AS p_compose_file,
+		              COALESCE(compose_dir, '') AS p_compose_dir, created_at AS p_created_at FROM projects)
+		   ON p_id = project_id
+		 WHERE name = ? AND pool = '' ORDER BY created_at DESC`,
 		name,
 	)
 	if err != nil {
@@ -139,17 +183,87 @@ func (db *DB) FindEnvironmentGlobally(name string) ([]EnvironmentWithProject, er
 
 	var results []EnvironmentWithProject
 	for rows.Next() {
-		var e Environment
 		var p Project
-		err := rows.Scan(
-			&e.ID, &e.ProjectID, &e.Name, &e.Branch, &e.Path, &e.DockerProject, &e.TmuxSession, &e.CreatedAt,
-			&p.ID, &p.Name, &p.RootPath, &p.ComposeFile, &p.ComposeDir, &p.CreatedAt,
-		)
+		e, err := scanEnvironment(rows, &p.ID, &p.Name, &p.RootPath, &p.ComposeFile, &p.ComposeDir, &p.CreatedAt)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan environment: %w", err)
 		}
-		results = append(results, EnvironmentWithProject{Environment: &e, Project: &p})
+		results = append(results, EnvironmentWithProject{Environment: e, Project: &p})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find environment: %w", err)
 	}
 
-	return results, rows.Err()
+	return results, nil
+}
+
+func (db *DB) TouchEnvironment(id int64) error {
+	_, err := db.conn.Exec(
+		`UPDATE environments SET last_activity_at = ?, expired_at = NULL WHERE id = ?`,
+		time.Now().UTC(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update environment activity: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) SetEnvironmentHibernated(id int64, hibernated bool) error {
+	var at any
+	if hibernated {
+		at = time.Now().UTC()
+	}
+	_, err := db.conn.Exec(`UPDATE environments SET hibernated_at = ? WHERE id = ?`, at, id)
+	if err != nil {
+		return fmt.Errorf("failed to update environment: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) SetEnvironmentExpired(id int64) error {
+	_, err := db.conn.Exec(`UPDATE environments SET expired_at = ? WHERE id = ?`, time.Now().UTC(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update environment: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) SetEnvironmentIdlePolicy(id int64, hibernateAfter, expireAfter sql.NullInt64, destroyExpired sql.NullBool) error {
+	_, err := db.conn.Exec(
+		`UPDATE environments SET hibernate_after = ?, expire_after = ?, destroy_expired = ? WHERE id = ?`,
+		hibernateAfter, expireAfter, destroyExpired, id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update idle policy: %w", err)
+	}
+	return nil
+}
+
+// UpdateEnvironment saves the naming fields of an environment (name, branch,
+// path, docker project, tmux session) and its pool state.
+func (db *DB) UpdateEnvironment(e *Environment) error {
+	result, err := db.conn.Exec(
+		`UPDATE environments SET name = ?, branch = ?, path = ?, docker_project = ?, tmux_session = ?, pool = ?
+		 WHERE id = ?`,
+		e.Name, e.Branch, e.Path, e.DockerProject, e.TmuxSession, e.Pool, e.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update environment: %w", err)
+	}
+	return checkRowsAffected(result, "environment not found")
+}
+
+// SetEnvironmentPool moves an environment from one pool state to another and
+// reports whether it was in the expected state, so only one caller can claim
+// a spare.
+func (db *DB) SetEnvironmentPool(id int64, from, to PoolState) (bool, error) {
+	result, err := db.conn.Exec(`UPDATE environments SET pool = ? WHERE id = ? AND pool = ?`, to, id, from)
+	if err != nil {
+		return false, fmt.Errorf("failed to update pool state: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to update pool state: %w", err)
+	}
+	return n > 0, nil
 }
@@ -0,0 +1,126 @@
+package state
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// EventRetention is how long events are kept.
+const EventRetention = 90 * 24 * time.Hour
+
+const (
+	EventOK       = "ok"
+	EventFailed   = "failed"
+	EventCanceled = "canceled"
+)
+
+// Event records an operation performed on an environment (or, with an empty
+// Environment, on the project).
+type Event struct {
+	ID          int64
+	ProjectID   int64
+	ProjectName string
+	Environment string
+	Action      string
+	Source      string
+	Actor       string
+	Status      string
+	Error       string
+	Detail      string
+	StartedAt   time.Time
+	Duration    time.Duration
+}
+
+type EventFilter struct {
+	ProjectID   int64
+	Environment string
+	Action      string
+	Since       time.Time
+	Limit       int
+}
+
+// AddEvent records an event and drops events older than EventRetention.
+func (db *DB) AddEvent(e *Event) error {
+	result, err := db.conn.Exec(
+		`INSERT INTO events (project_id, environment, action, source, actor, status, error, detail, started_at, duration_ms)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ProjectID, e.Environment, e.Action, e.Source, e.Actor, e.Status, e.Error, e.Detail,
+		e.StartedAt.UTC(), e.Duration.Milliseconds(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record event: %w", err)
+	}
+	e.ID, _ = result.LastInsertId()
+
+	_, err = db.conn.Exec(`DELETE FROM events WHERE started_at < ?`, time.Now().UTC().Add(-EventRetention))
+	if err != nil {
+		return fmt.Errorf("failed to prune events: %w", err)
+	}
+	return nil
+}
+
+// ListEvents returns matching events, newest first.
+func (db *DB) ListEvents(filter EventFilter) ([]*Event, error) {
+	var where []string
+	var args []any
+	if filter.ProjectID != 0 {
+		where = append(where, "e.project_id = ?")
+		args = append(args, filter.ProjectID)
+	}
+	if filter.Environment != "" {
+		where = append(where, "e.environment = ?")
+		args = append(args, filter.Environment)
+	}
+	if filter.Action != "" {
+		where = append(where, "e.action = ?")
+		args = append(args, filter.Action)
+	}
+	if !filter.Since.IsZero() {
+		where = append(where, "e.started_at >= ?")
+		args = append(args, filter.Since.UTC())
+	}
+
+	query := `SELECT e.id, e.project_id, p.name, e.environment, e.action, e.source, e.actor, e.status,
+		e.error, e.detail, e.started_at, e.duration_ms
+		FROM events e JOIN projects p ON p.id = e.project_id`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY e.id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*Event
+	for rows.Next() {
+		e := &Event{}
+		var durationMs int64
+		if err := rows.Scan(&e.ID, &e.ProjectID, &e.ProjectName, &e.Environment, &e.Action, &e.Source, &e.Actor,
+			&e.Status, &e.Error, &e.Detail, &e.StartedAt, &durationMs); err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+		e.Duration = time.Duration(durationMs) * time.Millisecond
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// RenameEventsEnvironment moves an environment's events to its new name.
+func (db *DB) RenameEventsEnvironment(projectID int64, oldName, newName string) error {
+	_, err := db.conn.Exec(
+		`UPDATE events SET environment = ? WHERE project_id = ? AND environment = ?`,
+		newName, projectID, oldName,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to rename events: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package state
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ExportVersion is the version of the export document format.
+const ExportVersion = 1
+
+// Export is a portable JSON document of all projects and environments.
+type Export struct {
+	Version       int               `json:"version"`
+	SchemaVersion int               `json:"schema_version"`
+	ExportedAt    time.Time         `json:"exported_at"`
+	Projects      []ExportedProject `json:"projects"`
+}
+
+type ExportedProject struct {
+	Name         string                `json:"name"`
+	RootPath     string                `json:"root_path"`
+	ComposeFile  string                `json:"compose_file"`
+	ComposeDir   string                `json:"compose_dir,omitempty"`
+	CreatedAt    time.Time             `json:"created_at"`
+	Environments []ExportedEnvironment `json:"environments"`
+}
+
+// ExportedEnvironment keeps the environment's ID: docker port allocations
+// are derived from it.
+type ExportedEnvironment struct {
+	ID             int64             `json:"id"`
+	Name           string            `json:"name"`
+	Branch         string            `json:"branch"`
+	Path           string            `json:"path"`
+	DockerProject  string            `json:"docker_project,omitempty"`
+	TmuxSession    string            `json:"tmux_session,omitempty"`
+	CreatedAt      time.Time         `json:"created_at"`
+	LastActivityAt time.Time         `json:"last_activity_at"`
+	HibernateAfter *int64            `json:"hibernate_after,omitempty"`
+	ExpireAfter    *int64            `json:"expire_after,omitempty"`
+	DestroyExpired *bool             `json:"destroy_expired,omitempty"`
+	Ports          map[string]int    `json:"ports,omitempty"`
+	Links          map[string]string `json:"links,omitempty"`
+	Metadata       *Metadata         `json:"metadata,omitempty"`
+}
+
+// Export collects all projects and environments, leaving out pool spares.
+func (db *DB) Export() (*Export, error) {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	projects, err := db.ListProjects()
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &Export{
+		Version:       ExportVersion,
+		SchemaVersion: version,
+		ExportedAt:    time.Now().UTC(),
+		Projects:      []ExportedProject{},
+	}
+	for _, p := range projects {
+		environments, err := db.ListEnvironmentsByProject(p.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		names := make(map[int64]string)
+		for _, e := range environments {
+			names[e.ID] = e.Name
+		}
+
+		exported := ExportedProject{
+			Name:         p.Name,
+			RootPath:     p.RootPath,
+			ComposeFile:  p.ComposeFile,
+			ComposeDir:   p.ComposeDir,
+			CreatedAt:    p.CreatedAt,
+			Environments: []ExportedEnvironment{},
+		}
+		for _, e := range environments {
+			ee := ExportedEnvironment{
+				ID:             e.ID,
+				Name:           e.Name,
+				Branch:         e.Branch,
+				Path:           e.Path,
+				DockerProject:  e.DockerProject,
+				TmuxSession:    e.TmuxSession.String,
+				CreatedAt:      e.CreatedAt,
+				LastActivityAt: e.LastActivityAt,
+			}
+			if e.HibernateAfter.Valid {
+				ee.HibernateAfter = &e.HibernateAfter.Int64
+			}
+			if e.ExpireAfter.Valid {
+				ee.ExpireAfter = &e.ExpireAfter.Int64
+			}
+			if e.DestroyExpired.Valid {
+				ee.DestroyExpired = &e.DestroyExpired.Bool
+			}
+
+			ports, err := db.ListEnvironmentPorts(e.ID)
+			if err != nil {
+				return nil, err
+			}
+			if len(ports) > 0 {
+				ee.Ports = ports
+			}
+
+			links, err := db.ListEnvironmentLinks(e.ID)
+			if err != nil {
+				return nil, err
+			}
+			for _, link := range links {
+				target, ok := names[link.TargetEnvironmentID]
+				if !ok {
+					continue
+				}
+				if ee.Links == nil {
+					ee.Links = make(map[string]string)
+				}
+				ee.Links[link.Service] = target
+			}
+
+			metadata, err := db.GetEnvironmentMetadata(e.ID)
+			if err != nil {
+				return nil, err
+			}
+			if !metadata.IsEmpty() {
+				ee.Metadata = metadata
+			}
+
+			exported.Environments = append(exported.Environments, ee)
+		}
+		doc.Projects = append(doc.Projects, exported)
+	}
+	return doc, nil
+}
+
+type ImportResult struct {
+	Projects     int
+	Environments int
+	// Warnings are things that were skipped or need attention.
+	Warnings []string
+}
+
+// Import registers the projects and environments of an export document in
+// a single transaction, passing every path through remap. Projects whose
+// root is already registered are skipped.
+func (db *DB) Import(doc *Export, remap func(string) string) (*ImportResult, error) {
+	if doc.Version != ExportVersion {
+		return nil, fmt.Errorf("unsupported export version %d (this piko reads version %d)", doc.Version, ExportVersion)
+	}
+	if remap == nil {
+		remap = func(path string) string { return path }
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin import: %w", err)
+	}
+	defer tx.Rollback()
+
+	result := &ImportResult{}
+	warn := func(format string, args ...any) {
+		result.Warnings = append(result.Warnings, fmt.Sprintf(format, args...))
+	}
+
+	for _, p := range doc.Projects {
+		root := remap(p.RootPath)
+
+		var existing int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM projects WHERE root_path = ?`, root).Scan(&existing); err != nil {
+			return nil, fmt.Errorf("failed to check project: %w", err)
+		}
+		if existing > 0 {
+			warn("project %s: already registered at %s, skipped", p.Name, root)
+			continue
+		}
+		if _, err := os.Stat(root); err != nil {
+			warn("project %s: %s does not exist", p.Name, root)
+		}
+
+		res, err := tx.Exec(
+			`INSERT INTO projects (name, root_path, compose_file, compose_dir, created_at) VALUES (?, ?, ?, ?, ?)`,
+			p.Name, root, p.ComposeFile, p.ComposeDir, p.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to import project %s: %w", p.Name, err)
+		}
+		projectID, err := res.LastInsertId()
+		if err != nil {
+			return nil, fmt.Errorf("failed to import project %s: %w", p.Name, err)
+		}
+		result.Projects++
+
+		ids := make(map[string]int64)
+		for _, e := range p.Environments {
+			id, err := importEnvironment(tx, projectID, e, remap(e.Path))
+			if err != nil {
+				return nil, fmt.Errorf("failed to import environment %s/%s: %w", p.Name, e.Name, err)
+			}
+			ids[e.Name] = id
+			result.Environments++
+
+			if e.Metadata != nil {
+				if err := writeMetadata(tx, id, e.Metadata); err != nil {
+					return nil, fmt.Errorf("failed to import environment %s/%s: %w", p.Name, e.Name, err)
+				}
+			}
+
+			if id != e.ID {
+				warn("environment %s/%s: ID %d is taken, docker host ports will change", p.Name, e.Name, e.ID)
+			}
+			if _, err := os.Stat(remap(e.Path)); err != nil {
+				warn("environment %s/%s: worktree %s does not exist", p.Name, e.Name, remap(e.Path))
+			}
+
+			for name, port := range e.Ports {
+				var taken int
+				if err := tx.QueryRow(`SELECT COUNT(*) FROM environment_ports WHERE port = ?`, port).Scan(&taken); err != nil {
+					return nil, fmt.Errorf("failed to check port %d: %w", port, err)
+				}
+				if taken > 0 {
+					warn("environment %s/%s: port %d (%s) is reserved by another environment, a new one will be picked", p.Name, e.Name, port, name)
+					continue
+				}
+				if _, err := tx.Exec(`INSERT INTO environment_ports (environment_id, name, port) VALUES (?, ?, ?)`, id, name, port); err != nil {
+					return nil, fmt.Errorf("failed to import port %s: %w", name, err)
+				}
+			}
+		}
+
+		for _, e := range p.Environments {
+			for service, target := range e.Links {
+				targetID, ok := ids[target]
+				if !ok {
+					warn("environment %s/%s: link target %s not found, link %s dropped", p.Name, e.Name, target, service)
+					continue
+				}
+				if _, err := tx.Exec(
+					`INSERT INTO environment_links (environment_id, service, target_environment_id) VALUES (?, ?, ?)`,
+					ids[e.Name], service, targetID,
+				); err != nil {
+					return nil, fmt.Errorf("failed to import link %s: %w", service, err)
+				}
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit import: %w", err)
+	}
+	return result, nil
+}
+
+// importEnvironment inserts an environment, keeping its ID when it is free.
+func importEnvironment(tx *sql.Tx, projectID int64, e ExportedEnvironment, path string) (int64, error) {
+	var id any
+	var taken int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM environments WHERE id = ?`, e.ID).Scan(&taken); err != nil {
+		return 0, err
+	}
+	if taken == 0 {
+		id = e.ID
+	}
+
+	tmuxSession := sql.NullString{String: e.TmuxSession, Valid: e.TmuxSession != ""}
+	res, err := tx.Exec(
+		`INSERT INTO environments (id, project_id, name, branch, path, docker_project, tmux_session, created_at,
+		 last_activity_at, hibernate_after, expire_after, destroy_expired)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, projectID, e.Name, e.Branch, path, e.DockerProject, tmuxSession, e.CreatedAt,
+		e.LastActivityAt, e.HibernateAfter, e.ExpireAfter, e.DestroyExpired,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
@@ -0,0 +1,223 @@
+package state
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// JobRetention is how long finished jobs and their logs are kept.
+const JobRetention = 7 * 24 * time.Hour
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCanceled  = "canceled"
+)
+
+// Job is an operation the server runs in the background. Request and Result
+// hold JSON documents whose shape depends on Kind.
+type Job struct {
+	ID          int64
+	ProjectID   int64
+	ProjectName string
+	Kind        string
+	Environment string
+	Request     string
+	Source      string
+	Status      string
+	Error       string
+	Result      string
+	CreatedAt   time.Time
+	StartedAt   sql.NullTime
+	FinishedAt  sql.NullTime
+}
+
+// Done reports whether the job has finished, successfully or not.
+func (j *Job) Done() bool {
+	return j.Status != JobQueued && j.Status != JobRunning
+}
+
+// JobLog is one line of a job's output. Seq numbers start at 0 and have no
+// gaps, so a reader can resume from any offset.
+type JobLog struct {
+	Seq    int64
+	Source string
+	Stream string
+	Data   string
+}
+
+type JobFilter struct {
+	ProjectID int64
+	Status    string
+	Limit     int
+}
+
+// InsertJob records a queued job and drops jobs that finished more than
+// JobRetention ago.
+func (db *DB) InsertJob(j *Job) error {
+	j.Status = JobQueued
+	j.CreatedAt = time.Now().UTC()
+	result, err := db.conn.Exec(
+		`INSERT INTO jobs (project_id, kind, environment, request, source, status, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		j.ProjectID, j.Kind, j.Environment, j.Request, j.Source, j.Status, j.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save job: %w", err)
+	}
+	j.ID, _ = result.LastInsertId()
+
+	_, err = db.conn.Exec(`DELETE FROM jobs WHERE finished_at < ?`, time.Now().UTC().Add(-JobRetention))
+	if err != nil {
+		return fmt.Errorf("failed to prune jobs: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) StartJob(id int64) error {
+	_, err := db.conn.Exec(
+		`UPDATE jobs SET status = ?, started_at = ? WHERE id = ? AND status = ?`,
+		JobRunning, time.Now().UTC(), id, JobQueued,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to start job: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) FinishJob(id int64, status, errMsg, result string) error {
+	_, err := db.conn.Exec(
+		`UPDATE jobs SET status = ?, error = ?, result = ?, finished_at = ? WHERE id = ?`,
+		status, errMsg, result, time.Now().UTC(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to finish job: %w", err)
+	}
+	return nil
+}
+
+// FailInterruptedJobs marks jobs that were queued or running when the server
+// stopped as failed. It returns how many were marked.
+func (db *DB) FailInterruptedJobs() (int64, error) {
+	result, err := db.conn.Exec(
+		`UPDATE jobs SET status = ?, error = ?, finished_at = ? WHERE status IN (?, ?)`,
+		JobFailed, "interrupted: the server stopped while the job was running", time.Now().UTC(),
+		JobQueued, JobRunning,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update interrupted jobs: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+const jobColumns = `j.id, j.project_id, p.name, j.kind, j.environment, j.request, j.source, j.status,
+	j.error, j.result, j.created_at, j.started_at, j.finished_at`
+
+func (db *DB) GetJob(id int64) (*Job, error) {
+	row := db.conn.QueryRow(
+		`SELECT `+jobColumns+` FROM jobs j JOIN projects p ON p.id = j.project_id WHERE j.id = ?`, id,
+	)
+	j, err := scanJob(row)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("job %d not found", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job: %w", err)
+	}
+	return j, nil
+}
+
+// ListJobs returns matching jobs, newest first.
+func (db *DB) ListJobs(filter JobFilter) ([]*Job, error) {
+	var where []string
+	var args []any
+	if filter.ProjectID != 0 {
+		where = append(where, "j.project_id = ?")
+		args = append(args, filter.ProjectID)
+	}
+	if filter.Status != "" {
+		where = append(where, "j.status = ?")
+		args = append(args, filter.Status)
+	}
+
+	query := `SELECT ` + jobColumns + ` FROM jobs j JOIN projects p ON p.id = j.project_id`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY j.id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []*Job
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan job: %w", err)
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+func scanJob(row interface{ Scan(...any) error }) (*Job, error) {
+	j := &Job{}
+	err := row.Scan(&j.ID, &j.ProjectID, &j.ProjectName, &j.Kind, &j.Environment, &j.Request, &j.Source,
+		&j.Status, &j.Error, &j.Result, &j.CreatedAt, &j.StartedAt, &j.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (db *DB) AppendJobLog(jobID int64, l *JobLog) error {
+	_, err := db.conn.Exec(
+		`INSERT INTO job_logs (job_id, seq, source, stream, data) VALUES (?, ?, ?, ?, ?)`,
+		jobID, l.Seq, l.Source, l.Stream, l.Data,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save job log: %w", err)
+	}
+	return nil
+}
+
+// JobLogs returns up to limit lines of a job's output starting at offset.
+func (db *DB) JobLogs(jobID, offset int64, limit int) ([]*JobLog, error) {
+	rows, err := db.conn.Query(
+		`SELECT seq, source, stream, data FROM job_logs WHERE job_id = ? AND seq >= ? ORDER BY seq LIMIT ?`,
+		jobID, offset, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read job logs: %w", err)
+	}
+	defer rows.Close()
+
+	var logs []*JobLog
+	for rows.Next() {
+		l := &JobLog{}
+		if err := rows.Scan(&l.Seq, &l.Source, &l.Stream, &l.Data); err != nil {
+			return nil, fmt.Errorf("failed to scan job log: %w", err)
+		}
+		logs = append(logs, l)
+	}
+	return logs, rows.Err()
+}
+
+func (db *DB) CountJobLogs(jobID int64) (int64, error) {
+	var count int64
+	if err := db.conn.QueryRow(`SELECT COUNT(*) FROM job_logs WHERE job_id = ?`, jobID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count job logs: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,72 @@
+package state
+
+import (
+	"fmt"
+	"time"
+)
+
+// EnvironmentLink points a service of one environment at the same service in
+// another environment of the project.
+type EnvironmentLink struct {
+	ID                  int64
+	EnvironmentID       int64
+	Service             string
+	TargetEnvironmentID int64
+	CreatedAt           time.Time
+}
+
+func (db *DB) SetEnvironmentLink(environmentID int64, service string, targetEnvironmentID int64) error {
+	_, err := db.conn.Exec(
+		`INSERT INTO environment_links (environment_id, service, target_environment_id)
+		 VALUES (?, ?, ?)
+		 ON CONFLICT(environment_id, service) DO UPDATE SET target_environment_id = excluded.target_environment_id`,
+		environmentID, service, targetEnvironmentID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save link: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) DeleteEnvironmentLink(environmentID int64, service string) (bool, error) {
+	result, err := db.conn.Exec(
+		`DELETE FROM environment_links WHERE environment_id = ? AND service = ?`,
+		environmentID, service,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete link: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// ListEnvironmentLinks returns the links of an environment.
+func (db *DB) ListEnvironmentLinks(environmentID int64) ([]*EnvironmentLink, error) {
+	return db.listLinks(`WHERE environment_id = ? ORDER BY service`, environmentID)
+}
+
+// ListLinksToEnvironment returns the links other environments have onto this one.
+func (db *DB) ListLinksToEnvironment(targetEnvironmentID int64) ([]*EnvironmentLink, error) {
+	return db.listLinks(`WHERE target_environment_id = ? ORDER BY environment_id, service`, targetEnvironmentID)
+}
+
+func (db *DB) listLinks(where string, args ...any) ([]*EnvironmentLink, error) {
+	rows, err := db.conn.Query(
+		`SELECT id, environment_id, service, target_environment_id, created_at FROM environment_links `+where,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list links: %w", err)
+	}
+	defer rows.Close()
+
+	var links []*EnvironmentLink
+	for rows.Next() {
+		l := &EnvironmentLink{}
+		if err := rows.Scan(&l.ID, &l.EnvironmentID, &l.Service, &l.TargetEnvironmentID, &l.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan link: %w", err)
+		}
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
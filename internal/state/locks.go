@@ -0,0 +1,160 @@
+package state
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Lock is an advisory lock on an environment, or on a whole project when
+// Environment is empty. A project lock conflicts with the locks of all of
+// its environments.
+type Lock struct {
+	ProjectID   int64
+	Environment string
+	Holder      string
+	Action      string
+	PID         int
+	Hostname    string
+	Command     string
+	AcquiredAt  time.Time
+	ExpiresAt   time.Time
+}
+
+func (l *Lock) scope() string {
+	if l.Environment == "" {
+		return fmt.Sprintf("%d", l.ProjectID)
+	}
+	return fmt.Sprintf("%d/%s", l.ProjectID, l.Environment)
+}
+
+// Stale reports whether the lock's holder is gone: the lock expired without
+// being refreshed, or its process on this host exited.
+func (l *Lock) Stale() bool {
+	if time.Now().After(l.ExpiresAt) {
+		return true
+	}
+	hostname, _ := os.Hostname()
+	return l.Hostname == hostname && !processAlive(l.PID)
+}
+
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+const lockColumns = `project_id, environment, holder, action, pid, hostname, command, acquired_at, expires_at`
+
+// TryLock takes l unless a live lock conflicts with it, in which case the
+// conflicting lock is returned. Stale conflicting locks are removed.
+func (db *DB) TryLock(l *Lock) (*Lock, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		`SELECT `+lockColumns+` FROM locks
+		 WHERE project_id = ? AND (environment = ? OR environment = '' OR ? = '')`,
+		l.ProjectID, l.Environment, l.Environment,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check locks: %w", err)
+	}
+	conflicts, err := scanLocks(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, conflict := range conflicts {
+		if !conflict.Stale() {
+			return conflict, nil
+		}
+		if _, err := tx.Exec(`DELETE FROM locks WHERE scope = ? AND holder = ?`, conflict.scope(), conflict.Holder); err != nil {
+			return nil, fmt.Errorf("failed to remove stale lock: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO locks (scope, `+lockColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		l.scope(), l.ProjectID, l.Environment, l.Holder, l.Action, l.PID, l.Hostname, l.Command,
+		l.AcquiredAt.UTC(), l.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to take lock: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit lock: %w", err)
+	}
+	return nil, nil
+}
+
+// RefreshLock extends a held lock.
+func (db *DB) RefreshLock(l *Lock) error {
+	_, err := db.conn.Exec(
+		`UPDATE locks SET expires_at = ? WHERE scope = ? AND holder = ?`,
+		l.ExpiresAt.UTC(), l.scope(), l.Holder,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to refresh lock: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) Unlock(l *Lock) error {
+	_, err := db.conn.Exec(`DELETE FROM locks WHERE scope = ? AND holder = ?`, l.scope(), l.Holder)
+	if err != nil {
+		return fmt.Errorf("failed to release lock: %w", err)
+	}
+	return nil
+}
+
+// ListLocks returns the locks held on a project and its environments, or on
+// all projects when projectID is 0.
+func (db *DB) ListLocks(projectID int64) ([]*Lock, error) {
+	rows, err := db.conn.Query(
+		`SELECT `+lockColumns+` FROM locks WHERE ? = 0 OR project_id = ? ORDER BY acquired_at`,
+		projectID, projectID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list locks: %w", err)
+	}
+	return scanLocks(rows)
+}
+
+func scanLocks(rows *sql.Rows) ([]*Lock, error) {
+	defer rows.Close()
+	var locks []*Lock
+	for rows.Next() {
+		l := &Lock{}
+		if err := rows.Scan(&l.ProjectID, &l.Environment, &l.Holder, &l.Action, &l.PID, &l.Hostname, &l.Command,
+			&l.AcquiredAt, &l.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan lock: %w", err)
+		}
+		locks = append(locks, l)
+	}
+	return locks, rows.Err()
+}
+
+// RemoveStaleLocks removes locks whose holders are gone.
+func (db *DB) RemoveStaleLocks() (int, error) {
+	locks, err := db.ListLocks(0)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, l := range locks {
+		if !l.Stale() {
+			continue
+		}
+		if err := db.Unlock(l); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
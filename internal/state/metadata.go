@@ -0,0 +1,123 @@
+package state
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+)
+
+// Metadata describes what an environment is for.
+type Metadata struct {
+	Description string            `json:"description,omitempty"`
+	Issue       string            `json:"issue,omitempty"`
+	Owner       string            `json:"owner,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// Matches reports whether the metadata has all of the tags and labels.
+func (m *Metadata) Matches(tags []string, labels map[string]string) bool {
+	for _, tag := range tags {
+		if !slices.Contains(m.Tags, tag) {
+			return false
+		}
+	}
+	for key, value := range labels {
+		if v, ok := m.Labels[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Metadata) IsEmpty() bool {
+	return m.Description == "" && m.Issue == "" && m.Owner == "" && len(m.Tags) == 0 && len(m.Labels) == 0
+}
+
+func (db *DB) GetEnvironmentMetadata(environmentID int64) (*Metadata, error) {
+	m := &Metadata{}
+	err := db.conn.QueryRow(
+		`SELECT description, issue, owner FROM environments WHERE id = ?`,
+		environmentID,
+	).Scan(&m.Description, &m.Issue, &m.Owner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get environment metadata: %w", err)
+	}
+
+	rows, err := db.conn.Query(`SELECT tag FROM environment_tags WHERE environment_id = ? ORDER BY tag`, environmentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan tag: %w", err)
+		}
+		m.Tags = append(m.Tags, tag)
+	}
+	rows.Close()
+
+	rows, err = db.conn.Query(`SELECT key, value FROM environment_labels WHERE environment_id = ?`, environmentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list labels: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, fmt.Errorf("failed to scan label: %w", err)
+		}
+		if m.Labels == nil {
+			m.Labels = make(map[string]string)
+		}
+		m.Labels[key] = value
+	}
+	return m, rows.Err()
+}
+
+// SetEnvironmentMetadata replaces an environment's metadata.
+func (db *DB) SetEnvironmentMetadata(environmentID int64, m *Metadata) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to save metadata: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := writeMetadata(tx, environmentID, m); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func writeMetadata(tx *sql.Tx, environmentID int64, m *Metadata) error {
+	result, err := tx.Exec(
+		`UPDATE environments SET description = ?, issue = ?, owner = ? WHERE id = ?`,
+		m.Description, m.Issue, m.Owner, environmentID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save metadata: %w", err)
+	}
+	if err := checkRowsAffected(result, "environment not found"); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM environment_tags WHERE environment_id = ?`, environmentID); err != nil {
+		return fmt.Errorf("failed to save tags: %w", err)
+	}
+	for _, tag := range m.Tags {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO environment_tags (environment_id, tag) VALUES (?, ?)`, environmentID, tag); err != nil {
+			return fmt.Errorf("failed to save tag %s: %w", tag, err)
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM environment_labels WHERE environment_id = ?`, environmentID); err != nil {
+		return fmt.Errorf("failed to save labels: %w", err)
+	}
+	for key, value := range m.Labels {
+		if _, err := tx.Exec(`INSERT INTO environment_labels (environment_id, key, value) VALUES (?, ?, ?)`, environmentID, key, value); err != nil {
+			return fmt.Errorf("failed to save label %s: %w", key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,342 @@
+package state
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Migration is a numbered schema change. Migrations are applied in order,
+// each in its own transaction, and recorded in schema_version. New schema
+// changes are appended with the next version; applied ones are never edited.
+type Migration struct {
+	Version     int
+	Description string
+	up          func(tx *sql.Tx) error
+}
+
+var migrations = []Migration{
+	{1, "initial schema", execSQL(`
+		CREATE TABLE IF NOT EXISTS projects (
+		    id INTEGER PRIMARY KEY,
+		    name TEXT NOT NULL,
+		    root_path TEXT UNIQUE NOT NULL,
+		    compose_file TEXT DEFAULT 'docker-compose.yml',
+		    compose_dir TEXT DEFAULT '',
+		    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS environments (
+		    id INTEGER PRIMARY KEY,
+		    project_id INTEGER REFERENCES projects(id) ON DELETE CASCADE,
+		    name TEXT NOT NULL,
+		    branch TEXT NOT NULL,
+		    path TEXT NOT NULL,
+		    docker_project TEXT NOT NULL,
+		    tmux_session TEXT,
+		    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		    UNIQUE(project_id, name)
+		);
+	`)},
+	{2, "idle tracking", func(tx *sql.Tx) error {
+		err := addColumns(
+			column{"environments", "last_activity_at", "DATETIME"},
+			column{"environments", "hibernated_at", "DATETIME"},
+			column{"environments", "expired_at", "DATETIME"},
+			column{"environments", "hibernate_after", "INTEGER"},
+			column{"environments", "expire_after", "INTEGER"},
+			column{"environments", "destroy_expired", "INTEGER"},
+		)(tx)
+		if err != nil {
+			return err
+		}
+		// Existing environments count as active from the upgrade, not idle
+		// since they were created.
+		return execSQL(`UPDATE environments SET last_activity_at = CURRENT_TIMESTAMP WHERE last_activity_at IS NULL`)(tx)
+	}},
+	{3, "environment links", execSQL(`
+		CREATE TABLE IF NOT EXISTS environment_links (
+		    id INTEGER PRIMARY KEY,
+		    environment_id INTEGER NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+		    service TEXT NOT NULL,
+		    target_environment_id INTEGER NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+		    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		    UNIQUE(environment_id, service)
+		);
+	`)},
+	{4, "reserved ports", execSQL(`
+		CREATE TABLE IF NOT EXISTS environment_ports (
+		    id INTEGER PRIMARY KEY,
+		    environment_id INTEGER NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+		    name TEXT NOT NULL,
+		    port INTEGER NOT NULL UNIQUE,
+		    UNIQUE(environment_id, name)
+		);
+	`)},
+	{5, "environment pool", addColumns(
+		column{"environments", "pool", "TEXT NOT NULL DEFAULT ''"},
+	)},
+	{6, "environment metadata", func(tx *sql.Tx) error {
+		err := addColumns(
+			column{"environments", "description", "TEXT NOT NULL DEFAULT ''"},
+			column{"environments", "issue", "TEXT NOT NULL DEFAULT ''"},
+			column{"environments", "owner", "TEXT NOT NULL DEFAULT ''"},
+		)(tx)
+		if err != nil {
+			return err
+		}
+		return execSQL(`
+			CREATE TABLE environment_tags (
+			    environment_id INTEGER NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+			    tag TEXT NOT NULL,
+			    PRIMARY KEY(environment_id, tag)
+			);
+
+			CREATE TABLE environment_labels (
+			    environment_id INTEGER NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+			    key TEXT NOT NULL,
+			    value TEXT NOT NULL,
+			    PRIMARY KEY(environment_id, key)
+			);
+		`)(tx)
+	}},
+	{7, "events", execSQL(`
+		CREATE TABLE events (
+		    id INTEGER PRIMARY KEY AUTOINCREMENT,
+		    project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+		    environment TEXT NOT NULL DEFAULT '',
+		    action TEXT NOT NULL,
+		    source TEXT NOT NULL,
+		    actor TEXT NOT NULL DEFAULT '',
+		    status TEXT NOT NULL,
+		    error TEXT NOT NULL DEFAULT '',
+		    detail TEXT NOT NULL DEFAULT '',
+		    started_at DATETIME NOT NULL,
+		    duration_ms INTEGER NOT NULL DEFAULT 0
+		);
+
+		CREATE INDEX events_project ON events(project_id, environment, id);
+		CREATE INDEX events_started_at ON events(started_at);
+	`)},
+	{8, "locks", execSQL(`
+		CREATE TABLE locks (
+		    scope TEXT PRIMARY KEY,
+		    project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+		    environment TEXT NOT NULL DEFAULT '',
+		    holder TEXT NOT NULL,
+		    action TEXT NOT NULL,
+		    pid INTEGER NOT NULL,
+		    hostname TEXT NOT NULL,
+		    command TEXT NOT NULL,
+		    acquired_at DATETIME NOT NULL,
+		    expires_at DATETIME NOT NULL
+		);
+	`)},
+	{9, "jobs", execSQL(`
+		CREATE TABLE jobs (
+		    id INTEGER PRIMARY KEY AUTOINCREMENT,
+		    project_id INTEGER NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+		    kind TEXT NOT NULL,
+		    environment TEXT NOT NULL,
+		    request TEXT NOT NULL DEFAULT '',
+		    source TEXT NOT NULL DEFAULT '',
+		    status TEXT NOT NULL,
+		    error TEXT NOT NULL DEFAULT '',
+		    result TEXT NOT NULL DEFAULT '',
+		    created_at DATETIME NOT NULL,
+		    started_at DATETIME,
+		    finished_at DATETIME
+		);
+
+		CREATE TABLE job_logs (
+		    job_id INTEGER NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+		    seq INTEGER NOT NULL,
+		    source TEXT NOT NULL,
+		    stream TEXT NOT NULL,
+		    data TEXT NOT NULL,
+		    PRIMARY KEY(job_id, seq)
+		);
+
+		CREATE INDEX jobs_created_at ON jobs(created_at);
+	`)},
+}
+
+const schemaVersionTable = `
+CREATE TABLE IF NOT EXISTS schema_version (
+    version INTEGER PRIMARY KEY,
+    description TEXT NOT NULL,
+    applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+`
+
+// LatestSchemaVersion is the schema version this binary migrates to.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+type MigrationStatus struct {
+	Migration
+	AppliedAt sql.NullTime
+}
+
+// SchemaVersion returns the version of the database schema, 0 for a database
+// that has never been migrated.
+func (db *DB) SchemaVersion() (int, error) {
+	if _, err := db.conn.Exec(schemaVersionTable); err != nil {
+		return 0, fmt.Errorf("failed to create schema_version: %w", err)
+	}
+	var version sql.NullInt64
+	if err := db.conn.QueryRow(`SELECT MAX(version) FROM schema_version`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+// MigrationStatuses lists all known migrations and when they were applied.
+func (db *DB) MigrationStatuses() ([]MigrationStatus, error) {
+	if _, err := db.SchemaVersion(); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.conn.Query(`SELECT version, applied_at FROM schema_version`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_version: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, fmt.Errorf("failed to read schema_version: %w", err)
+		}
+		applied[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{Migration: m}
+		if at, ok := applied[m.Version]; ok {
+			status.AppliedAt = sql.NullTime{Time: at, Valid: true}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Migrate applies pending migrations and returns them, backing up the
+// database first. It refuses to touch a database migrated by a newer piko.
+func (db *DB) Migrate() ([]Migration, error) {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if latest := LatestSchemaVersion(); version > latest {
+		return nil, fmt.Errorf("state database %s has schema version %d, newer than this piko supports (%d); upgrade piko", db.path, version, latest)
+	}
+
+	if version < LatestSchemaVersion() {
+		// Databases from before versioned migrations are at version 0 but
+		// hold data too.
+		var tables int
+		err := db.conn.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'projects'`).Scan(&tables)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect database: %w", err)
+		}
+		if tables > 0 {
+			if _, err := db.Backup("migrate"); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var applied []Migration
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+		ok, err := db.applyMigration(m)
+		if err != nil {
+			return applied, err
+		}
+		if ok {
+			applied = append(applied, m)
+		}
+	}
+	return applied, nil
+}
+
+// applyMigration runs a migration in a transaction. It reports false when
+// another process applied it first.
+func (db *DB) applyMigration(m Migration) (bool, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin migration %d: %w", m.Version, err)
+	}
+	defer tx.Rollback()
+
+	var done int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM schema_version WHERE version = ?`, m.Version).Scan(&done); err != nil {
+		return false, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if done > 0 {
+		return false, nil
+	}
+
+	if err := m.up(tx); err != nil {
+		return false, fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Description, err)
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_version (version, description) VALUES (?, ?)`, m.Version, m.Description); err != nil {
+		return false, fmt.Errorf("failed to record migration %d: %w", m.Version, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit migration %d: %w", m.Version, err)
+	}
+	return true, nil
+}
+
+func execSQL(statements string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(statements)
+		return err
+	}
+}
+
+type column struct {
+	table      string
+	name       string
+	definition string
+}
+
+// addColumns adds columns that are missing. Databases created before
+// versioned migrations may already have some of them.
+func addColumns(columns ...column) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, c := range columns {
+			exists, err := columnExists(tx, c.table, c.name)
+			if err != nil {
+				return err
+			}
+			if exists {
+				continue
+			}
+			if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.name, c.definition)); err != nil {
+				return fmt.Errorf("failed to add column %s.%s: %w", c.table, c.name, err)
+			}
+		}
+		return nil
+	}
+}
+
+func columnExists(tx *sql.Tx, table, name string) (bool, error) {
+	var count int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, name).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect %s: %w", table, err)
+	}
+	return count > 0, nil
+}
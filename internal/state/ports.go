@@ -0,0 +1,56 @@
+package state
+
+import "fmt"
+
+// ListEnvironmentPorts returns the named ports reserved for an environment.
+func (db *DB) ListEnvironmentPorts(environmentID int64) (map[string]int, error) {
+	rows, err := db.conn.Query(
+		`SELECT name, port FROM environment_ports WHERE environment_id = ?`,
+		environmentID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ports: %w", err)
+	}
+	defer rows.Close()
+
+	result := make(map[string]int)
+	for rows.Next() {
+		var name string
+		var port int
+		if err := rows.Scan(&name, &port); err != nil {
+			return nil, fmt.Errorf("failed to scan port: %w", err)
+		}
+		result[name] = port
+	}
+	return result, rows.Err()
+}
+
+// ReservedPorts returns every port reserved by any environment.
+func (db *DB) ReservedPorts() (map[int]bool, error) {
+	rows, err := db.conn.Query(`SELECT port FROM environment_ports`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ports: %w", err)
+	}
+	defer rows.Close()
+
+	result := make(map[int]bool)
+	for rows.Next() {
+		var port int
+		if err := rows.Scan(&port); err != nil {
+			return nil, fmt.Errorf("failed to scan port: %w", err)
+		}
+		result[port] = true
+	}
+	return result, rows.Err()
+}
+
+func (db *DB) InsertEnvironmentPort(environmentID int64, name string, port int) error {
+	_, err := db.conn.Exec(
+		`INSERT INTO environment_ports (environment_id, name, port) VALUES (?, ?, ?)`,
+		environmentID, name, port,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to reserve port %d for %s: %w", port, name, err)
+	}
+	return nil
+}
@@ -71,6 +71,13 @@ func (db *DB) FindProjectByPath(path string) (*Project, error) {
 			return project, nil
 		}
 
+		// Worktrees can live outside the project root.
+		var projectID int64
+		err = db.conn.QueryRow(`SELECT project_id FROM environments WHERE path = ?`, cleanPath).Scan(&projectID)
+		if err == nil {
+			return db.GetProjectByID(projectID)
+		}
+
 		parent := filepath.Dir(cleanPath)
 		if parent == cleanPath {
 			break
@@ -81,6 +88,29 @@ func (db *DB) FindProjectByPath(path string) (*Project, error) {
 	return nil, fmt.Errorf("not in a piko project (run 'piko init' first)")
 }
 
+// RelocateProject changes a project's root path and the paths of its
+// environments under the old root.
+func (db *DB) RelocateProject(projectID int64, oldRoot, newRoot string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to relocate project: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE projects SET root_path = ? WHERE id = ?`, newRoot, projectID); err != nil {
+		return fmt.Errorf("failed to relocate project: %w", err)
+	}
+	_, err = tx.Exec(
+		`UPDATE environments SET path = ? || substr(path, length(?) + 1)
+		 WHERE project_id = ? AND (path = ? OR substr(path, 1, length(?) + 1) = ? || '/')`,
+		newRoot, oldRoot, projectID, oldRoot, oldRoot, oldRoot,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to relocate environments: %w", err)
+	}
+	return tx.Commit()
+}
+
 func (db *DB) ListProjects() ([]*Project, error) {
 	rows, err := db.conn.Query(
 		`SELECT ` + projectColumns + ` FROM projects ORDER BY name ASC`,
@@ -7,7 +7,8 @@ import (
 )
 
 const projectColumns = "id, name, root_path, compose_file, COALESCE(compose_dir, ''), created_at"
-const environmentColumns = "id, project_id, name, branch, path, docker_project, tmux_session, created_at"
+const environmentColumns = "id, project_id, name, branch, path, docker_project, tmux_session, created_at, " +
+	"last_activity_at, hibernated_at, expired_at, hibernate_after, expire_after, destroy_expired, pool"
 
 type Scanner interface {
 	Scan(dest ...any) error
@@ -22,9 +23,16 @@ func scanProject(s Scanner) (*Project, error) {
 	return &p, nil
 }
 
-func scanEnvironment(s Scanner) (*Environment, error) {
+// scanEnvironment scans environmentColumns, followed by any extra columns
+// the query selects into extra.
+func scanEnvironment(s Scanner, extra ...any) (*Environment, error) {
 	var e Environment
-	err := s.Scan(&e.ID, &e.ProjectID, &e.Name, &e.Branch, &e.Path, &e.DockerProject, &e.TmuxSession, &e.CreatedAt)
+	dest := []any{
+		&e.ID, &e.ProjectID, &e.Name, &e.Branch, &e.Path, &e.DockerProject, &e.TmuxSession, &e.CreatedAt,
+		&e.LastActivityAt, &e.HibernatedAt, &e.ExpiredAt, &e.HibernateAfter, &e.ExpireAfter, &e.DestroyExpired,
+		&e.Pool,
+	}
+	err := s.Scan(append(dest, extra...)...)
 	if err != nil {
 		return nil, err
 	}
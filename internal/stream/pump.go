@@ -0,0 +1,275 @@
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	ErrPumpClosed = errors.New("connection closed")
+	ErrSlowClient = errors.New("client is not keeping up")
+)
+
+// Policy decides what Send does when a connection's queue is full.
+type Policy int
+
+const (
+	// Block makes Send wait for room. A client that takes longer than the
+	// write timeout to make room is disconnected.
+	Block Policy = iota
+	// DropOldest discards the oldest queued message to make room. Sequenced
+	// messages let the client notice the gap.
+	DropOldest
+	// Disconnect closes the connection.
+	Disconnect
+)
+
+// Sequenced messages are numbered per connection as they are queued, so a
+// client can tell that messages were dropped.
+type Sequenced interface {
+	WithSeq(seq int64) any
+}
+
+type PumpOptions struct {
+	// QueueSize is how many messages may wait to be written; it defaults to
+	// 256.
+	QueueSize int
+	Policy    Policy
+
+	// WriteTimeout bounds each write, and how long Send blocks under the
+	// Block policy. It defaults to 10s.
+	WriteTimeout time.Duration
+
+	// PingInterval, if set, keeps an idle connection alive with pings.
+	PingInterval time.Duration
+
+	// BatchBytes is how much log output from one source may be merged into
+	// a single frame; it defaults to 32 KiB.
+	BatchBytes int
+}
+
+// Pump owns the writing side of a websocket connection. gorilla/websocket
+// allows one writer at a time, so everything sent to the client, from any
+// goroutine, is queued here and written by the pump's own goroutine.
+// Consecutive log lines from the same source and stream that are waiting in
+// the queue are batched into one frame; the batch carries the Seq of its
+// last line.
+type Pump struct {
+	conn *websocket.Conn
+	opts PumpOptions
+
+	mu      sync.Mutex
+	queue   []any
+	seq     int64
+	dropped int64
+	closing bool
+	err     error
+
+	wake chan struct{}
+	room chan struct{}
+	done chan struct{}
+}
+
+func NewPump(conn *websocket.Conn, opts PumpOptions) *Pump {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 256
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = 10 * time.Second
+	}
+	if opts.BatchBytes <= 0 {
+		opts.BatchBytes = 32 * 1024
+	}
+	p := &Pump{
+		conn: conn,
+		opts: opts,
+		wake: make(chan struct{}, 1),
+		room: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Send queues msg, which is written as JSON. When the queue is full it
+// applies the pump's policy. It returns an error once the connection has
+// failed or the pump is closed.
+func (p *Pump) Send(msg any) error {
+	p.mu.Lock()
+	for len(p.queue) >= p.opts.QueueSize && p.err == nil && !p.closing {
+		switch p.opts.Policy {
+		case DropOldest:
+			p.queue[0] = nil
+			p.queue = p.queue[1:]
+			p.dropped++
+		case Disconnect:
+			p.fail(ErrSlowClient)
+		default:
+			room := p.room
+			p.mu.Unlock()
+			timer := time.NewTimer(p.opts.WriteTimeout)
+			select {
+			case <-room:
+			case <-p.done:
+			case <-timer.C:
+				p.mu.Lock()
+				p.fail(ErrSlowClient)
+				p.mu.Unlock()
+			}
+			timer.Stop()
+			p.mu.Lock()
+		}
+	}
+	if p.err != nil {
+		err := p.err
+		p.mu.Unlock()
+		return err
+	}
+	if p.closing {
+		p.mu.Unlock()
+		return ErrPumpClosed
+	}
+
+	if s, ok := msg.(Sequenced); ok {
+		p.seq++
+		msg = s.WithSeq(p.seq)
+	}
+	p.queue = append(p.queue, msg)
+	p.mu.Unlock()
+
+	p.signal()
+	return nil
+}
+
+// Done is closed once the pump has stopped writing, because it was closed
+// or the connection failed.
+func (p *Pump) Done() <-chan struct{} {
+	return p.done
+}
+
+// Dropped returns how many messages the DropOldest policy discarded.
+func (p *Pump) Dropped() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.dropped
+}
+
+// Close writes what is still queued, closes the connection and waits for
+// the pump to stop. It returns the error that broke the connection, if any.
+func (p *Pump) Close() error {
+	p.mu.Lock()
+	p.closing = true
+	p.mu.Unlock()
+	p.signal()
+
+	<-p.done
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+func (p *Pump) signal() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// fail records err and closes the connection, which also unblocks a write
+// in progress. It must be called with mu held.
+func (p *Pump) fail(err error) {
+	if p.err != nil {
+		return
+	}
+	p.err = err
+	p.conn.Close()
+	p.signal()
+}
+
+func (p *Pump) run() {
+	defer close(p.done)
+	defer p.conn.Close()
+
+	var ping <-chan time.Time
+	if p.opts.PingInterval > 0 {
+		ticker := time.NewTicker(p.opts.PingInterval)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+
+	for {
+		p.mu.Lock()
+		batch := p.queue
+		p.queue = nil
+		if len(batch) > 0 {
+			close(p.room)
+			p.room = make(chan struct{})
+		}
+		closing, failed := p.closing, p.err != nil
+		p.mu.Unlock()
+
+		if failed {
+			return
+		}
+		if len(batch) == 0 {
+			if closing {
+				p.conn.SetWriteDeadline(time.Now().Add(p.opts.WriteTimeout))
+				p.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			select {
+			case <-p.wake:
+			case <-ping:
+				p.conn.SetWriteDeadline(time.Now().Add(p.opts.WriteTimeout))
+				if err := p.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+					p.mu.Lock()
+					p.fail(err)
+					p.mu.Unlock()
+				}
+			}
+			continue
+		}
+
+		for _, msg := range p.merge(batch) {
+			if err := p.write(msg); err != nil {
+				p.mu.Lock()
+				p.fail(err)
+				p.mu.Unlock()
+				return
+			}
+		}
+	}
+}
+
+// merge batches consecutive log lines from the same source and stream.
+func (p *Pump) merge(batch []any) []any {
+	merged := batch[:0]
+	for _, msg := range batch {
+		line, ok := msg.(LogMessage)
+		if ok && len(merged) > 0 {
+			if last, ok := merged[len(merged)-1].(LogMessage); ok &&
+				last.Source == line.Source && last.Stream == line.Stream &&
+				len(last.Data)+len(line.Data) <= p.opts.BatchBytes {
+				last.Data += line.Data
+				last.Seq = line.Seq
+				merged[len(merged)-1] = last
+				continue
+			}
+		}
+		merged = append(merged, msg)
+	}
+	return merged
+}
+
+func (p *Pump) write(msg any) error {
+	encoded, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	p.conn.SetWriteDeadline(time.Now().Add(p.opts.WriteTimeout))
+	return p.conn.WriteMessage(websocket.TextMessage, encoded)
+}
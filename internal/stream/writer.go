@@ -2,16 +2,13 @@ package stream
 
 import (
 	"bytes"
-	"encoding/json"
 	"io"
 	"sync"
-	"time"
-
-	"github.com/gorilla/websocket"
 )
 
 type LogMessage struct {
 	Type   string `json:"type"`
+	Seq    int64  `json:"seq,omitempty"`
 	Source string `json:"source"`
 	Stream string `json:"stream"`
 	Data   string `json:"data"`
@@ -24,6 +21,13 @@ type CompleteMessage struct {
 	Error       string       `json:"error,omitempty"`
 }
 
+// JobMessage tells a client which job is doing the work it asked for, so it
+// can follow the job again if the connection drops.
+type JobMessage struct {
+	Type string `json:"type"`
+	Job  int64  `json:"job"`
+}
+
 type Environment struct {
 	ID     int64  `json:"id"`
 	Name   string `json:"name"`
@@ -33,22 +37,37 @@ type Environment struct {
 	Status string `json:"status"`
 }
 
+// SendFunc delivers a complete log line.
+type SendFunc func(msg LogMessage) error
+
 type StreamWriter struct {
-	conn       *websocket.Conn
-	source     string
-	stream     string
-	mu         sync.Mutex
-	buf        bytes.Buffer
-	writeDelay time.Duration
-	tee        io.Writer
+	send   SendFunc
+	source string
+	stream string
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	tee    io.Writer
 }
 
-func NewStreamWriter(conn *websocket.Conn, source, stream string) *StreamWriter {
+// NewStreamWriter returns a writer that sends each line to a websocket
+// through its pump.
+func NewStreamWriter(pump *Pump, source, stream string) *StreamWriter {
+	return NewStreamWriterFunc(pumpSender(pump), source, stream)
+}
+
+// NewStreamWriterFunc returns a writer that hands each line to send instead
+// of writing it to a websocket.
+func NewStreamWriterFunc(send SendFunc, source, stream string) *StreamWriter {
 	return &StreamWriter{
-		conn:       conn,
-		source:     source,
-		stream:     stream,
-		writeDelay: 5 * time.Second,
+		send:   send,
+		source: source,
+		stream: stream,
+	}
+}
+
+func pumpSender(pump *Pump) SendFunc {
+	return func(msg LogMessage) error {
+		return pump.Send(msg)
 	}
 }
 
@@ -99,48 +118,32 @@ func (w *StreamWriter) sendLine(data string) error {
 		return nil
 	}
 
-	msg := LogMessage{
+	return w.send(LogMessage{
 		Type:   "log",
 		Source: w.source,
 		Stream: w.stream,
 		Data:   data,
-	}
-
-	encoded, err := json.Marshal(msg)
-	if err != nil {
-		return err
-	}
-
-	w.conn.SetWriteDeadline(time.Now().Add(w.writeDelay))
-	return w.conn.WriteMessage(websocket.TextMessage, encoded)
+	})
 }
 
-func SendComplete(conn *websocket.Conn, env *Environment) error {
-	msg := CompleteMessage{
+func SendComplete(pump *Pump, env *Environment) error {
+	return pump.Send(CompleteMessage{
 		Type:        "complete",
 		Success:     true,
 		Environment: env,
-	}
-	encoded, err := json.Marshal(msg)
-	if err != nil {
-		return err
-	}
-	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
-	return conn.WriteMessage(websocket.TextMessage, encoded)
+	})
+}
+
+func SendJob(pump *Pump, id int64) error {
+	return pump.Send(JobMessage{Type: "job", Job: id})
 }
 
-func SendError(conn *websocket.Conn, errMsg string) error {
-	msg := CompleteMessage{
+func SendError(pump *Pump, errMsg string) error {
+	return pump.Send(CompleteMessage{
 		Type:    "complete",
 		Success: false,
 		Error:   errMsg,
-	}
-	encoded, err := json.Marshal(msg)
-	if err != nil {
-		return err
-	}
-	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
-	return conn.WriteMessage(websocket.TextMessage, encoded)
+	})
 }
 
 type MultiWriter struct {
@@ -159,26 +162,49 @@ func (mw *MultiWriter) Write(p []byte) (n int, err error) {
 }
 
 type WriterFactory struct {
-	conn *websocket.Conn
+	send SendFunc
 	tee  io.Writer
+
+	mu      sync.Mutex
+	writers []*StreamWriter
 }
 
-func NewWriterFactory(conn *websocket.Conn, tee io.Writer) *WriterFactory {
-	return &WriterFactory{conn: conn, tee: tee}
+func NewWriterFactory(pump *Pump, tee io.Writer) *WriterFactory {
+	return NewWriterFactoryFunc(pumpSender(pump), tee)
+}
+
+func NewWriterFactoryFunc(send SendFunc, tee io.Writer) *WriterFactory {
+	return &WriterFactory{send: send, tee: tee}
 }
 
 func (f *WriterFactory) NewWriter(source, stream string) *StreamWriter {
-	sw := NewStreamWriter(f.conn, source, stream)
+	sw := NewStreamWriterFunc(f.send, source, stream)
 	if f.tee != nil {
 		sw.WithTee(f.tee)
 	}
+	f.mu.Lock()
+	f.writers = append(f.writers, sw)
+	f.mu.Unlock()
 	return sw
 }
 
+// Flush sends the partial lines buffered by every writer the factory made.
+func (f *WriterFactory) Flush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, w := range f.writers {
+		w.Flush()
+	}
+}
+
 func (f *WriterFactory) Git() (stdout, stderr *StreamWriter) {
 	return f.NewWriter("git", "stdout"), f.NewWriter("git", "stderr")
 }
 
+func (f *WriterFactory) Files() *StreamWriter {
+	return f.NewWriter("files", "stdout")
+}
+
 func (f *WriterFactory) Docker() (stdout, stderr *StreamWriter) {
 	return f.NewWriter("docker", "stdout"), f.NewWriter("docker", "stderr")
 }
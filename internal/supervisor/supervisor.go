@@ -0,0 +1,368 @@
+package supervisor
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type State string
+
+const (
+	StateRunning    State = "running"
+	StateStopped    State = "stopped"
+	StateExited     State = "exited"
+	StateRestarting State = "restarting"
+)
+
+const (
+	minBackoff  = time.Second
+	maxBackoff  = 30 * time.Second
+	stableAfter = 30 * time.Second
+	stopTimeout = 10 * time.Second
+	maxLogSize  = 10 << 20
+)
+
+// Spec describes a process to run in the background.
+type Spec struct {
+	Name    string
+	Command string
+	Dir     string
+	Env     []string
+	LogPath string
+}
+
+type Status struct {
+	Name      string     `json:"name"`
+	Command   string     `json:"command"`
+	State     State      `json:"state"`
+	PID       int        `json:"pid,omitempty"`
+	Restarts  int        `json:"restarts"`
+	StartedAt *time.Time `json:"startedAt,omitempty"`
+	LastError string     `json:"lastError,omitempty"`
+	LogPath   string     `json:"logPath"`
+}
+
+type key struct {
+	envID int64
+	name  string
+}
+
+// Supervisor runs processes per environment, restarting them when they crash
+// and appending their output to a log file.
+type Supervisor struct {
+	mu       sync.Mutex
+	procs    map[key]*process
+	onChange func(envID int64)
+}
+
+func New(onChange func(envID int64)) *Supervisor {
+	if onChange == nil {
+		onChange = func(int64) {}
+	}
+	return &Supervisor{
+		procs:    make(map[key]*process),
+		onChange: onChange,
+	}
+}
+
+func LogPath(dataDir, name string) string {
+	return filepath.Join(dataDir, "logs", name+".log")
+}
+
+// Start starts a process unless it is already running.
+func (s *Supervisor) Start(envID int64, spec Spec) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := key{envID, spec.Name}
+	if p, ok := s.procs[k]; ok && p.active() {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(spec.LogPath), 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	p := &process{
+		spec:  spec,
+		state: StateRestarting,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	s.procs[k] = p
+	go p.run(func() { s.onChange(envID) })
+	return nil
+}
+
+// Stop stops a process and waits for it to exit.
+func (s *Supervisor) Stop(envID int64, name string) error {
+	s.mu.Lock()
+	p, ok := s.procs[key{envID, name}]
+	s.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("process %q is not running", name)
+	}
+	p.halt()
+	return nil
+}
+
+// StopEnvironment stops all processes of an environment.
+func (s *Supervisor) StopEnvironment(envID int64) {
+	s.mu.Lock()
+	var procs []*process
+	for k, p := range s.procs {
+		if k.envID == envID {
+			procs = append(procs, p)
+		}
+	}
+	s.mu.Unlock()
+
+	stopAll(procs)
+}
+
+// Remove stops all processes of an environment and forgets them.
+func (s *Supervisor) Remove(envID int64) {
+	s.StopEnvironment(envID)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k := range s.procs {
+		if k.envID == envID {
+			delete(s.procs, k)
+		}
+	}
+}
+
+func (s *Supervisor) Shutdown() {
+	s.mu.Lock()
+	var procs []*process
+	for _, p := range s.procs {
+		procs = append(procs, p)
+	}
+	s.mu.Unlock()
+
+	stopAll(procs)
+}
+
+func (s *Supervisor) Status(envID int64) []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var statuses []Status
+	for k, p := range s.procs {
+		if k.envID == envID {
+			statuses = append(statuses, p.status())
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+func stopAll(procs []*process) {
+	var wg sync.WaitGroup
+	for _, p := range procs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.halt()
+		}()
+	}
+	wg.Wait()
+}
+
+type process struct {
+	spec Spec
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+
+	mu        sync.Mutex
+	state     State
+	pid       int
+	restarts  int
+	startedAt time.Time
+	lastError string
+}
+
+func (p *process) active() bool {
+	select {
+	case <-p.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (p *process) halt() {
+	p.once.Do(func() { close(p.stop) })
+	<-p.done
+}
+
+func (p *process) status() Status {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	st := Status{
+		Name:      p.spec.Name,
+		Command:   p.spec.Command,
+		State:     p.state,
+		PID:       p.pid,
+		Restarts:  p.restarts,
+		LastError: p.lastError,
+		LogPath:   p.spec.LogPath,
+	}
+	if p.state == StateRunning {
+		startedAt := p.startedAt
+		st.StartedAt = &startedAt
+	}
+	return st
+}
+
+func (p *process) set(fn func()) {
+	p.mu.Lock()
+	fn()
+	p.mu.Unlock()
+}
+
+func (p *process) run(notify func()) {
+	defer close(p.done)
+
+	backoff := minBackoff
+	for {
+		stopped, err := p.runOnce(notify)
+		if stopped {
+			p.set(func() { p.state, p.pid = StateStopped, 0 })
+			notify()
+			return
+		}
+
+		if err == nil {
+			p.set(func() { p.state, p.pid, p.lastError = StateExited, 0, "" })
+			notify()
+			return
+		}
+
+		p.mu.Lock()
+		if time.Since(p.startedAt) > stableAfter {
+			backoff = minBackoff
+		}
+		p.state, p.pid, p.lastError = StateRestarting, 0, err.Error()
+		p.restarts++
+		p.mu.Unlock()
+		notify()
+
+		select {
+		case <-p.stop:
+			p.set(func() { p.state = StateStopped })
+			notify()
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// runOnce runs the command until it exits or the process is stopped.
+func (p *process) runOnce(notify func()) (bool, error) {
+	rotateLog(p.spec.LogPath)
+	logFile, err := os.OpenFile(p.spec.LogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return false, fmt.Errorf("failed to open log: %w", err)
+	}
+	defer logFile.Close()
+
+	fmt.Fprintf(logFile, "--- piko: starting %s at %s ---\n", p.spec.Name, time.Now().Format(time.RFC3339))
+
+	cmd := exec.Command("sh", "-c", p.spec.Command)
+	cmd.Dir = p.spec.Dir
+	cmd.Env = append(os.Environ(), p.spec.Env...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(logFile, "--- piko: failed to start: %v ---\n", err)
+		p.set(func() { p.startedAt = time.Now() })
+		return false, err
+	}
+
+	p.set(func() {
+		p.state = StateRunning
+		p.pid = cmd.Process.Pid
+		p.startedAt = time.Now()
+	})
+	notify()
+
+	waitCh := make(chan error, 1)
+	go func() { waitCh <- cmd.Wait() }()
+
+	select {
+	case err := <-waitCh:
+		fmt.Fprintf(logFile, "--- piko: %s exited: %v ---\n", p.spec.Name, exitDescription(err))
+		return false, err
+	case <-p.stop:
+		terminate(cmd.Process.Pid, waitCh)
+		fmt.Fprintf(logFile, "--- piko: stopped %s ---\n", p.spec.Name)
+		return true, nil
+	}
+}
+
+// terminate signals the whole process group so children of the shell exit
+// too, escalating to SIGKILL if it doesn't stop in time.
+func terminate(pid int, waitCh <-chan error) {
+	syscall.Kill(-pid, syscall.SIGTERM)
+	select {
+	case <-waitCh:
+	case <-time.After(stopTimeout):
+		syscall.Kill(-pid, syscall.SIGKILL)
+		<-waitCh
+	}
+}
+
+func exitDescription(err error) string {
+	if err == nil {
+		return "status 0"
+	}
+	return err.Error()
+}
+
+func rotateLog(path string) {
+	info, err := os.Stat(path)
+	if err != nil || info.Size() < maxLogSize {
+		return
+	}
+	os.Rename(path, path+".1")
+}
+
+// Tail returns the last n lines of a log file.
+func Tail(path string, n int) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if n <= 0 {
+		return data, nil
+	}
+
+	end := len(data)
+	if end > 0 && data[end-1] == '\n' {
+		end--
+	}
+	count := 0
+	for i := end - 1; i >= 0; i-- {
+		if data[i] == '\n' {
+			count++
+			if count == n {
+				return data[i+1:], nil
+			}
+		}
+	}
+	return data, nil
+}
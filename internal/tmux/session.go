@@ -1,9 +1,11 @@
 package tmux
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"time"
 
@@ -27,8 +29,14 @@ func SessionExists(sessionName string) bool {
 	return err == nil
 }
 
-func CreateSession(sessionName, workDir string) error {
-	output, err := run.Command("tmux", "new-session", "-d", "-s", sessionName, "-c", workDir).
+// CreateSession creates a detached session; env (KEY=value) is set in the
+// session's environment so every window inherits it.
+func CreateSession(sessionName, workDir string, env ...string) error {
+	args := []string{"new-session", "-d", "-s", sessionName, "-c", workDir}
+	for _, v := range env {
+		args = append(args, "-e", v)
+	}
+	output, err := run.Command("tmux", args...).
 		Timeout(tmuxTimeout).
 		CombinedOutput()
 	if err != nil {
@@ -60,6 +68,28 @@ func NewWindow(sessionName, windowName, workDir, command string) error {
 	return nil
 }
 
+// NewWindowWithEnv replaces any window of the same name with a new one whose
+// shell has env set, then types command into it.
+func NewWindowWithEnv(sessionName, windowName, workDir string, env []string, command string) error {
+	target := fmt.Sprintf("%s:%s", sessionName, windowName)
+	run.Command("tmux", "kill-window", "-t", target).Timeout(tmuxTimeout).Run()
+
+	args := []string{"new-window", "-d", "-t", sessionName + ":", "-n", windowName, "-c", workDir}
+	for _, v := range env {
+		args = append(args, "-e", v)
+	}
+	output, err := run.Command("tmux", args...).
+		Timeout(tmuxTimeout).
+		CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to create window: %s: %w", string(output), err)
+	}
+
+	return run.Command("tmux", "send-keys", "-t", target, command, "Enter").
+		Timeout(tmuxTimeout).
+		Run()
+}
+
 func SendKeysToWindow(sessionName, windowName, keys string) error {
 	return run.Command("tmux", "send-keys", "-t", fmt.Sprintf("%s:%s", sessionName, windowName), keys, "Enter").
 		Timeout(tmuxTimeout).
@@ -107,6 +137,41 @@ func KillSession(sessionName string) error {
 		Run()
 }
 
+// SetSessionDir changes the directory new windows of a session start in. The
+// session directory can only be set when attaching, so this attaches a
+// control mode client that exits right away.
+func SetSessionDir(sessionName, dir string) error {
+	output, err := run.Command("tmux", "-C", "attach-session", "-t", sessionName, "-c", dir).
+		Stdin(strings.NewReader("refresh-client\n")).
+		Timeout(tmuxTimeout).
+		CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to set session directory: %s: %w", string(output), err)
+	}
+	return nil
+}
+
+// SetEnvironment sets variables (KEY=value) in a session's environment,
+// inherited by windows created afterwards.
+func SetEnvironment(sessionName string, env []string) error {
+	for _, v := range env {
+		key, value, _ := strings.Cut(v, "=")
+		output, err := run.Command("tmux", "set-environment", "-t", sessionName, key, value).
+			Timeout(tmuxTimeout).
+			CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to set %s: %s: %w", key, string(output), err)
+		}
+	}
+	return nil
+}
+
+func RenameSession(oldName, newName string) error {
+	return run.Command("tmux", "rename-session", "-t", oldName, newName).
+		Timeout(tmuxTimeout).
+		Run()
+}
+
 func ListPikoSessions() ([]string, error) {
 	output, err := run.Command("tmux", "list-sessions", "-F", "#{session_name}").
 		Timeout(tmuxTimeout).
@@ -124,16 +189,50 @@ func ListPikoSessions() ([]string, error) {
 	return sessions, nil
 }
 
+// AttachedSessions reports, per piko session, whether a client is attached.
+// No tmux, or no tmux server, means no sessions; any other failure is an
+// error, since callers can't tell who is attached.
+func AttachedSessions() (map[string]bool, error) {
+	attached := make(map[string]bool)
+	result, err := run.Command("tmux", "list-sessions", "-F", "#{session_name}\t#{session_attached}").
+		Timeout(tmuxTimeout).
+		RunCapture()
+	if errors.Is(err, exec.ErrNotFound) {
+		return attached, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tmux sessions: %w", err)
+	}
+	if result.ExitCode != 0 {
+		stderr := strings.TrimSpace(string(result.Stderr))
+		if strings.HasPrefix(stderr, "no server running") || strings.HasPrefix(stderr, "error connecting to") {
+			return attached, nil
+		}
+		return nil, fmt.Errorf("failed to list tmux sessions: %s", stderr)
+	}
+
+	for line := range strings.SplitSeq(strings.TrimSpace(string(result.Stdout)), "\n") {
+		name, count, ok := strings.Cut(line, "\t")
+		if !ok || !strings.HasPrefix(name, "piko/") {
+			continue
+		}
+		n, _ := strconv.Atoi(count)
+		attached[name] = n > 0
+	}
+	return attached, nil
+}
+
 type SessionConfig struct {
 	SessionName   string
 	WorkDir       string
 	DockerProject string
 	Services      []string
 	Shells        map[string]string
+	Env           []string
 }
 
 func CreateFullSession(cfg SessionConfig) error {
-	if err := CreateSession(cfg.SessionName, cfg.WorkDir); err != nil {
+	if err := CreateSession(cfg.SessionName, cfg.WorkDir, cfg.Env...); err != nil {
 		return err
 	}
 